@@ -0,0 +1,207 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github/akhil/ecommerce-yt/database"
+	"github/akhil/ecommerce-yt/helpers"
+	"github/akhil/ecommerce-yt/models"
+	"github/akhil/ecommerce-yt/roles"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// userExport is the archive returned by a data export
+type userExport struct {
+	Exported_At time.Time            `json:"exported_at"`
+	Profile     userProfile          `json:"profile"`
+	Addresses   []models.Address     `json:"addresses"`
+	Cart        []models.ProductUser `json:"cart"`
+	Orders      []models.Order       `json:"orders"`
+}
+
+// deleteAccountRequest is the body accepted by DeleteMe
+type deleteAccountRequest struct {
+	Current_Password string `json:"current_password" validate:"required"`
+}
+
+// newUserExport builds the data export of user
+func newUserExport(user models.User) userExport {
+	export := userExport{
+		Exported_At: time.Now().UTC(),
+		Profile:     newUserProfile(user),
+		Addresses:   user.Address_Details,
+		Cart:        user.User_Cart,
+		Orders:      user.Order_Status,
+	}
+	if export.Addresses == nil {
+		export.Addresses = make([]models.Address, 0)
+	}
+	if export.Cart == nil {
+		export.Cart = make([]models.ProductUser, 0)
+	}
+	if export.Orders == nil {
+		export.Orders = make([]models.Order, 0)
+	}
+	return export
+}
+
+// sendUserExport answers with the data export of user as a JSON file download
+func sendUserExport(c *gin.Context, user models.User) {
+	helpers.JSONAttachment(c, "user-"+user.User_ID+"-export.json", newUserExport(user))
+}
+
+// deleteAccount anonymizes a user and removes the data that still points at them.
+// actorID is the user who asked for the deletion.
+func deleteAccount(c *gin.Context, user models.User, actorID string) error {
+	if err := database.AnonymizeUser(UserCollection, user.User_ID); err != nil {
+		return err
+	}
+
+	// Bumping the token version above already ends the sessions; deleting them drops the
+	// IPs and user agents they hold
+	if err := database.DeleteSessions(SessionCollection, user.User_ID); err != nil {
+		log.Printf("error deleting sessions of user %s: %v", user.User_ID, err)
+	}
+	if err := database.DeletePasswordResets(PasswordResetCollection, user.User_ID); err != nil {
+		log.Printf("error deleting password resets of user %s: %v", user.User_ID, err)
+	}
+	if user.Email != nil {
+		clearLoginFailures(emailAttemptKey(*user.Email))
+	}
+	phone := ""
+	if user.Phone != nil {
+		phone = *user.Phone
+	}
+	if err := database.DeletePhoneOTPs(PhoneOTPCollection, user.User_ID, phone); err != nil {
+		log.Printf("error deleting phone codes of user %s: %v", user.User_ID, err)
+	}
+
+	recordAudit(c, "account_deleted", actorID, user.User_ID, nil)
+
+	// Scrub last so the entry above loses the client IP too
+	if err := database.ScrubAuditLogs(AuditLogCollection, user.User_ID); err != nil {
+		log.Printf("error scrubbing audit logs of user %s: %v", user.User_ID, err)
+	}
+	return nil
+}
+
+// ExportMe returns everything stored about the authenticated user as a JSON file
+func ExportMe() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		foundUser, ok := findAuthenticatedUser(c, ctx)
+		if !ok {
+			return
+		}
+
+		recordAudit(c, "data_exported", foundUser.User_ID, foundUser.User_ID, nil)
+
+		sendUserExport(c, foundUser)
+	}
+}
+
+// DeleteMe deletes the authenticated user's account. Personal data is erased; orders are kept
+// anonymized for accounting. Staff accounts have to lose their roles first.
+func DeleteMe() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var req deleteAccountRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+		if err := validate.Struct(req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+
+		foundUser, ok := findAuthenticatedUser(c, ctx)
+		if !ok {
+			return
+		}
+
+		if roles.IsStaff(roles.EffectiveRoles(foundUser.Roles, foundUser.IsAdmin)) {
+			helpers.Error(c, http.StatusForbidden, "admin accounts can't be deleted while they hold staff roles")
+			return
+		}
+
+		if !checkCurrentPassword(c, foundUser, req.Current_Password) {
+			return
+		}
+
+		if err := deleteAccount(c, foundUser, foundUser.User_ID); err != nil {
+			helpers.InternalServerError(c, "error deleting account")
+			return
+		}
+		helpers.ClearSessionCookies(c)
+
+		helpers.Success(c, "Account deleted successfully", nil)
+	}
+}
+
+// ExportUser returns everything stored about a user as a JSON file, for data subject requests
+func ExportUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		targetID := c.Param("user_id")
+
+		var foundUser models.User
+		err := UserCollection.FindOne(ctx, bson.M{"user_id": targetID}).Decode(&foundUser)
+		if err != nil {
+			helpers.NotFound(c, "user not found")
+			return
+		}
+
+		recordAudit(c, "data_exported", c.GetString("user_id"), targetID, nil)
+
+		sendUserExport(c, foundUser)
+	}
+}
+
+// DeleteUser deletes a customer account on their behalf, for data subject requests
+func DeleteUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireUserCaller(c) {
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		adminID := c.GetString("user_id")
+		targetID := c.Param("user_id")
+
+		var foundUser models.User
+		err := UserCollection.FindOne(ctx, bson.M{"user_id": targetID, "deleted_at": bson.M{"$exists": false}}).Decode(&foundUser)
+		if err != nil {
+			helpers.NotFound(c, "user not found")
+			return
+		}
+
+		if roles.IsStaff(roles.EffectiveRoles(foundUser.Roles, foundUser.IsAdmin)) {
+			helpers.Error(c, http.StatusForbidden, "admin accounts can't be deleted while they hold staff roles")
+			return
+		}
+
+		if err := deleteAccount(c, foundUser, adminID); err != nil {
+			if err == database.ErrCantFindUser {
+				helpers.NotFound(c, "user not found")
+				return
+			}
+			helpers.InternalServerError(c, "error deleting account")
+			return
+		}
+
+		helpers.Success(c, "Account deleted successfully", gin.H{"user_id": targetID})
+	}
+}
@@ -0,0 +1,327 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github/akhil/ecommerce-yt/database"
+	"github/akhil/ecommerce-yt/helpers"
+	"github/akhil/ecommerce-yt/models"
+	"github/akhil/ecommerce-yt/passwords"
+	"github/akhil/ecommerce-yt/roles"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Invites are valid for 72 hours unless the creating admin asks otherwise
+const defaultInviteTTLHours = 72
+
+// createInviteRequest is the body accepted by CreateAdminInvite
+type createInviteRequest struct {
+	Email            *string `json:"email" validate:"omitempty,email"`
+	Role             string  `json:"role"`
+	Expires_In_Hours int     `json:"expires_in_hours" validate:"omitempty,min=1,max=720"`
+}
+
+// assignRolesRequest is the body accepted by AssignRoles
+type assignRolesRequest struct {
+	Roles []string `json:"roles" validate:"required,min=1"`
+}
+
+// recordAudit stores an audit entry for the current request. Failures are logged, not surfaced.
+// Entries recorded while an admin impersonates the user also name the admin.
+func recordAudit(c *gin.Context, action, actorID, targetID string, details map[string]interface{}) {
+	if impersonatorID := c.GetString("impersonator_id"); impersonatorID != "" {
+		if details == nil {
+			details = map[string]interface{}{}
+		}
+		details["impersonated_by"] = impersonatorID
+	}
+	err := database.RecordAudit(AuditLogCollection, models.AuditLog{
+		Action:    action,
+		Actor_ID:  actorID,
+		Target_ID: targetID,
+		IP:        c.ClientIP(),
+		Details:   details,
+	})
+	if err != nil {
+		log.Printf("error recording audit log %s: %v", action, err)
+	}
+}
+
+// CreateAdminInvite issues a single-use admin invite code. The code is only returned once.
+func CreateAdminInvite() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireUserCaller(c) {
+			return
+		}
+		adminID := c.GetString("user_id")
+
+		var req createInviteRequest
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				helpers.BadRequest(c, err.Error())
+				return
+			}
+		}
+
+		if err := validate.Struct(req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+
+		// Invites grant full admin access unless a narrower role is requested
+		if req.Role == "" {
+			req.Role = roles.SuperAdmin
+		}
+		if !roles.IsValid(req.Role) || req.Role == roles.Customer {
+			helpers.BadRequest(c, "role must be one of the staff roles")
+			return
+		}
+
+		ttlHours := req.Expires_In_Hours
+		if ttlHours == 0 {
+			ttlHours = defaultInviteTTLHours
+		}
+
+		invite, code, err := database.CreateAdminInvite(AdminInviteCollection, adminID, req.Email, req.Role, time.Duration(ttlHours)*time.Hour)
+		if err != nil {
+			helpers.InternalServerError(c, "error creating invite")
+			return
+		}
+
+		recordAudit(c, "admin_invite_created", adminID, invite.Invite_ID.Hex(), map[string]interface{}{
+			"email":      req.Email,
+			"role":       req.Role,
+			"expires_at": invite.Expires_At,
+		})
+
+		helpers.Success(c, "Invite created successfully", gin.H{
+			"invite":      invite,
+			"invite_code": code,
+		})
+	}
+}
+
+// ListAdminInvites returns all admin invites with their status
+func ListAdminInvites() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		invites, err := database.ListAdminInvites(AdminInviteCollection)
+		if err != nil {
+			helpers.InternalServerError(c, "error fetching invites")
+			return
+		}
+
+		helpers.Success(c, "", invites)
+	}
+}
+
+// RevokeAdminInvite revokes an invite that has not been used yet
+func RevokeAdminInvite() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireUserCaller(c) {
+			return
+		}
+		adminID := c.GetString("user_id")
+
+		inviteID, err := primitive.ObjectIDFromHex(c.Param("invite_id"))
+		if err != nil {
+			helpers.BadRequest(c, "invalid invite id")
+			return
+		}
+
+		err = database.RevokeAdminInvite(AdminInviteCollection, inviteID, adminID)
+		if err != nil {
+			switch err {
+			case database.ErrInviteNotFound:
+				helpers.NotFound(c, err.Error())
+			case database.ErrInviteClosed:
+				helpers.BadRequest(c, err.Error())
+			default:
+				helpers.InternalServerError(c, "error revoking invite")
+			}
+			return
+		}
+
+		recordAudit(c, "admin_invite_revoked", adminID, inviteID.Hex(), nil)
+
+		helpers.Success(c, "Invite revoked successfully", nil)
+	}
+}
+
+// ListRoles returns every role with the permissions it grants
+func ListRoles() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		helpers.Success(c, "", roles.All())
+	}
+}
+
+// AssignRoles replaces the roles of a user
+func AssignRoles() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireUserCaller(c) {
+			return
+		}
+		adminID := c.GetString("user_id")
+		targetID := c.Param("user_id")
+
+		var req assignRolesRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+		if err := validate.Struct(req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+
+		for _, role := range req.Roles {
+			if !roles.IsValid(role) {
+				helpers.BadRequest(c, "unknown role: "+role)
+				return
+			}
+		}
+
+		// Admins cannot change their own roles, so the last superadmin can't lock everyone out
+		if targetID == adminID {
+			helpers.Error(c, http.StatusForbidden, "you cannot change your own roles")
+			return
+		}
+
+		userRoles := roles.Normalize(req.Roles)
+		err := database.SetUserRoles(UserCollection, targetID, userRoles, roles.IsStaff(userRoles))
+		if err != nil {
+			if err == database.ErrCantFindUser {
+				helpers.NotFound(c, "user not found")
+				return
+			}
+			helpers.InternalServerError(c, "error updating roles")
+			return
+		}
+
+		recordAudit(c, "user_roles_changed", adminID, targetID, map[string]interface{}{
+			"roles": userRoles,
+		})
+
+		helpers.Success(c, "Roles updated successfully", gin.H{"user_id": targetID, "roles": userRoles})
+	}
+}
+
+// ListAuditLogs returns audit log entries with pagination, optionally filtered by ?action=
+func ListAuditLogs() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		pagination := helpers.GetPaginationParams(c)
+
+		logs, total, err := database.ListAuditLogs(AuditLogCollection, c.Query("action"), pagination.Skip, pagination.PageSize)
+		if err != nil {
+			helpers.InternalServerError(c, "error fetching audit logs")
+			return
+		}
+
+		helpers.PaginatedSuccess(c, logs, total, pagination)
+	}
+}
+
+// ErrAdminExists is returned by BootstrapAdmin when an admin account already exists
+var ErrAdminExists = errors.New("an admin account already exists")
+
+// BootstrapAdmin creates the first admin account. It refuses to run once any admin exists,
+// after which new admins can only join through invites.
+func BootstrapAdmin(email, password, firstName, lastName, phone string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	count, err := UserCollection.CountDocuments(ctx, bson.M{"$or": []bson.M{
+		{"is_admin": true},
+		{"roles": roles.SuperAdmin},
+	}})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrAdminExists
+	}
+
+	user := models.User{
+		First_Name: &firstName,
+		Last_Name:  &lastName,
+		Password:   &password,
+		Email:      &email,
+		Phone:      &phone,
+	}
+	if err := validate.Struct(user); err != nil {
+		return err
+	}
+	if violations := passwords.DefaultPolicy().Check(password, personalInfo(user)...); len(violations) > 0 {
+		return errors.New(violations[0].Message)
+	}
+
+	count, err = UserCollection.CountDocuments(ctx, bson.M{"email": email})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("a user with this email already exists")
+	}
+
+	hashed, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	user.Password = &hashed
+	user.Created_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	user.Updated_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	user.ID = primitive.NewObjectID()
+	user.User_ID = user.ID.Hex()
+	user.IsAdmin = true
+	user.Roles = []string{roles.SuperAdmin}
+	user.User_Cart = make([]models.ProductUser, 0)
+	user.Address_Details = make([]models.Address, 0)
+	user.Order_Status = make([]models.Order, 0)
+
+	if _, err := UserCollection.InsertOne(ctx, user); err != nil {
+		return err
+	}
+
+	err = database.RecordAudit(AuditLogCollection, models.AuditLog{
+		Action:    "admin_bootstrapped",
+		Target_ID: user.User_ID,
+		Details:   map[string]interface{}{"email": email},
+	})
+	if err != nil {
+		log.Printf("error recording audit log admin_bootstrapped: %v", err)
+	}
+
+	return nil
+}
+
+// BootstrapAdminFromEnv creates the first admin from the ADMIN_BOOTSTRAP_* environment
+// variables if they are set and no admin exists yet
+func BootstrapAdminFromEnv() {
+	email := os.Getenv("ADMIN_BOOTSTRAP_EMAIL")
+	password := os.Getenv("ADMIN_BOOTSTRAP_PASSWORD")
+	if email == "" || password == "" {
+		return
+	}
+
+	err := BootstrapAdmin(
+		email,
+		password,
+		os.Getenv("ADMIN_BOOTSTRAP_FIRST_NAME"),
+		os.Getenv("ADMIN_BOOTSTRAP_LAST_NAME"),
+		os.Getenv("ADMIN_BOOTSTRAP_PHONE"),
+	)
+	if err != nil {
+		if err != ErrAdminExists {
+			log.Printf("Error bootstrapping admin account: %v", err)
+		}
+		return
+	}
+	log.Printf("Bootstrapped admin account %s", email)
+}
@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github/akhil/ecommerce-yt/database"
+	"github/akhil/ecommerce-yt/helpers"
+	"github/akhil/ecommerce-yt/roles"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// createAPIKeyRequest is the body accepted by CreateAPIKey
+type createAPIKeyRequest struct {
+	Name            string   `json:"name" validate:"required,min=1,max=100"`
+	Scopes          []string `json:"scopes" validate:"required,min=1"`
+	Expires_In_Days int      `json:"expires_in_days" validate:"omitempty,min=1,max=3650"`
+}
+
+// requireUserCaller answers 403 and returns false when the request was authenticated with an
+// API key. Keys can't manage other keys, invites or roles, impersonate users or change
+// accounts, so a leaked key can't be used to mint new credentials or grant itself access.
+func requireUserCaller(c *gin.Context) bool {
+	if c.GetString("api_key_id") != "" {
+		helpers.Error(c, http.StatusForbidden, "this action requires an admin login, not an API key")
+		return false
+	}
+	return true
+}
+
+// CreateAPIKey issues a named API key limited to the given scopes. The key is only returned once.
+func CreateAPIKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireUserCaller(c) {
+			return
+		}
+		adminID := c.GetString("user_id")
+
+		var req createAPIKeyRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+		if err := validate.Struct(req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+
+		// Scopes are permission names. Admins can only hand out permissions they hold themselves,
+		// and never the ones that create credentials or grant access.
+		callerRoles := c.GetStringSlice("roles")
+		scopes := roles.Normalize(req.Scopes)
+		for _, scope := range scopes {
+			if !roles.IsValidPermission(scope) || roles.IsPrivileged(scope) {
+				helpers.BadRequest(c, "invalid scope: "+scope)
+				return
+			}
+			if !roles.HasPermission(callerRoles, scope) {
+				helpers.Error(c, http.StatusForbidden, "you cannot grant a scope you don't have: "+scope)
+				return
+			}
+		}
+
+		var expiresAt *time.Time
+		if req.Expires_In_Days > 0 {
+			t := time.Now().Add(time.Duration(req.Expires_In_Days) * 24 * time.Hour)
+			expiresAt = &t
+		}
+
+		apiKey, key, err := database.CreateAPIKey(APIKeyCollection, adminID, strings.TrimSpace(req.Name), scopes, expiresAt)
+		if err != nil {
+			helpers.InternalServerError(c, "error creating API key")
+			return
+		}
+
+		recordAudit(c, "api_key_created", adminID, apiKey.Key_ID.Hex(), map[string]interface{}{
+			"name":       apiKey.Name,
+			"scopes":     apiKey.Scopes,
+			"expires_at": apiKey.Expires_At,
+		})
+
+		helpers.Success(c, "API key created successfully, store it now as it won't be shown again", gin.H{
+			"api_key": apiKey,
+			"key":     key,
+		})
+	}
+}
+
+// ListAPIKeys returns all API keys with their scopes and usage, without the keys themselves
+func ListAPIKeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireUserCaller(c) {
+			return
+		}
+
+		keys, err := database.ListAPIKeys(APIKeyCollection)
+		if err != nil {
+			helpers.InternalServerError(c, "error fetching API keys")
+			return
+		}
+
+		helpers.Success(c, "", keys)
+	}
+}
+
+// RevokeAPIKey revokes an API key
+func RevokeAPIKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireUserCaller(c) {
+			return
+		}
+		adminID := c.GetString("user_id")
+
+		keyID, err := primitive.ObjectIDFromHex(c.Param("key_id"))
+		if err != nil {
+			helpers.BadRequest(c, "invalid API key id")
+			return
+		}
+
+		err = database.RevokeAPIKey(APIKeyCollection, keyID, adminID)
+		if err != nil {
+			switch err {
+			case database.ErrAPIKeyNotFound:
+				helpers.NotFound(c, err.Error())
+			case database.ErrAPIKeyRevoked:
+				helpers.BadRequest(c, err.Error())
+			default:
+				helpers.InternalServerError(c, "error revoking API key")
+			}
+			return
+		}
+
+		recordAudit(c, "api_key_revoked", adminID, keyID.Hex(), nil)
+
+		helpers.Success(c, "API key revoked successfully", nil)
+	}
+}
@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github/akhil/ecommerce-yt/roles"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// asAdmin authenticates the request as a superadmin logged in as a user
+func asAdmin(c *gin.Context) {
+	c.Set("user_id", "admin-1")
+	c.Set("roles", []string{roles.SuperAdmin})
+}
+
+// asAPIKey authenticates the request with an API key, as the middleware does
+func asAPIKey(c *gin.Context) {
+	c.Set("user_id", "apikey:key-1")
+	c.Set("api_key_id", "key-1")
+	c.Set("roles", []string{roles.SuperAdmin})
+}
+
+func TestCreateAPIKeyScopes(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	tests := []struct {
+		scope string
+		want  int
+	}{
+		{roles.ProductsWrite, http.StatusOK},
+		{roles.UsersManage, http.StatusOK},
+		{roles.APIKeysManage, http.StatusBadRequest},
+		{roles.InvitesManage, http.StatusBadRequest},
+		{roles.RolesManage, http.StatusBadRequest},
+		{roles.UsersImpersonate, http.StatusBadRequest},
+		{"*", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.scope, func(mt *mtest.T) {
+			useMockCollections(mt)
+			// Storing the key and its audit entry
+			mt.AddMockResponses(mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse())
+
+			body := createAPIKeyRequest{Name: "integration", Scopes: []string{roles.ProductsRead, tt.scope}}
+			w := serve(CreateAPIKey(), http.MethodPost, body, asAdmin)
+			if w.Code != tt.want {
+				mt.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if inserted := len(commandsOn(mt, "insert", "APIKeys")); (tt.want == http.StatusOK) != (inserted == 1) {
+				mt.Errorf("%d keys stored for a %d answer", inserted, w.Code)
+			}
+		})
+	}
+}
+
+func TestAPIKeyCantGrantAccess(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	tests := []struct {
+		name    string
+		handler gin.HandlerFunc
+		params  gin.Params
+		body    interface{}
+	}{
+		{"create invite", CreateAdminInvite(), nil, createInviteRequest{Role: roles.SuperAdmin}},
+		{"revoke invite", RevokeAdminInvite(), gin.Params{{Key: "invite_id", Value: "65f000000000000000000001"}}, nil},
+		{"assign roles", AssignRoles(), gin.Params{{Key: "user_id", Value: "user-2"}}, assignRolesRequest{Roles: []string{roles.SuperAdmin}}},
+		{"unlock user", UnlockUser(), gin.Params{{Key: "user_id", Value: "user-2"}}, nil},
+		{"delete user", DeleteUser(), gin.Params{{Key: "user_id", Value: "user-2"}}, nil},
+		{"impersonate", ImpersonateUser(), gin.Params{{Key: "user_id", Value: "user-2"}}, nil},
+		{"create key", CreateAPIKey(), nil, createAPIKeyRequest{Name: "another", Scopes: []string{roles.ProductsRead}}},
+		{"revoke key", RevokeAPIKey(), gin.Params{{Key: "key_id", Value: "65f000000000000000000002"}}, nil},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			useMockCollections(mt)
+
+			// The key holds every scope the route asks for; being a key is what's refused
+			w := serve(tt.handler, http.MethodPost, tt.body, func(c *gin.Context) {
+				asAPIKey(c)
+				c.Params = tt.params
+			})
+			if w.Code != http.StatusForbidden {
+				mt.Fatalf("status = %d, want %d: %s", w.Code, http.StatusForbidden, w.Body)
+			}
+			var resp struct{ Error string }
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Error == "" {
+				mt.Errorf("response %s has no error message", w.Body)
+			}
+			if events := mt.GetAllStartedEvents(); len(events) != 0 {
+				mt.Errorf("refused request still sent %s to the database", events[0].CommandName)
+			}
+		})
+	}
+
+	// The same request from a logged in admin goes through to the database
+	mt.Run("admin login", func(mt *mtest.T) {
+		useMockCollections(mt)
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}, {Key: "nModified", Value: 1}}, mtest.CreateSuccessResponse())
+
+		w := serve(AssignRoles(), http.MethodPut, assignRolesRequest{Roles: []string{roles.Support}}, func(c *gin.Context) {
+			asAdmin(c)
+			c.Params = gin.Params{{Key: "user_id", Value: "user-2"}}
+		})
+		if w.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+		}
+	})
+}
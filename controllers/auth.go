@@ -0,0 +1,263 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github/akhil/ecommerce-yt/database"
+	"github/akhil/ecommerce-yt/helpers"
+	"github/akhil/ecommerce-yt/models"
+	"github/akhil/ecommerce-yt/roles"
+	generate "github/akhil/ecommerce-yt/tokens"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// refreshRequest is the body accepted by RefreshToken
+type refreshRequest struct {
+	Refresh_Token string `json:"refresh_token"`
+}
+
+// sendLoginTokens answers a successful login, with cookies when the client asked for a cookie session
+func sendLoginTokens(c *gin.Context, userID, token, refreshToken string) {
+	if !helpers.WantsCookieSession(c) {
+		helpers.LoginSuccess(c, userID, token, refreshToken)
+		return
+	}
+
+	csrfToken, err := helpers.SetSessionCookies(c, token, refreshToken)
+	if err != nil {
+		helpers.InternalServerError(c, "error creating session")
+		return
+	}
+	helpers.SessionLoginSuccess(c, "Logged In Successfully", userID, csrfToken)
+}
+
+// RefreshToken exchanges a valid refresh token for a new access/refresh pair of the same session.
+// The presented refresh token is rotated out; presenting it again is treated as
+// token theft and revokes every session of the user.
+func RefreshToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var req refreshRequest
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				helpers.BadRequest(c, err.Error())
+				return
+			}
+		}
+
+		// Cookie sessions send the refresh token as a cookie, which needs CSRF protection
+		fromCookie := false
+		if req.Refresh_Token == "" {
+			if cookie, err := c.Cookie(helpers.RefreshTokenCookie); err == nil && cookie != "" {
+				if !helpers.ValidCSRFToken(c) {
+					helpers.Error(c, http.StatusForbidden, "missing or invalid CSRF token")
+					return
+				}
+				req.Refresh_Token = cookie
+				fromCookie = true
+			}
+		}
+		if req.Refresh_Token == "" {
+			helpers.BadRequest(c, "refresh_token is required")
+			return
+		}
+
+		claims, msg := generate.ValidateRefreshToken(req.Refresh_Token)
+		if msg != "" {
+			helpers.Unauthorized(c, msg)
+			return
+		}
+
+		var foundUser models.User
+		err := UserCollection.FindOne(ctx, bson.M{"user_id": claims.User_ID}).Decode(&foundUser)
+		if err != nil {
+			helpers.Unauthorized(c, "invalid refresh token")
+			return
+		}
+
+		// Tokens issued before a logout from all devices stay revoked
+		if claims.Token_Version < foundUser.Token_Version {
+			helpers.Unauthorized(c, "token has been revoked")
+			return
+		}
+
+		var token, refreshtoken string
+		if claims.Session_ID == "" {
+			token, refreshtoken, err = refreshLegacyToken(c, ctx, foundUser, req.Refresh_Token)
+		} else {
+			token, refreshtoken, err = refreshSessionToken(c, ctx, foundUser, claims.Session_ID, req.Refresh_Token)
+		}
+		if err != nil {
+			switch err {
+			case errRefreshTokenReused:
+				helpers.Unauthorized(c, "refresh token has already been used; all sessions have been revoked")
+			case database.ErrSessionNotFound:
+				helpers.Unauthorized(c, "session has been revoked")
+			default:
+				helpers.InternalServerError(c, "error refreshing tokens")
+			}
+			return
+		}
+
+		if fromCookie {
+			csrfToken, err := helpers.SetSessionCookies(c, token, refreshtoken)
+			if err != nil {
+				helpers.InternalServerError(c, "error creating session")
+				return
+			}
+			helpers.SessionLoginSuccess(c, "Token Refreshed Successfully", foundUser.User_ID, csrfToken)
+			return
+		}
+
+		helpers.RefreshSuccess(c, foundUser.User_ID, token, refreshtoken)
+	}
+}
+
+var errRefreshTokenReused = errors.New("refresh token has already been used")
+
+// refreshSessionToken rotates the refresh token of a session. A correctly signed refresh token
+// that is no longer the session's current one has been replayed, so every session of the user
+// is revoked: the replay may come from the thief or from the user, and only the user's other
+// sessions can tell which.
+func refreshSessionToken(c *gin.Context, ctx context.Context, user models.User, sessionID, refreshToken string) (string, string, error) {
+	session, err := database.FindSession(SessionCollection, user.User_ID, sessionID)
+	if err != nil {
+		return "", "", err
+	}
+
+	presentedHash := generate.HashOpaqueToken(refreshToken)
+	if session.Refresh_Token_Hash != presentedHash {
+		revokeAfterReuse(ctx, user.User_ID)
+		return "", "", errRefreshTokenReused
+	}
+
+	token, newRefreshToken, err := generate.TokenGenerator(*user.Email, *user.First_Name, *user.Last_Name, user.User_ID, sessionID, roles.EffectiveRoles(user.Roles, user.IsAdmin), user.Token_Version)
+	if err != nil {
+		return "", "", err
+	}
+
+	rotated, err := database.RotateSessionRefreshToken(SessionCollection, sessionID, presentedHash, generate.HashOpaqueToken(newRefreshToken), c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		return "", "", err
+	}
+	// Another request rotated the same token first
+	if !rotated {
+		revokeAfterReuse(ctx, user.User_ID)
+		return "", "", errRefreshTokenReused
+	}
+
+	return token, newRefreshToken, nil
+}
+
+// refreshLegacyToken exchanges a refresh token from before sessions existed, which is checked
+// against the pair stored on the user, for the tokens of a new session
+func refreshLegacyToken(c *gin.Context, ctx context.Context, user models.User, refreshToken string) (string, string, error) {
+	if user.Refresh_Token == nil || *user.Refresh_Token != refreshToken {
+		revokeAfterReuse(ctx, user.User_ID)
+		return "", "", errRefreshTokenReused
+	}
+
+	consumed, err := generate.ConsumeStoredRefreshToken(refreshToken, user.User_ID, UserCollection, ctx)
+	if err != nil {
+		return "", "", err
+	}
+	if !consumed {
+		revokeAfterReuse(ctx, user.User_ID)
+		return "", "", errRefreshTokenReused
+	}
+
+	return startSession(c, user)
+}
+
+// revokeAfterReuse revokes all tokens of a user whose rotated refresh token was replayed
+func revokeAfterReuse(ctx context.Context, userID string) {
+	log.Printf("refresh token reuse detected for user %s, revoking all sessions", userID)
+	if err := generate.RevokeAllTokens(userID, UserCollection, ctx); err != nil {
+		log.Printf("error revoking tokens for user %s: %v", userID, err)
+	}
+	database.InvalidateUserAuthState(userID)
+	endAllSessions(userID)
+}
+
+// Logout revokes the access token used for the request and ends its session
+func Logout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		userID, exists := c.Get("user_id")
+		if !exists {
+			helpers.Unauthorized(c, "user not authenticated")
+			return
+		}
+
+		tokenID := c.GetString("token_id")
+		expiresAt := c.GetTime("token_expires_at")
+		if expiresAt.IsZero() {
+			expiresAt = time.Now().Add(24 * time.Hour)
+		}
+
+		if err := generate.RevokeTokenID(tokenID, userID.(string), expiresAt, RevokedTokenCollection, ctx); err != nil {
+			helpers.InternalServerError(c, "error revoking token")
+			return
+		}
+
+		// Tokens from before sessions existed are tracked on the user instead. Impersonation
+		// tokens have neither; revoking the token ends them.
+		if sessionID := c.GetString("session_id"); sessionID != "" {
+			err := database.RevokeSession(SessionCollection, RevokedTokenCollection, userID.(string), sessionID)
+			if err != nil && err != database.ErrSessionNotFound {
+				helpers.InternalServerError(c, "error revoking session")
+				return
+			}
+		} else if c.GetString("impersonator_id") != "" {
+			recordAudit(c, "impersonation_ended", c.GetString("impersonator_id"), userID.(string), nil)
+		} else if err := generate.ClearStoredTokens(userID.(string), UserCollection, ctx); err != nil {
+			helpers.InternalServerError(c, "error revoking refresh token")
+			return
+		}
+		helpers.ClearSessionCookies(c)
+
+		helpers.Success(c, "Logged Out Successfully", nil)
+	}
+}
+
+// LogoutAll revokes every access and refresh token issued to the user
+func LogoutAll() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		userID, exists := c.Get("user_id")
+		if !exists {
+			helpers.Unauthorized(c, "user not authenticated")
+			return
+		}
+
+		if err := generate.RevokeAllTokens(userID.(string), UserCollection, ctx); err != nil {
+			helpers.InternalServerError(c, "error revoking tokens")
+			return
+		}
+		database.InvalidateUserAuthState(userID.(string))
+		endAllSessions(userID.(string))
+		helpers.ClearSessionCookies(c)
+
+		helpers.Success(c, "Logged Out From All Devices Successfully", nil)
+	}
+}
+
+// JWKS publishes the public keys that verify our tokens so other services can validate them
+func JWKS() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, generate.JWKS())
+	}
+}
@@ -0,0 +1,296 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github/akhil/ecommerce-yt/helpers"
+	"github/akhil/ecommerce-yt/roles"
+	generate "github/akhil/ecommerce-yt/tokens"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// useMockCollections points the collections used by the handlers at the mock deployment of mt,
+// so every database call is answered by the responses queued with mt.AddMockResponses
+func useMockCollections(mt *mtest.T) {
+	collections := map[string]**mongo.Collection{
+		"Users":          &UserCollection,
+		"Sessions":       &SessionCollection,
+		"RevokedTokens":  &RevokedTokenCollection,
+		"AdminInvites":   &AdminInviteCollection,
+		"AuditLogs":      &AuditLogCollection,
+		"LoginAttempts":  &LoginAttemptCollection,
+		"APIKeys":        &APIKeyCollection,
+		"PasswordResets": &PasswordResetCollection,
+		"PhoneOTPs":      &PhoneOTPCollection,
+		"Products":       &ProductCollection,
+	}
+	for name, collection := range collections {
+		previous := *collection
+		*collection = mt.DB.Collection(name)
+		mt.Cleanup(func() { *collection = previous })
+	}
+}
+
+// useTestKeys loads a fresh signing key so tokens can be issued and validated
+func useTestKeys(t *testing.T) {
+	t.Helper()
+
+	dir := t.TempDir()
+	data, err := generate.GenerateKeyPEM(generate.AlgEdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "test.pem"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("JWT_KEYS_DIR", dir)
+	t.Setenv("JWT_ACTIVE_KID", "")
+	if err := generate.LoadKeys(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serve runs handler for a request with a JSON body; setup runs first, like the middleware would
+func serve(handler gin.HandlerFunc, method string, body interface{}, setup func(c *gin.Context)) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	data, _ := json.Marshal(body)
+	c.Request = httptest.NewRequest(method, "/", bytes.NewReader(data))
+	c.Request.Header.Set("Content-Type", "application/json")
+	if setup != nil {
+		setup(c)
+	}
+	handler(c)
+	return w
+}
+
+// userDocument is a stored user as the mock deployment returns it
+func userDocument(userID string, tokenVersion int) bson.D {
+	return bson.D{
+		{Key: "user_id", Value: userID},
+		{Key: "email", Value: "jane@example.com"},
+		{Key: "first_name", Value: "Jane"},
+		{Key: "last_name", Value: "Doe"},
+		{Key: "token_version", Value: tokenVersion},
+	}
+}
+
+// commandsOn returns the commands named name that were sent for collection
+func commandsOn(mt *mtest.T, name, collection string) []bson.Raw {
+	var commands []bson.Raw
+	for _, event := range mt.GetAllStartedEvents() {
+		if event.CommandName != name {
+			continue
+		}
+		if target, ok := event.Command.Lookup(name).StringValueOK(); ok && target == collection {
+			commands = append(commands, event.Command)
+		}
+	}
+	return commands
+}
+
+func TestRefreshTokenRotates(t *testing.T) {
+	useTestKeys(t)
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	_, refreshToken, err := generate.TokenGenerator("jane@example.com", "Jane", "Doe", "user-1", "session-1", []string{roles.Customer}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := bson.D{
+		{Key: "session_id", Value: "session-1"},
+		{Key: "user_id", Value: "user-1"},
+		{Key: "refresh_token_hash", Value: generate.HashOpaqueToken(refreshToken)},
+		{Key: "expires_at", Value: time.Now().Add(time.Hour)},
+	}
+
+	mt.Run("current token", func(mt *mtest.T) {
+		useMockCollections(mt)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "test.Users", mtest.FirstBatch, userDocument("user-1", 0)),
+			mtest.CreateCursorResponse(0, "test.Sessions", mtest.FirstBatch, session),
+			bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}, {Key: "nModified", Value: 1}},
+		)
+
+		w := serve(RefreshToken(), http.MethodPost, refreshRequest{Refresh_Token: refreshToken}, nil)
+		if w.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+		}
+		var response helpers.LoginResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			mt.Fatal(err)
+		}
+		if response.Token == "" || response.RefreshToken == "" || response.RefreshToken == refreshToken {
+			mt.Fatalf("response = %+v, want a new token pair", response)
+		}
+
+		// The session only accepts the new refresh token from now on, and only if nobody
+		// rotated the old one first
+		updates := commandsOn(mt, "update", "Sessions")
+		if len(updates) != 1 {
+			mt.Fatalf("%d updates of the session, want 1", len(updates))
+		}
+		update := updates[0].Lookup("updates").Array().Index(0).Value().Document()
+		if hash := update.Lookup("q", "refresh_token_hash").StringValue(); hash != generate.HashOpaqueToken(refreshToken) {
+			mt.Errorf("rotation matches refresh_token_hash %q, want the hash of the presented token", hash)
+		}
+		if hash := update.Lookup("u", "$set", "refresh_token_hash").StringValue(); hash != generate.HashOpaqueToken(response.RefreshToken) {
+			mt.Errorf("stored refresh_token_hash %q, want the hash of the new token", hash)
+		}
+	})
+
+	mt.Run("issued before a logout from all devices", func(mt *mtest.T) {
+		useMockCollections(mt)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "test.Users", mtest.FirstBatch, userDocument("user-1", 1)))
+
+		w := serve(RefreshToken(), http.MethodPost, refreshRequest{Refresh_Token: refreshToken}, nil)
+		if w.Code != http.StatusUnauthorized {
+			mt.Fatalf("status = %d, want %d: %s", w.Code, http.StatusUnauthorized, w.Body)
+		}
+		if len(commandsOn(mt, "update", "Sessions")) != 0 {
+			mt.Error("a revoked refresh token was rotated")
+		}
+	})
+}
+
+func TestRefreshTokenReplayEndsEverySession(t *testing.T) {
+	useTestKeys(t)
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	_, refreshToken, err := generate.TokenGenerator("jane@example.com", "Jane", "Doe", "user-1", "session-1", []string{roles.Customer}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}, {Key: "nModified", Value: 1}}
+
+	tests := []struct {
+		name string
+		// currentHash is the refresh token hash stored on the session
+		currentHash string
+		responses   []bson.D
+	}{
+		{
+			name:        "token already rotated out",
+			currentHash: "hash-of-a-later-token",
+		},
+		{
+			// The stored hash matched, but a concurrent request rotated the token first
+			name:        "token rotated concurrently",
+			currentHash: generate.HashOpaqueToken(refreshToken),
+			responses:   []bson.D{{{Key: "ok", Value: 1}, {Key: "n", Value: 0}, {Key: "nModified", Value: 0}}},
+		},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			useMockCollections(mt)
+			session := bson.D{
+				{Key: "session_id", Value: "session-1"},
+				{Key: "user_id", Value: "user-1"},
+				{Key: "refresh_token_hash", Value: tt.currentHash},
+				{Key: "expires_at", Value: time.Now().Add(time.Hour)},
+			}
+			mt.AddMockResponses(mtest.CreateCursorResponse(0, "test.Users", mtest.FirstBatch, userDocument("user-1", 0)))
+			mt.AddMockResponses(mtest.CreateCursorResponse(0, "test.Sessions", mtest.FirstBatch, session))
+			mt.AddMockResponses(tt.responses...)
+			// Revoking the user's tokens, then ending their sessions
+			mt.AddMockResponses(ok, ok)
+
+			w := serve(RefreshToken(), http.MethodPost, refreshRequest{Refresh_Token: refreshToken}, nil)
+			if w.Code != http.StatusUnauthorized {
+				mt.Fatalf("status = %d, want %d: %s", w.Code, http.StatusUnauthorized, w.Body)
+			}
+
+			// Access tokens of every session die with the token version
+			userUpdates := commandsOn(mt, "update", "Users")
+			if len(userUpdates) != 1 {
+				mt.Fatalf("%d updates of the user, want 1", len(userUpdates))
+			}
+			inc := userUpdates[0].Lookup("updates").Array().Index(0).Value().Document().Lookup("u", "$inc", "token_version")
+			if inc.AsInt64() != 1 {
+				mt.Errorf("user update doesn't bump token_version: %v", userUpdates[0])
+			}
+
+			// The other sessions are ended too, not only the one whose token was replayed
+			var ended bool
+			for _, command := range commandsOn(mt, "update", "Sessions") {
+				update := command.Lookup("updates").Array().Index(0).Value().Document()
+				filter := update.Lookup("q").Document()
+				if _, err := filter.LookupErr("session_id"); err == nil {
+					continue
+				}
+				if filter.Lookup("user_id").StringValue() == "user-1" && update.Lookup("multi").Boolean() {
+					ended = true
+				}
+			}
+			if !ended {
+				mt.Error("the user's other sessions were left running")
+			}
+		})
+	}
+}
+
+func TestLogoutRevokesTheTokenAndItsSession(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("logout", func(mt *mtest.T) {
+		useMockCollections(mt)
+		updated := bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}, {Key: "nModified", Value: 1}}
+		mt.AddMockResponses(mtest.CreateSuccessResponse(), updated, mtest.CreateSuccessResponse())
+
+		w := serve(Logout(), http.MethodPost, nil, func(c *gin.Context) {
+			c.Set("user_id", "user-1")
+			c.Set("token_id", "jti-1")
+			c.Set("session_id", "session-1")
+			c.Set("token_expires_at", time.Now().Add(time.Hour))
+		})
+		if w.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+		}
+
+		// The access token dies with its jti, the session's other access tokens with the
+		// session entry; both are what the middleware looks up
+		var revoked []string
+		for _, command := range commandsOn(mt, "insert", "RevokedTokens") {
+			revoked = append(revoked, command.Lookup("documents").Array().Index(0).Value().Document().Lookup("token_id").StringValue())
+		}
+		if want := []string{"jti-1", generate.SessionRevocationID("session-1")}; !reflect.DeepEqual(revoked, want) {
+			mt.Errorf("revoked %v, want %v", revoked, want)
+		}
+		if len(commandsOn(mt, "update", "Sessions")) != 1 {
+			mt.Error("the session wasn't ended")
+		}
+	})
+
+	mt.Run("logout from all devices", func(mt *mtest.T) {
+		useMockCollections(mt)
+		updated := bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}, {Key: "nModified", Value: 1}}
+		mt.AddMockResponses(updated, updated)
+
+		w := serve(LogoutAll(), http.MethodPost, nil, func(c *gin.Context) { c.Set("user_id", "user-1") })
+		if w.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+		}
+
+		userUpdates := commandsOn(mt, "update", "Users")
+		if len(userUpdates) != 1 || userUpdates[0].Lookup("updates").Array().Index(0).Value().Document().Lookup("u", "$inc", "token_version").AsInt64() != 1 {
+			mt.Errorf("token_version wasn't bumped: %v", userUpdates)
+		}
+		if len(commandsOn(mt, "update", "Sessions")) != 1 {
+			mt.Error("the sessions weren't ended")
+		}
+	})
+}
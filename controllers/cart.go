@@ -10,7 +10,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-// AddToCart adds a product to the user's cart
+// AddToCart adds a product to the user's cart. Products with variants need the sku query parameter.
 func (app *Application) AddToCart() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get user_id from context (set by middleware)
@@ -34,7 +34,7 @@ func (app *Application) AddToCart() gin.HandlerFunc {
 		}
 
 		// Call database function
-		err = database.AddProductToCart(app.ProductCollection, app.UserCollection, productID, userID.(string))
+		err = database.AddProductToCart(app.ProductCollection, app.UserCollection, productID, c.Query("sku"), userID.(string))
 		if err != nil {
 			handleCartError(c, err)
 			return
@@ -44,7 +44,7 @@ func (app *Application) AddToCart() gin.HandlerFunc {
 	}
 }
 
-// RemoveItem removes an item from the user's cart
+// RemoveItem removes an item from the user's cart, or only the variant given by the sku query parameter
 func (app *Application) RemoveItem() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get user_id from context (set by middleware)
@@ -68,7 +68,7 @@ func (app *Application) RemoveItem() gin.HandlerFunc {
 		}
 
 		// Call database function
-		err = database.RemoveProductFromCart(app.UserCollection, productID, userID.(string))
+		err = database.RemoveProductFromCart(app.UserCollection, productID, c.Query("sku"), userID.(string))
 		if err != nil {
 			handleCartError(c, err)
 			return
@@ -122,7 +122,7 @@ func (app *Application) BuyFromCart() gin.HandlerFunc {
 		}
 
 		// Call database function
-		orderID, totalPrice, err := database.BuyItemFromCart(app.UserCollection, userID.(string), paymentMethod)
+		orderID, totalPrice, err := database.BuyItemFromCart(app.ProductCollection, app.UserCollection, userID.(string), paymentMethod)
 		if err != nil {
 			handleCartError(c, err)
 			return
@@ -136,7 +136,7 @@ func (app *Application) BuyFromCart() gin.HandlerFunc {
 	}
 }
 
-// InstantBuy processes an instant purchase without adding to cart
+// InstantBuy processes an instant purchase without adding to cart. Products with variants need the sku query parameter.
 func (app *Application) InstantBuy() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get user_id from context (set by middleware)
@@ -169,7 +169,7 @@ func (app *Application) InstantBuy() gin.HandlerFunc {
 		}
 
 		// Call database function
-		orderID, price, err := database.InstantBuy(app.ProductCollection, app.UserCollection, productID, userID.(string), paymentMethod)
+		orderID, price, err := database.InstantBuy(app.ProductCollection, app.UserCollection, productID, c.Query("sku"), userID.(string), paymentMethod)
 		if err != nil {
 			handleCartError(c, err)
 			return
@@ -202,10 +202,18 @@ func handleCartError(c *gin.Context, err error) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to process order"})
 	case database.ErrProductAlreadyInCart:
 		c.JSON(http.StatusBadRequest, gin.H{"error": "product already in cart"})
-	case database.ErrProductAlreadySold:
-		c.JSON(http.StatusBadRequest, gin.H{"error": "product has already been sold"})
+	case database.ErrCantFindVariant:
+		c.JSON(http.StatusNotFound, gin.H{"error": "variant not found"})
+	case database.ErrVariantRequired:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "choose a variant of this product with the sku parameter"})
+	case database.ErrOutOfStock:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "product is out of stock"})
+	case database.ErrProductArchived:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "product is no longer available"})
 	case database.ErrDuplicateOrder:
 		c.JSON(http.StatusConflict, gin.H{"error": "order already processed"})
+	case database.ErrEmailNotVerified:
+		c.JSON(http.StatusForbidden, gin.H{"error": "verify your email address before placing orders", "code": "email_not_verified"})
 	default:
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 	}
@@ -0,0 +1,367 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github/akhil/ecommerce-yt/database"
+	"github/akhil/ecommerce-yt/helpers"
+	"github/akhil/ecommerce-yt/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Slugs are lowercase words of letters and digits joined by single dashes
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// Products can have up to 20 tags of up to 30 characters each
+const (
+	maxTags      = 20
+	maxTagLength = 30
+)
+
+// createCategoryRequest is the body accepted by CreateCategory
+type createCategoryRequest struct {
+	Name      string `json:"name" validate:"required,min=1,max=100"`
+	Slug      string `json:"slug" validate:"omitempty,max=100"`
+	Parent_ID string `json:"parent_id"`
+}
+
+// updateCategoryRequest is the body accepted by UpdateCategory. Omitted fields are left
+// unchanged; an empty parent_id moves the category to the root.
+type updateCategoryRequest struct {
+	Name      *string `json:"name" validate:"omitempty,min=1,max=100"`
+	Slug      *string `json:"slug" validate:"omitempty,max=100"`
+	Parent_ID *string `json:"parent_id"`
+}
+
+// categoryNode is a category with its subcategories, as returned by ListCategories
+type categoryNode struct {
+	models.Category
+	Children []*categoryNode `json:"children"`
+}
+
+// slugify turns a name into a slug, e.g. "Men's T-Shirts" into "men-s-t-shirts"
+func slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// normalizeTags lowercases and trims tags and drops duplicates
+func normalizeTags(tags []string) ([]string, error) {
+	normalized := make([]string, 0, len(tags))
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || len(tag) > maxTagLength {
+			return nil, errors.New("tags must be between 1 and 30 characters")
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	if len(normalized) > maxTags {
+		return nil, errors.New("a product can have at most 20 tags")
+	}
+	return normalized, nil
+}
+
+// parseCategoryID parses a category id from a request and makes sure the category exists.
+// It answers and returns false on errors.
+func parseCategoryID(c *gin.Context, id string) (primitive.ObjectID, bool) {
+	categoryID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		helpers.BadRequest(c, "invalid category id")
+		return primitive.NilObjectID, false
+	}
+	if _, err := database.FindCategory(CategoryCollection, categoryID); err != nil {
+		if err == database.ErrCantFindCategory {
+			helpers.BadRequest(c, "category not found")
+			return primitive.NilObjectID, false
+		}
+		helpers.InternalServerError(c, "error fetching category")
+		return primitive.NilObjectID, false
+	}
+	return categoryID, true
+}
+
+// classificationFields adds the category and tag changes of a product update to fields. A nil
+// argument leaves that field unchanged; an empty category id or tag list removes it.
+// It answers and returns false on errors.
+func classificationFields(c *gin.Context, fields bson.M, categoryID *string, tags *[]string) bool {
+	if categoryID != nil {
+		if *categoryID == "" {
+			fields["category_id"] = nil
+		} else {
+			id, ok := parseCategoryID(c, *categoryID)
+			if !ok {
+				return false
+			}
+			fields["category_id"] = id
+		}
+	}
+
+	if tags != nil {
+		normalized, err := normalizeTags(*tags)
+		if err != nil {
+			helpers.BadRequest(c, err.Error())
+			return false
+		}
+		if len(normalized) == 0 {
+			fields["tags"] = nil
+		} else {
+			fields["tags"] = normalized
+		}
+	}
+	return true
+}
+
+// categoryTreeIDs returns the ids of the category with the given slug and of all its
+// descendants. It answers and returns false on errors.
+func categoryTreeIDs(c *gin.Context, slug string) ([]primitive.ObjectID, bool) {
+	category, err := database.FindCategoryBySlug(CategoryCollection, slug)
+	if err != nil {
+		handleCategoryError(c, err, "fetching")
+		return nil, false
+	}
+	ids, err := database.CategoryTreeIDs(CategoryCollection, category.Category_ID)
+	if err != nil {
+		helpers.InternalServerError(c, "error fetching categories")
+		return nil, false
+	}
+	return ids, true
+}
+
+// handleCategoryError answers for errors returned by the database category functions
+func handleCategoryError(c *gin.Context, err error, action string) {
+	switch err {
+	case database.ErrCantFindCategory:
+		helpers.NotFound(c, "category not found")
+	case database.ErrDuplicateSlug:
+		helpers.Error(c, http.StatusConflict, err.Error())
+	case database.ErrCategoryInUse, database.ErrCategoryCycle:
+		helpers.BadRequest(c, err.Error())
+	default:
+		helpers.InternalServerError(c, "error "+action+" category")
+	}
+}
+
+// ListCategories returns the category tree
+func ListCategories() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		categories, err := database.ListCategories(CategoryCollection)
+		if err != nil {
+			helpers.InternalServerError(c, "error fetching categories")
+			return
+		}
+
+		nodes := make(map[primitive.ObjectID]*categoryNode, len(categories))
+		for _, category := range categories {
+			nodes[category.Category_ID] = &categoryNode{Category: category, Children: []*categoryNode{}}
+		}
+		roots := []*categoryNode{}
+		for _, category := range categories {
+			node := nodes[category.Category_ID]
+			if category.Parent_ID != nil {
+				if parent, ok := nodes[*category.Parent_ID]; ok {
+					parent.Children = append(parent.Children, node)
+					continue
+				}
+			}
+			roots = append(roots, node)
+		}
+
+		helpers.Success(c, "", roots)
+	}
+}
+
+// GetCategoryProducts returns the products of a category and its subcategories with pagination
+// (excludes sold out and archived products)
+func GetCategoryProducts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		pagination := helpers.GetPaginationParams(c)
+
+		ids, ok := categoryTreeIDs(c, c.Param("slug"))
+		if !ok {
+			return
+		}
+
+		filter := bson.M{
+			"category_id": bson.M{"$in": ids},
+			"archived_at": database.NotArchived,
+			"stock":       bson.M{"$gt": 0},
+		}
+		products, total, err := database.ListProducts(ProductCollection, filter, pagination.Skip, pagination.PageSize)
+		if err != nil {
+			helpers.InternalServerError(c, "error fetching products")
+			return
+		}
+
+		helpers.PaginatedSuccess(c, products, total, pagination)
+	}
+}
+
+// CreateCategory adds a category, below parent_id when given. The slug defaults to one made from the name.
+func CreateCategory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req createCategoryRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+		if err := validate.Struct(req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+
+		name := strings.TrimSpace(req.Name)
+		slug := req.Slug
+		if slug == "" {
+			slug = slugify(name)
+		}
+		if name == "" || !slugPattern.MatchString(slug) {
+			helpers.BadRequest(c, "name must not be blank and slug may only contain lowercase letters, digits and dashes")
+			return
+		}
+
+		var parentID *primitive.ObjectID
+		if req.Parent_ID != "" {
+			id, err := primitive.ObjectIDFromHex(req.Parent_ID)
+			if err != nil {
+				helpers.BadRequest(c, "invalid parent id")
+				return
+			}
+			parentID = &id
+		}
+
+		category, err := database.CreateCategory(CategoryCollection, name, slug, parentID)
+		if err != nil {
+			handleCategoryError(c, err, "creating")
+			return
+		}
+		recordAudit(c, "category_created", c.GetString("user_id"), category.Category_ID.Hex(), map[string]interface{}{
+			"name":      category.Name,
+			"slug":      category.Slug,
+			"parent_id": category.Parent_ID,
+		})
+
+		helpers.Success(c, "Category created successfully", category)
+	}
+}
+
+// UpdateCategory renames a category, changes its slug or moves it, with its subcategories,
+// below another parent
+func UpdateCategory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		categoryID, err := primitive.ObjectIDFromHex(c.Param("category_id"))
+		if err != nil {
+			helpers.BadRequest(c, "invalid category id")
+			return
+		}
+
+		var req updateCategoryRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+		if err := validate.Struct(req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+
+		// Check the new parent before writing anything, so a bad parent doesn't leave the
+		// category renamed
+		var parentID *primitive.ObjectID
+		if req.Parent_ID != nil && *req.Parent_ID != "" {
+			id, err := primitive.ObjectIDFromHex(*req.Parent_ID)
+			if err != nil {
+				helpers.BadRequest(c, "invalid parent id")
+				return
+			}
+			parentID = &id
+			if _, err := database.CategoryAncestors(CategoryCollection, categoryID, parentID); err != nil {
+				handleCategoryError(c, err, "moving")
+				return
+			}
+		}
+
+		fields := bson.M{}
+		if req.Name != nil {
+			name := strings.TrimSpace(*req.Name)
+			if name == "" {
+				helpers.BadRequest(c, "name must not be blank")
+				return
+			}
+			fields["name"] = name
+		}
+		if req.Slug != nil {
+			if !slugPattern.MatchString(*req.Slug) {
+				helpers.BadRequest(c, "slug may only contain lowercase letters, digits and dashes")
+				return
+			}
+			fields["slug"] = *req.Slug
+		}
+		if len(fields) == 0 && req.Parent_ID == nil {
+			helpers.BadRequest(c, "no fields to update")
+			return
+		}
+
+		var category models.Category
+		if len(fields) > 0 {
+			category, err = database.UpdateCategory(CategoryCollection, categoryID, fields)
+			if err != nil {
+				handleCategoryError(c, err, "updating")
+				return
+			}
+		}
+		if req.Parent_ID != nil {
+			category, err = database.MoveCategory(CategoryCollection, categoryID, parentID)
+			if err != nil {
+				handleCategoryError(c, err, "moving")
+				return
+			}
+		}
+
+		recordAudit(c, "category_updated", c.GetString("user_id"), categoryID.Hex(), map[string]interface{}{
+			"name":      req.Name,
+			"slug":      req.Slug,
+			"parent_id": req.Parent_ID,
+		})
+
+		helpers.Success(c, "Category updated successfully", category)
+	}
+}
+
+// DeleteCategory removes a category without subcategories or products
+func DeleteCategory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		categoryID, err := primitive.ObjectIDFromHex(c.Param("category_id"))
+		if err != nil {
+			helpers.BadRequest(c, "invalid category id")
+			return
+		}
+
+		if err := database.DeleteCategory(CategoryCollection, ProductCollection, categoryID); err != nil {
+			handleCategoryError(c, err, "deleting")
+			return
+		}
+		recordAudit(c, "category_deleted", c.GetString("user_id"), categoryID.Hex(), nil)
+
+		helpers.Success(c, "Category deleted successfully", nil)
+	}
+}
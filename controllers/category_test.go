@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Men's T-Shirts", "men-s-t-shirts"},
+		{"  Home   & Garden  ", "home-garden"},
+		{"100% Cotton!", "100-cotton"},
+		{"already-a-slug", "already-a-slug"},
+		{"UPPER case", "upper-case"},
+		// Letters outside a-z are dropped rather than transliterated
+		{"Café Crème", "caf-cr-me"},
+		{"---", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got := slugify(tt.name)
+		if got != tt.want {
+			t.Errorf("slugify(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+		if got != "" && !slugPattern.MatchString(got) {
+			t.Errorf("slugify(%q) = %q, which isn't a valid slug", tt.name, got)
+		}
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	many := make([]string, maxTags+1)
+	for i := range many {
+		many[i] = fmt.Sprintf("tag%d", i)
+	}
+	repeated := append([]string{}, many[:maxTags]...)
+	repeated = append(repeated, strings.ToUpper(many[0]))
+
+	tests := []struct {
+		name    string
+		tags    []string
+		want    []string
+		wantErr bool
+	}{
+		{"no tags", nil, []string{}, false},
+		{"lowercased and trimmed", []string{" Summer ", "COTTON"}, []string{"summer", "cotton"}, false},
+		{"duplicates dropped in order", []string{"sale", "Summer", "SALE", "summer "}, []string{"sale", "summer"}, false},
+		{"blank tag", []string{"sale", "  "}, nil, true},
+		{"longest tag", []string{strings.Repeat("a", maxTagLength)}, []string{strings.Repeat("a", maxTagLength)}, false},
+		{"tag too long", []string{strings.Repeat("a", maxTagLength+1)}, nil, true},
+		{"too many tags", many, nil, true},
+		// The limit applies after duplicates are dropped
+		{"duplicates don't count", repeated, many[:maxTags], false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeTags(tt.tags)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("normalizeTags() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("normalizeTags(%q) = %q, want %q", tt.tags, got, tt.want)
+			}
+		})
+	}
+}
@@ -2,7 +2,6 @@ package controllers
 
 import (
 	"context"
-	"fmt"
 	"log"
 	"net/http"
 	"strconv"
@@ -10,8 +9,11 @@ import (
 
 	"github/akhil/ecommerce-yt/database"
 	"github/akhil/ecommerce-yt/helpers"
+	"github/akhil/ecommerce-yt/mailer"
 	"github/akhil/ecommerce-yt/models"
-	generate "github/akhil/ecommerce-yt/tokens"
+	"github/akhil/ecommerce-yt/passwords"
+	"github/akhil/ecommerce-yt/roles"
+	"github/akhil/ecommerce-yt/sms"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
@@ -19,11 +21,26 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var UserCollection *mongo.Collection = database.UserData(database.Client, "Users")
 var ProductCollection *mongo.Collection = database.ProductData(database.Client, "Products")
+var RevokedTokenCollection *mongo.Collection = database.UserData(database.Client, "RevokedTokens")
+var AdminInviteCollection *mongo.Collection = database.UserData(database.Client, "AdminInvites")
+var AuditLogCollection *mongo.Collection = database.UserData(database.Client, "AuditLogs")
+var PasswordResetCollection *mongo.Collection = database.UserData(database.Client, "PasswordResets")
+var SessionCollection *mongo.Collection = database.UserData(database.Client, "Sessions")
+var LoginAttemptCollection *mongo.Collection = database.UserData(database.Client, "LoginAttempts")
+var APIKeyCollection *mongo.Collection = database.UserData(database.Client, "APIKeys")
+var OIDCStateCollection *mongo.Collection = database.UserData(database.Client, "OIDCStates")
+var PhoneOTPCollection *mongo.Collection = database.UserData(database.Client, "PhoneOTPs")
+var CategoryCollection *mongo.Collection = database.ProductData(database.Client, "Categories")
+
+// Mailer delivers account emails; main replaces it with the one configured by MAIL_DRIVER
+var Mailer mailer.Mailer = &mailer.LogMailer{}
+
+// SMS delivers phone passcodes; main replaces it with the one configured by the environment
+var SMS sms.SMSSender = &sms.LogSender{}
 var validate = validator.New()
 
 type Application struct {
@@ -38,46 +55,45 @@ func NewApplication(productCollection, userCollection *mongo.Collection) *Applic
 	}
 }
 
-func HashPassword(password string) string {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	if err != nil {
-		log.Panic(err)
-	}
-	return string(hashedPassword)
+// HashPassword hashes a password with the configured hasher (argon2id by default)
+func HashPassword(password string) (string, error) {
+	return passwords.Hash(password)
 }
 
-func VerifyPassword(userPassword string, givenPassword string) (bool, string) {
-
-	hashedPassword := []byte(givenPassword)
-	err := bcrypt.CompareHashAndPassword(hashedPassword, []byte(userPassword))
-	if err != nil {
+// VerifyPassword checks userPassword against the stored hash givenPassword. When the password
+// matches a hash made with an outdated algorithm or parameters, such as a legacy bcrypt hash,
+// the stored hash of userID is upgraded.
+func VerifyPassword(userPassword string, givenPassword string, userID string) (bool, string) {
+	// Accounts created through an identity provider have no password until they set one
+	if givenPassword == "" {
 		return false, "password is incorrect"
 	}
-	return true, "password is correct"
-}
 
-// addSoldProductExclusion adds a filter condition to exclude sold products from the given filter.
-// It handles both simple filters and filters with $and conditions.
-func addSoldProductExclusion(filter bson.M, soldProductIDs map[primitive.ObjectID]bool) {
-	if len(soldProductIDs) == 0 {
-		return
+	ok, needsRehash, err := passwords.Verify(userPassword, givenPassword)
+	if err != nil {
+		log.Printf("error verifying password of user %s: %v", userID, err)
 	}
-
-	// Convert sold product IDs map to array
-	soldIDsArray := make([]primitive.ObjectID, 0, len(soldProductIDs))
-	for productID := range soldProductIDs {
-		soldIDsArray = append(soldIDsArray, productID)
+	if !ok {
+		return false, "password is incorrect"
 	}
 
-	exclusion := bson.M{"product_id": bson.M{"$nin": soldIDsArray}}
+	if needsRehash && userID != "" {
+		if hashed, err := HashPassword(userPassword); err != nil {
+			log.Printf("error rehashing password of user %s: %v", userID, err)
+		} else if err := database.RehashPassword(UserCollection, userID, givenPassword, hashed); err != nil {
+			log.Printf("error storing rehashed password of user %s: %v", userID, err)
+		}
+	}
+	return true, "password is correct"
+}
 
-	// If filter already has $and, append to it
-	if andConditions, ok := filter["$and"].([]bson.M); ok {
-		filter["$and"] = append(andConditions, exclusion)
-	} else {
-		// Otherwise, add directly to filter
-		filter["product_id"] = exclusion["product_id"]
+// storedPassword returns the password hash of user, or "" for accounts that only sign in
+// through an identity provider
+func storedPassword(user models.User) string {
+	if user.Password == nil {
+		return ""
 	}
+	return *user.Password
 }
 
 func SignUp() gin.HandlerFunc {
@@ -97,6 +113,9 @@ func SignUp() gin.HandlerFunc {
 			helpers.BadRequest(c, validationErr.Error())
 			return
 		}
+		if !checkPasswordPolicy(c, *user.Password, personalInfo(user)...) {
+			return
+		}
 
 		count, err := UserCollection.CountDocuments(ctx, bson.M{"email": user.Email})
 		if err != nil {
@@ -121,51 +140,76 @@ func SignUp() gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "this phone number is already in use"})
 			return
 		}
-		password := HashPassword(*user.Password)
+		password, err := HashPassword(*user.Password)
+		if err != nil {
+			helpers.InternalServerError(c, "error hashing password")
+			return
+		}
 		user.Password = &password
 
 		user.Created_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		user.Updated_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		user.ID = primitive.NewObjectID()
 		user.User_ID = user.ID.Hex()
-		token, refreshtoken, _ := generate.TokenGenerator(*user.Email, *user.First_Name, *user.Last_Name, user.User_ID)
-		user.Token = &token
-		user.Refresh_Token = &refreshtoken
 		user.User_Cart = make([]models.ProductUser, 0)
 		user.Address_Details = make([]models.Address, 0)
 		user.Order_Status = make([]models.Order, 0)
 		user.IsAdmin = false // Regular user signup
+		user.Roles = []string{roles.Customer}
+		user.Email_Verified = false
+		user.Email_Verified_At = nil
+		user.Phone_Verified = false
+		user.Phone_Verified_At = nil
+		user.MFA_Enabled = false
+		user.MFA_Secret = nil
+		user.MFA_Pending_Secret = nil
+		user.MFA_Recovery_Codes = nil
 		_, insertedErr := UserCollection.InsertOne(ctx, user)
 		if insertedErr != nil {
 			helpers.InternalServerError(c, insertedErr.Error())
 			return
 		}
+
+		// The account exists either way; a failed email can be resent later
+		if err := sendVerificationEmail(ctx, user); err != nil {
+			log.Printf("error sending verification email to user %s: %v", user.User_ID, err)
+		}
 		defer cancel()
 		helpers.Success(c, "Signed Up Successfully", nil)
 	}
 }
 
-// AdminSignUp handles admin registration
+// adminSignUpRequest is the body accepted by AdminSignUp
+type adminSignUpRequest struct {
+	models.User
+	Invite_Code string `json:"invite_code" validate:"required"`
+}
+
+// AdminSignUp handles admin registration. An unused, unexpired invite code issued by an
+// existing admin is required; the code is consumed by the registration.
 func AdminSignUp() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		var user models.User
-		if err := c.BindJSON(&user); err != nil {
+		var req adminSignUpRequest
+		if err := c.BindJSON(&req); err != nil {
 			helpers.BadRequest(c, err.Error())
 			return
 		}
 
-		validationErr := validate.Struct(user)
+		validationErr := validate.Struct(req)
 		if validationErr != nil {
 			helpers.BadRequest(c, validationErr.Error())
 			return
 		}
+		user := req.User
+		if !checkPasswordPolicy(c, *user.Password, personalInfo(user)...) {
+			return
+		}
 
 		count, err := UserCollection.CountDocuments(ctx, bson.M{"email": user.Email})
 		if err != nil {
-			log.Panic(err)
 			helpers.InternalServerError(c, err.Error())
 			return
 		}
@@ -177,7 +221,6 @@ func AdminSignUp() gin.HandlerFunc {
 
 		count, err = UserCollection.CountDocuments(ctx, bson.M{"phone": user.Phone})
 		if err != nil {
-			log.Panic(err)
 			helpers.InternalServerError(c, err.Error())
 			return
 		}
@@ -187,27 +230,68 @@ func AdminSignUp() gin.HandlerFunc {
 			return
 		}
 
-		password := HashPassword(*user.Password)
+		user.ID = primitive.NewObjectID()
+		user.User_ID = user.ID.Hex()
+
+		invite, err := database.RedeemAdminInvite(AdminInviteCollection, req.Invite_Code, *user.Email, user.User_ID)
+		if err != nil {
+			if err == database.ErrInvalidInvite {
+				helpers.Unauthorized(c, err.Error())
+				return
+			}
+			helpers.InternalServerError(c, "error checking invite code")
+			return
+		}
+
+		password, err := HashPassword(*user.Password)
+		if err != nil {
+			if err := database.ReleaseAdminInvite(AdminInviteCollection, invite.Invite_ID); err != nil {
+				log.Printf("error releasing admin invite %s: %v", invite.Invite_ID.Hex(), err)
+			}
+			helpers.InternalServerError(c, "error hashing password")
+			return
+		}
 		user.Password = &password
 
 		user.Created_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		user.Updated_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		user.ID = primitive.NewObjectID()
-		user.User_ID = user.ID.Hex()
 		user.IsAdmin = true
-		token, refreshtoken, _ := generate.TokenGenerator(*user.Email, *user.First_Name, *user.Last_Name, user.User_ID)
-		user.Token = &token
-		user.Refresh_Token = &refreshtoken
+		user.Email_Verified = false
+		user.Email_Verified_At = nil
+		user.Phone_Verified = false
+		user.Phone_Verified_At = nil
+		user.MFA_Enabled = false
+		user.MFA_Secret = nil
+		user.MFA_Pending_Secret = nil
+		user.MFA_Recovery_Codes = nil
+		user.Roles = []string{invite.Role}
+		if invite.Role == "" {
+			// Invites issued before roles existed granted full admin access
+			user.Roles = []string{roles.SuperAdmin}
+		}
 		user.User_Cart = make([]models.ProductUser, 0)
 		user.Address_Details = make([]models.Address, 0)
 		user.Order_Status = make([]models.Order, 0)
 
 		_, insertedErr := UserCollection.InsertOne(ctx, user)
 		if insertedErr != nil {
+			// Give the invite back so the invitee can try again
+			if err := database.ReleaseAdminInvite(AdminInviteCollection, invite.Invite_ID); err != nil {
+				log.Printf("error releasing admin invite %s: %v", invite.Invite_ID.Hex(), err)
+			}
 			helpers.InternalServerError(c, insertedErr.Error())
 			return
 		}
 
+		recordAudit(c, "admin_invite_redeemed", user.User_ID, user.User_ID, map[string]interface{}{
+			"invite_id":  invite.Invite_ID.Hex(),
+			"created_by": invite.Created_By,
+		})
+
+		if err := sendVerificationEmail(ctx, user); err != nil {
+			log.Printf("error sending verification email to user %s: %v", user.User_ID, err)
+		}
+
 		helpers.Success(c, "Admin Signed Up Successfully", nil)
 	}
 }
@@ -222,26 +306,33 @@ func Login() gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err})
 			return
 		}
+		if user.Email == nil || user.Password == nil {
+			helpers.BadRequest(c, "email and password are required")
+			return
+		}
+
+		if !allowLoginAttempt(c, emailAttemptKey(*user.Email), ipAttemptKey(c.ClientIP())) {
+			return
+		}
 
 		var foundUser models.User
 		err := UserCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&foundUser)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "email or password is incorrect"})
+			recordPasswordFailure(c, *user.Email, "")
+			helpers.Unauthorized(c, "email or password is incorrect")
 			return
 		}
 
-		PasssordIsValid, msg := VerifyPassword(*user.Password, *foundUser.Password)
+		PasssordIsValid, _ := VerifyPassword(*user.Password, storedPassword(foundUser), foundUser.User_ID)
 
 		if !PasssordIsValid {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
-			fmt.Println(msg)
+			recordPasswordFailure(c, *user.Email, foundUser.User_ID)
+			helpers.Unauthorized(c, "email or password is incorrect")
 			return
 		}
-		token, refreshtoken, _ := generate.TokenGenerator(*foundUser.Email, *foundUser.First_Name, *foundUser.Last_Name, foundUser.User_ID)
-
-		generate.UpdateAllTokens(token, refreshtoken, foundUser.User_ID, UserCollection, ctx)
+		clearLoginFailures(emailAttemptKey(*user.Email))
 
-		helpers.LoginSuccess(c, foundUser.User_ID, token, refreshtoken)
+		completeLogin(c, foundUser)
 	}
 }
 
@@ -256,34 +347,37 @@ func AdminLogin() gin.HandlerFunc {
 			helpers.BadRequest(c, err.Error())
 			return
 		}
+		if user.Email == nil || user.Password == nil {
+			helpers.BadRequest(c, "email and password are required")
+			return
+		}
+
+		if !allowLoginAttempt(c, emailAttemptKey(*user.Email), ipAttemptKey(c.ClientIP())) {
+			return
+		}
 
 		var foundUser models.User
 		err := UserCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&foundUser)
 		if err != nil {
+			recordPasswordFailure(c, *user.Email, "")
 			helpers.Unauthorized(c, "email or password is incorrect")
 			return
 		}
 
-		// Check if user is an admin
-		if !foundUser.IsAdmin {
-			helpers.Unauthorized(c, "access denied: admin privileges required")
-			return
-		}
-
-		PasswordIsValid, msg := VerifyPassword(*user.Password, *foundUser.Password)
+		PasswordIsValid, _ := VerifyPassword(*user.Password, storedPassword(foundUser), foundUser.User_ID)
 		defer cancel()
 
-		if !PasswordIsValid {
-			helpers.Unauthorized(c, msg)
-			fmt.Println(msg)
+		// Customers get the same answer as a wrong password, so the endpoint doesn't reveal
+		// which accounts are staff
+		if !PasswordIsValid || !roles.IsStaff(roles.EffectiveRoles(foundUser.Roles, foundUser.IsAdmin)) {
+			recordPasswordFailure(c, *user.Email, foundUser.User_ID)
+			helpers.Unauthorized(c, "email or password is incorrect")
 			return
 		}
+		clearLoginFailures(emailAttemptKey(*user.Email))
 
-		token, refreshtoken, _ := generate.TokenGenerator(*foundUser.Email, *foundUser.First_Name, *foundUser.Last_Name, foundUser.User_ID)
-
-		generate.UpdateAllTokens(token, refreshtoken, foundUser.User_ID, UserCollection, ctx)
-
-		helpers.LoginSuccess(c, foundUser.User_ID, token, refreshtoken)
+		// Admin accounts always need a second factor
+		completeLogin(c, foundUser)
 	}
 }
 
@@ -294,7 +388,7 @@ func ProductViewerAdmin() gin.HandlerFunc {
 
 		var products models.Product
 		if err := c.BindJSON(&products); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err})
+			helpers.BadRequest(c, err.Error())
 			return
 		}
 
@@ -304,10 +398,38 @@ func ProductViewerAdmin() gin.HandlerFunc {
 			return
 		}
 
+		if err := prepareVariants(&products); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		tags, err := normalizeTags(products.Tags)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		products.Tags = tags
+		if len(tags) == 0 {
+			products.Tags = nil
+		}
+		if products.Category_ID != nil {
+			if _, ok := parseCategoryID(c, products.Category_ID.Hex()); !ok {
+				return
+			}
+		}
+
 		products.Product_ID = primitive.NewObjectID()
+		// Products created without a stock are one of a kind
+		if products.Stock == nil {
+			stock := int64(1)
+			products.Stock = &stock
+		}
 		_, insertedErr := ProductCollection.InsertOne(ctx, products)
+		if mongo.IsDuplicateKeyError(insertedErr) {
+			c.JSON(http.StatusConflict, gin.H{"error": database.ErrDuplicateSKU.Error()})
+			return
+		}
 		if insertedErr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": insertedErr})
+			helpers.InternalServerError(c, "error creating product")
 			return
 		}
 
@@ -320,7 +442,7 @@ func ProductViewerAdmin() gin.HandlerFunc {
 }
 
 // GetAllProducts returns all products with pagination (accessible to all authenticated users)
-// Excludes products that have been sold
+// Excludes products that are sold out or archived
 func GetAllProducts() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
@@ -329,18 +451,10 @@ func GetAllProducts() gin.HandlerFunc {
 		// Get pagination parameters
 		pagination := helpers.GetPaginationParams(c)
 
-		// Get all sold product IDs
-		soldProductIDs, err := database.GetSoldProductIDs(UserCollection)
-		if err != nil {
-			helpers.InternalServerError(c, "error fetching sold products")
-			return
-		}
-
-		// Build filter to exclude sold products
-		filter := bson.M{}
-		addSoldProductExclusion(filter, soldProductIDs)
+		// Build filter to exclude sold out and archived products
+		filter := bson.M{"archived_at": database.NotArchived, "stock": bson.M{"$gt": 0}}
 
-		// Get total count of available products (not sold)
+		// Get total count of available products
 		total, err := ProductCollection.CountDocuments(ctx, filter)
 		if err != nil {
 			helpers.InternalServerError(c, "error counting products")
@@ -371,7 +485,7 @@ func GetAllProducts() gin.HandlerFunc {
 	}
 }
 
-// SearchProduct searches products by name (excludes sold products)
+// SearchProduct searches products by name (excludes sold out and archived products)
 func SearchProduct() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
@@ -384,19 +498,16 @@ func SearchProduct() gin.HandlerFunc {
 			return
 		}
 
-		// Get all sold product IDs
-		soldProductIDs, err := database.GetSoldProductIDs(UserCollection)
-		if err != nil {
-			helpers.InternalServerError(c, "error fetching sold products")
-			return
+		// Create Mongodb filter for text search. Variants are found through their parent product, also by SKU.
+		filter := bson.M{
+			"$or": []bson.M{
+				{"product_name": bson.M{"$regex": query, "$options": "i"}},
+				{"variants.sku": bson.M{"$regex": query, "$options": "i"}},
+			},
+			"archived_at": database.NotArchived,
+			"stock":       bson.M{"$gt": 0},
 		}
 
-		// Create Mongodb filter for text search
-		filter := bson.M{"product_name": bson.M{"$regex": query, "$options": "i"}}
-
-		// Exclude sold products from search results
-		addSoldProductExclusion(filter, soldProductIDs)
-
 		// Query Mongodb
 		cursor, err := ProductCollection.Find(ctx, filter)
 		if err != nil {
@@ -425,11 +536,13 @@ func SearchProduct() gin.HandlerFunc {
 
 }
 
-// SearchProductByQuery searches products by name and/or price range (excludes sold products)
+// SearchProductByQuery searches products by name and/or price range (excludes sold out and archived products)
 // Query parameters:
-//   - search: optional product name search (case-insensitive regex)
+//   - search: optional product name or variant SKU search (case-insensitive regex)
 //   - min_price: optional minimum price (numeric)
 //   - max_price: optional maximum price (numeric)
+//   - category: optional category slug; products in its subcategories match too
+//   - tag: optional tag, may be repeated to require every tag
 //
 // At least one parameter must be provided
 func SearchProductByQuery() gin.HandlerFunc {
@@ -441,31 +554,42 @@ func SearchProductByQuery() gin.HandlerFunc {
 		query := c.Query("search")
 		minPriceStr := c.Query("min_price")
 		maxPriceStr := c.Query("max_price")
-
-		// Validate that at least one search parameter is provided
-		if query == "" && minPriceStr == "" && maxPriceStr == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "at least one search parameter is required (search, min_price, or max_price)"})
+		categorySlug := c.Query("category")
+		tags, err := normalizeTags(c.QueryArray("tag"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		// Get all sold product IDs
-		soldProductIDs, err := database.GetSoldProductIDs(UserCollection)
-		if err != nil {
-			helpers.InternalServerError(c, "error fetching sold products")
+		// Validate that at least one search parameter is provided
+		if query == "" && minPriceStr == "" && maxPriceStr == "" && categorySlug == "" && len(tags) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "at least one search parameter is required (search, min_price, max_price, category, or tag)"})
 			return
 		}
 
 		// Build filter conditions
 		andConditions := []bson.M{}
 
-		// Add product name search if provided
+		// Add product name or variant SKU search if provided
 		if query != "" {
-			andConditions = append(andConditions, bson.M{
-				"product_name": bson.M{
-					"$regex":   query,
-					"$options": "i",
-				},
-			})
+			andConditions = append(andConditions, bson.M{"$or": []bson.M{
+				{"product_name": bson.M{"$regex": query, "$options": "i"}},
+				{"variants.sku": bson.M{"$regex": query, "$options": "i"}},
+			}})
+		}
+
+		// Add category filter, covering its subcategories, if provided
+		if categorySlug != "" {
+			categoryIDs, ok := categoryTreeIDs(c, categorySlug)
+			if !ok {
+				return
+			}
+			andConditions = append(andConditions, bson.M{"category_id": bson.M{"$in": categoryIDs}})
+		}
+
+		// Add tag filter if provided; products must have every tag
+		if len(tags) > 0 {
+			andConditions = append(andConditions, bson.M{"tags": bson.M{"$all": tags}})
 		}
 
 		// Add price range filter if min_price or max_price is provided
@@ -497,24 +621,25 @@ func SearchProductByQuery() gin.HandlerFunc {
 			}
 		}
 
-		// Add price filter if it has any conditions
+		// Add price filter if it has any conditions. A product with variants matches when
+		// one of its variants is in the price range.
 		if len(priceFilter) > 0 {
-			andConditions = append(andConditions, bson.M{"price": priceFilter})
+			andConditions = append(andConditions, bson.M{"$or": []bson.M{
+				{"variants.0": bson.M{"$exists": false}, "price": priceFilter},
+				{"variants": bson.M{"$elemMatch": bson.M{"price": priceFilter}}},
+			}})
 		}
 
-		// Build final filter
-		filter := bson.M{}
+		// Build final filter, leaving out sold out and archived products
+		filter := bson.M{"archived_at": database.NotArchived, "stock": bson.M{"$gt": 0}}
 		if len(andConditions) > 0 {
 			filter["$and"] = andConditions
 		}
 
-		// Exclude sold products from search results
-		addSoldProductExclusion(filter, soldProductIDs)
-
 		// Get pagination parameters
 		pagination := helpers.GetPaginationParams(c)
 
-		// Get total count of matching products (not sold)
+		// Get total count of matching products
 		total, err := ProductCollection.CountDocuments(ctx, filter)
 		if err != nil {
 			helpers.InternalServerError(c, "error counting products")
@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github/akhil/ecommerce-yt/helpers"
+	"github/akhil/ecommerce-yt/models"
+	"github/akhil/ecommerce-yt/roles"
+	generate "github/akhil/ecommerce-yt/tokens"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Impersonation tokens last 15 minutes unless the admin asks for less or up to an hour
+const defaultImpersonationMinutes = 15
+
+// impersonateRequest is the body accepted by ImpersonateUser
+type impersonateRequest struct {
+	Reason             string `json:"reason" validate:"required,min=3,max=500"`
+	Expires_In_Minutes int    `json:"expires_in_minutes" validate:"omitempty,min=1,max=60"`
+}
+
+// ImpersonateUser issues a short-lived access token that lets the admin act as a customer.
+// The token names the admin in its act claim; every request made with it is audited and
+// sensitive actions are refused.
+func ImpersonateUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		if !requireUserCaller(c) {
+			return
+		}
+		adminID := c.GetString("user_id")
+		targetID := c.Param("user_id")
+
+		var req impersonateRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+		if err := validate.Struct(req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+
+		if targetID == adminID {
+			helpers.BadRequest(c, "you cannot impersonate yourself")
+			return
+		}
+
+		var foundUser models.User
+		err := UserCollection.FindOne(ctx, bson.M{"user_id": targetID, "deleted_at": bson.M{"$exists": false}}).Decode(&foundUser)
+		if err != nil {
+			helpers.NotFound(c, "user not found")
+			return
+		}
+
+		// Acting as staff would hand out their permissions
+		userRoles := roles.EffectiveRoles(foundUser.Roles, foundUser.IsAdmin)
+		if roles.IsStaff(userRoles) {
+			helpers.Error(c, http.StatusForbidden, "staff accounts cannot be impersonated")
+			return
+		}
+
+		minutes := req.Expires_In_Minutes
+		if minutes == 0 {
+			minutes = defaultImpersonationMinutes
+		}
+
+		token, expiresAt, err := generate.GenerateImpersonationToken(*foundUser.Email, *foundUser.First_Name, *foundUser.Last_Name,
+			foundUser.User_ID, userRoles, foundUser.Token_Version,
+			generate.Actor{Sub: adminID, Email: c.GetString("email")}, time.Duration(minutes)*time.Minute)
+		if err != nil {
+			helpers.InternalServerError(c, "error generating token")
+			return
+		}
+
+		recordAudit(c, "impersonation_started", adminID, foundUser.User_ID, map[string]interface{}{
+			"reason":     req.Reason,
+			"expires_at": expiresAt,
+		})
+
+		helpers.Success(c, "Impersonation token issued", gin.H{
+			"user_id":    foundUser.User_ID,
+			"token":      token,
+			"expires_at": expiresAt,
+		})
+	}
+}
@@ -0,0 +1,487 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github/akhil/ecommerce-yt/database"
+	"github/akhil/ecommerce-yt/helpers"
+	"github/akhil/ecommerce-yt/mfa"
+	"github/akhil/ecommerce-yt/models"
+	"github/akhil/ecommerce-yt/roles"
+	generate "github/akhil/ecommerce-yt/tokens"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Number of recovery codes handed out when MFA is enabled or the codes are regenerated
+const recoveryCodeCount = 10
+
+var errInvalidMFACode = errors.New("invalid two-factor code")
+
+// mfaLoginRequest is the body accepted by LoginMFA
+type mfaLoginRequest struct {
+	MFA_Token     string `json:"mfa_token" validate:"required"`
+	Code          string `json:"code" validate:"required_without=Recovery_Code"`
+	Recovery_Code string `json:"recovery_code"`
+}
+
+// mfaSetupRequest is the body accepted by LoginMFASetup
+type mfaSetupRequest struct {
+	MFA_Token string `json:"mfa_token" validate:"required"`
+}
+
+// mfaActivateRequest is the body accepted by LoginMFAActivate and ActivateMFA.
+// MFA_Token is only used during login.
+type mfaActivateRequest struct {
+	MFA_Token string `json:"mfa_token"`
+	Code      string `json:"code" validate:"required"`
+}
+
+// mfaCodeRequest is the body accepted by DisableMFA and RegenerateRecoveryCodes
+type mfaCodeRequest struct {
+	Code          string `json:"code" validate:"required_without=Recovery_Code"`
+	Recovery_Code string `json:"recovery_code"`
+}
+
+// mfaIssuer is the account issuer shown in authenticator apps, from MFA_ISSUER
+func mfaIssuer() string {
+	if issuer := os.Getenv("MFA_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "Ecommerce"
+}
+
+// mfaRequired reports whether the user must use MFA. It is mandatory for staff accounts.
+func mfaRequired(user models.User) bool {
+	return roles.IsStaff(roles.EffectiveRoles(user.Roles, user.IsAdmin))
+}
+
+// completeLogin finishes a login whose password has been checked. Users with MFA get an
+// MFA pending token instead of real tokens; staff without MFA must set it up first.
+func completeLogin(c *gin.Context, foundUser models.User) {
+	if foundUser.MFA_Enabled || mfaRequired(foundUser) {
+		tokenType := generate.MFAPendingTokenType
+		if !foundUser.MFA_Enabled {
+			tokenType = generate.MFASetupTokenType
+		}
+
+		mfaToken, err := generate.GenerateMFAToken(foundUser.User_ID, tokenType, foundUser.Token_Version)
+		if err != nil {
+			helpers.InternalServerError(c, "error generating tokens")
+			return
+		}
+		helpers.MFAChallenge(c, mfaToken, !foundUser.MFA_Enabled)
+		return
+	}
+
+	issueLoginTokens(c, foundUser)
+}
+
+// issueLoginTokens starts a session for the login and sends its access and refresh tokens
+func issueLoginTokens(c *gin.Context, foundUser models.User) {
+	token, refreshtoken, err := startSession(c, foundUser)
+	if err != nil {
+		helpers.InternalServerError(c, "error generating tokens")
+		return
+	}
+
+	sendLoginTokens(c, foundUser.User_ID, token, refreshtoken)
+}
+
+// findMFATokenUser validates an MFA token of the given type and loads its user
+func findMFATokenUser(ctx context.Context, mfaToken, tokenType string) (models.User, bool) {
+	var foundUser models.User
+
+	claims, msg := generate.ValidateMFAToken(mfaToken, tokenType)
+	if msg != "" {
+		return foundUser, false
+	}
+
+	err := UserCollection.FindOne(ctx, bson.M{"user_id": claims.User_ID}).Decode(&foundUser)
+	if err != nil {
+		return foundUser, false
+	}
+
+	// A password reset or logout from all devices cancels unfinished logins
+	if claims.Token_Version < foundUser.Token_Version {
+		return foundUser, false
+	}
+
+	return foundUser, true
+}
+
+// findAuthenticatedUser loads the user of an authenticated request
+func findAuthenticatedUser(c *gin.Context, ctx context.Context) (models.User, bool) {
+	var foundUser models.User
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		helpers.Unauthorized(c, "user not authenticated")
+		return foundUser, false
+	}
+
+	err := UserCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&foundUser)
+	if err != nil {
+		helpers.NotFound(c, "user not found")
+		return foundUser, false
+	}
+
+	return foundUser, true
+}
+
+// verifySecondFactor checks a TOTP code or, when given, a recovery code. Both can only be used once.
+func verifySecondFactor(user models.User, code, recoveryCode string) error {
+	if recoveryCode != "" {
+		hash := generate.HashOpaqueToken(mfa.NormalizeRecoveryCode(recoveryCode))
+		used, err := database.UseRecoveryCode(UserCollection, user.User_ID, hash)
+		if err != nil {
+			return err
+		}
+		if !used {
+			return errInvalidMFACode
+		}
+		return nil
+	}
+
+	if user.MFA_Secret == nil {
+		return errInvalidMFACode
+	}
+	step, ok := mfa.ValidateCode(*user.MFA_Secret, code, time.Now())
+	if !ok {
+		return errInvalidMFACode
+	}
+
+	err := database.UseMFAStep(UserCollection, user.User_ID, step)
+	if err == database.ErrMFACodeReused {
+		return errInvalidMFACode
+	}
+	return err
+}
+
+// respondSecondFactorError answers a failed verifySecondFactor
+func respondSecondFactorError(c *gin.Context, err error) {
+	if err == errInvalidMFACode {
+		helpers.Unauthorized(c, "invalid or already used two-factor code")
+		return
+	}
+	helpers.InternalServerError(c, "error checking two-factor code")
+}
+
+// newRecoveryCodes generates a set of recovery codes and the hashes that are stored in their place
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := mfa.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = generate.HashOpaqueToken(mfa.NormalizeRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// startMFASetup creates a pending secret for the user and sends its provisioning URI
+func startMFASetup(c *gin.Context, user models.User) {
+	if user.MFA_Enabled {
+		helpers.BadRequest(c, "two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := mfa.GenerateSecret()
+	if err != nil {
+		helpers.InternalServerError(c, "error generating secret")
+		return
+	}
+
+	if err := database.SetPendingMFASecret(UserCollection, user.User_ID, secret); err != nil {
+		helpers.InternalServerError(c, "error starting two-factor setup")
+		return
+	}
+
+	helpers.Success(c, "Scan the QR code with your authenticator app, then confirm a code to enable two-factor authentication", gin.H{
+		"secret":           secret,
+		"provisioning_uri": mfa.ProvisioningURI(secret, mfaIssuer(), *user.Email),
+	})
+}
+
+// activateMFA enables MFA once the user proves their app produces codes for the pending secret.
+// The recovery codes are only shown in this response.
+func activateMFA(c *gin.Context, user models.User, code string) {
+	if user.MFA_Enabled {
+		helpers.BadRequest(c, "two-factor authentication is already enabled")
+		return
+	}
+	if user.MFA_Pending_Secret == nil {
+		helpers.BadRequest(c, database.ErrMFANotPending.Error())
+		return
+	}
+
+	step, ok := mfa.ValidateCode(*user.MFA_Pending_Secret, code, time.Now())
+	if !ok {
+		helpers.Unauthorized(c, "invalid two-factor code")
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		helpers.InternalServerError(c, "error generating recovery codes")
+		return
+	}
+
+	err = database.EnableMFA(UserCollection, user.User_ID, *user.MFA_Pending_Secret, hashes, step)
+	if err != nil {
+		if err == database.ErrMFANotPending {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+		helpers.InternalServerError(c, "error enabling two-factor authentication")
+		return
+	}
+
+	recordAudit(c, "mfa_enabled", user.User_ID, user.User_ID, nil)
+
+	helpers.Success(c, "Two-factor authentication enabled. Store these recovery codes somewhere safe; each works once.", gin.H{
+		"recovery_codes": codes,
+	})
+}
+
+// LoginMFA finishes a login by exchanging an MFA pending token and a valid code for tokens
+func LoginMFA() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var req mfaLoginRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+		if err := validate.Struct(req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+
+		foundUser, ok := findMFATokenUser(ctx, req.MFA_Token, generate.MFAPendingTokenType)
+		if !ok || !foundUser.MFA_Enabled {
+			helpers.Unauthorized(c, "login session is invalid or has expired, please log in again")
+			return
+		}
+
+		// Codes are short, so guesses are throttled like passwords
+		attemptKey := mfaAttemptKey(foundUser.User_ID)
+		if !allowLoginAttempt(c, attemptKey) {
+			return
+		}
+
+		if err := verifySecondFactor(foundUser, req.Code, req.Recovery_Code); err != nil {
+			if err == errInvalidMFACode {
+				recordLoginFailure(c, attemptKey, loginThrottleFromEnv().MaxFailures, foundUser.User_ID)
+			}
+			respondSecondFactorError(c, err)
+			return
+		}
+		clearLoginFailures(attemptKey)
+
+		if req.Recovery_Code != "" {
+			recordAudit(c, "mfa_recovery_code_used", foundUser.User_ID, foundUser.User_ID, map[string]interface{}{
+				"remaining": len(foundUser.MFA_Recovery_Codes) - 1,
+			})
+		}
+
+		issueLoginTokens(c, foundUser)
+	}
+}
+
+// LoginMFASetup starts MFA enrollment for a staff account that logged in without it
+func LoginMFASetup() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var req mfaSetupRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+		if err := validate.Struct(req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+
+		foundUser, ok := findMFATokenUser(ctx, req.MFA_Token, generate.MFASetupTokenType)
+		if !ok {
+			helpers.Unauthorized(c, "login session is invalid or has expired, please log in again")
+			return
+		}
+
+		startMFASetup(c, foundUser)
+	}
+}
+
+// LoginMFAActivate enables MFA for a staff account during login. The user then logs in again with a code.
+func LoginMFAActivate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var req mfaActivateRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+		if err := validate.Struct(req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+
+		foundUser, ok := findMFATokenUser(ctx, req.MFA_Token, generate.MFASetupTokenType)
+		if !ok {
+			helpers.Unauthorized(c, "login session is invalid or has expired, please log in again")
+			return
+		}
+
+		activateMFA(c, foundUser, req.Code)
+	}
+}
+
+// SetupMFA starts MFA enrollment for the authenticated user
+func SetupMFA() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		foundUser, ok := findAuthenticatedUser(c, ctx)
+		if !ok {
+			return
+		}
+
+		startMFASetup(c, foundUser)
+	}
+}
+
+// ActivateMFA enables MFA for the authenticated user with a code from the pending secret
+func ActivateMFA() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var req mfaActivateRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+		if err := validate.Struct(req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+
+		foundUser, ok := findAuthenticatedUser(c, ctx)
+		if !ok {
+			return
+		}
+
+		activateMFA(c, foundUser, req.Code)
+	}
+}
+
+// DisableMFA turns MFA off for the authenticated user. Staff accounts can't turn it off.
+func DisableMFA() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var req mfaCodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+		if err := validate.Struct(req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+
+		foundUser, ok := findAuthenticatedUser(c, ctx)
+		if !ok {
+			return
+		}
+
+		if !foundUser.MFA_Enabled {
+			helpers.BadRequest(c, "two-factor authentication is not enabled")
+			return
+		}
+		if mfaRequired(foundUser) {
+			helpers.Error(c, http.StatusForbidden, "two-factor authentication is mandatory for admin accounts")
+			return
+		}
+
+		if err := verifySecondFactor(foundUser, req.Code, req.Recovery_Code); err != nil {
+			respondSecondFactorError(c, err)
+			return
+		}
+
+		if err := database.DisableMFA(UserCollection, foundUser.User_ID); err != nil {
+			helpers.InternalServerError(c, "error disabling two-factor authentication")
+			return
+		}
+
+		recordAudit(c, "mfa_disabled", foundUser.User_ID, foundUser.User_ID, nil)
+
+		helpers.Success(c, "Two-factor authentication disabled", nil)
+	}
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the authenticated user
+func RegenerateRecoveryCodes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var req mfaCodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+		if err := validate.Struct(req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+
+		foundUser, ok := findAuthenticatedUser(c, ctx)
+		if !ok {
+			return
+		}
+
+		if !foundUser.MFA_Enabled {
+			helpers.BadRequest(c, "two-factor authentication is not enabled")
+			return
+		}
+
+		if err := verifySecondFactor(foundUser, req.Code, req.Recovery_Code); err != nil {
+			respondSecondFactorError(c, err)
+			return
+		}
+
+		codes, hashes, err := newRecoveryCodes()
+		if err != nil {
+			helpers.InternalServerError(c, "error generating recovery codes")
+			return
+		}
+
+		if err := database.ReplaceRecoveryCodes(UserCollection, foundUser.User_ID, hashes); err != nil {
+			helpers.InternalServerError(c, "error saving recovery codes")
+			return
+		}
+
+		recordAudit(c, "mfa_recovery_codes_regenerated", foundUser.User_ID, foundUser.User_ID, nil)
+
+		helpers.Success(c, "New recovery codes generated. The previous codes no longer work.", gin.H{
+			"recovery_codes": codes,
+		})
+	}
+}
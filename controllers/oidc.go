@@ -0,0 +1,225 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github/akhil/ecommerce-yt/database"
+	"github/akhil/ecommerce-yt/helpers"
+	"github/akhil/ecommerce-yt/models"
+	"github/akhil/ecommerce-yt/oidc"
+	"github/akhil/ecommerce-yt/roles"
+	generate "github/akhil/ecommerce-yt/tokens"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The user has 10 minutes to sign in at the identity provider
+const oidcStateTTL = 10 * time.Minute
+
+// OIDCLogin starts a login with an OpenID Connect identity provider by redirecting to it.
+// ?mode=cookie asks for a cookie session once the login completes.
+func OIDCLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		provider, err := oidc.Lookup(c.Param("provider"))
+		if err != nil {
+			helpers.NotFound(c, err.Error())
+			return
+		}
+
+		state, err := generate.GenerateOpaqueToken(32)
+		if err != nil {
+			helpers.InternalServerError(c, "error starting login")
+			return
+		}
+		nonce, err := generate.GenerateOpaqueToken(32)
+		if err != nil {
+			helpers.InternalServerError(c, "error starting login")
+			return
+		}
+		codeVerifier, err := oidc.NewCodeVerifier()
+		if err != nil {
+			helpers.InternalServerError(c, "error starting login")
+			return
+		}
+
+		authURL, err := provider.AuthCodeURL(ctx, state, nonce, codeVerifier)
+		if err != nil {
+			log.Printf("error starting login with %s: %v", provider.Name, err)
+			helpers.Error(c, http.StatusBadGateway, "the identity provider is unavailable")
+			return
+		}
+
+		now := time.Now()
+		err = database.CreateOIDCState(OIDCStateCollection, state, models.OIDCState{
+			Provider:      provider.Name,
+			Nonce:         nonce,
+			Code_Verifier: codeVerifier,
+			Cookie_Mode:   helpers.WantsCookieSession(c),
+			Created_At:    now,
+			Expires_At:    now.Add(oidcStateTTL),
+		})
+		if err != nil {
+			helpers.InternalServerError(c, "error starting login")
+			return
+		}
+
+		c.Redirect(http.StatusFound, authURL)
+	}
+}
+
+// OIDCCallback finishes a login with an OpenID Connect identity provider. The user is found by
+// the provider account, linked by verified email, or created, and then logged in as usual.
+func OIDCCallback() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		provider, err := oidc.Lookup(c.Param("provider"))
+		if err != nil {
+			helpers.NotFound(c, err.Error())
+			return
+		}
+
+		if providerError := c.Query("error"); providerError != "" {
+			helpers.BadRequest(c, "the identity provider refused the login: "+providerError)
+			return
+		}
+
+		code, state := c.Query("code"), c.Query("state")
+		if code == "" || state == "" {
+			helpers.BadRequest(c, "code and state are required")
+			return
+		}
+
+		oidcState, err := database.ConsumeOIDCState(OIDCStateCollection, state)
+		if err != nil {
+			if err == database.ErrInvalidOIDCState {
+				helpers.BadRequest(c, err.Error())
+				return
+			}
+			helpers.InternalServerError(c, "error checking login state")
+			return
+		}
+		if oidcState.Provider != provider.Name {
+			helpers.BadRequest(c, database.ErrInvalidOIDCState.Error())
+			return
+		}
+
+		rawIDToken, err := provider.Exchange(ctx, code, oidcState.Code_Verifier)
+		if err != nil {
+			log.Printf("error exchanging authorization code with %s: %v", provider.Name, err)
+			helpers.Error(c, http.StatusBadGateway, "error completing login with the identity provider")
+			return
+		}
+
+		claims, err := provider.VerifyIDToken(ctx, rawIDToken, oidcState.Nonce)
+		if err != nil {
+			log.Printf("error verifying ID token from %s: %v", provider.Name, err)
+			helpers.Unauthorized(c, "the identity provider returned an invalid ID token")
+			return
+		}
+
+		if claims.Email == "" || !claims.EmailVerified {
+			helpers.Error(c, http.StatusForbidden, "the identity provider did not confirm your email address")
+			return
+		}
+
+		foundUser, ok := findOrCreateOIDCUser(c, ctx, provider.Name, claims)
+		if !ok {
+			return
+		}
+
+		if oidcState.Cookie_Mode {
+			c.Set("cookie_session", true)
+		}
+		completeLogin(c, foundUser)
+	}
+}
+
+// findOrCreateOIDCUser returns the user signed in by an identity provider. Users are looked up
+// by the provider account first and then by email, which links the account; otherwise a new
+// customer is created. It answers and returns false on errors.
+func findOrCreateOIDCUser(c *gin.Context, ctx context.Context, providerName string, claims *oidc.IDTokenClaims) (models.User, bool) {
+	var foundUser models.User
+
+	err := UserCollection.FindOne(ctx, bson.M{
+		"identities": bson.M{"$elemMatch": bson.M{"provider": providerName, "subject": claims.Subject}},
+		"deleted_at": bson.M{"$exists": false},
+	}).Decode(&foundUser)
+	if err == nil {
+		return foundUser, true
+	}
+
+	identity := models.ExternalIdentity{
+		Provider:  providerName,
+		Subject:   claims.Subject,
+		Email:     claims.Email,
+		Linked_At: time.Now(),
+	}
+
+	err = UserCollection.FindOne(ctx, bson.M{"email": claims.Email}).Decode(&foundUser)
+	if err == nil {
+		if err := database.LinkIdentity(UserCollection, foundUser.User_ID, identity, foundUser.Email_Verified); err != nil {
+			helpers.InternalServerError(c, "error linking account")
+			return foundUser, false
+		}
+		if !foundUser.Email_Verified {
+			endAllSessions(foundUser.User_ID)
+		}
+
+		recordAudit(c, "identity_linked", foundUser.User_ID, foundUser.User_ID, map[string]interface{}{
+			"provider":         providerName,
+			"password_removed": !foundUser.Email_Verified,
+		})
+
+		if err := UserCollection.FindOne(ctx, bson.M{"user_id": foundUser.User_ID}).Decode(&foundUser); err != nil {
+			helpers.InternalServerError(c, "error loading account")
+			return foundUser, false
+		}
+		return foundUser, true
+	}
+
+	firstName, lastName := claims.GivenName, claims.FamilyName
+	if firstName == "" && lastName == "" {
+		firstName, lastName, _ = strings.Cut(strings.TrimSpace(claims.Name), " ")
+	}
+	email := claims.Email
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+	user := models.User{
+		ID:                primitive.NewObjectID(),
+		First_Name:        &firstName,
+		Last_Name:         &lastName,
+		Email:             &email,
+		Email_Verified:    true,
+		Email_Verified_At: &now,
+		Created_At:        now,
+		Updated_At:        now,
+		Roles:             []string{roles.Customer},
+		Identities:        []models.ExternalIdentity{identity},
+		User_Cart:         make([]models.ProductUser, 0),
+		Address_Details:   make([]models.Address, 0),
+		Order_Status:      make([]models.Order, 0),
+	}
+	user.User_ID = user.ID.Hex()
+
+	if _, err := UserCollection.InsertOne(ctx, user); err != nil {
+		helpers.InternalServerError(c, "error creating account")
+		return user, false
+	}
+
+	recordAudit(c, "oidc_signup", user.User_ID, user.User_ID, map[string]interface{}{
+		"provider": providerName,
+	})
+
+	return user, true
+}
@@ -0,0 +1,181 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github/akhil/ecommerce-yt/database"
+	"github/akhil/ecommerce-yt/helpers"
+	"github/akhil/ecommerce-yt/mailer"
+	"github/akhil/ecommerce-yt/models"
+	"github/akhil/ecommerce-yt/passwords"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Reset tokens are valid for 30 minutes
+const passwordResetTTL = 30 * time.Minute
+
+// forgotPasswordRequest is the body accepted by ForgotPassword
+type forgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// resetPasswordRequest is the body accepted by ResetPassword
+type resetPasswordRequest struct {
+	Token        string `json:"token" validate:"required"`
+	New_Password string `json:"new_password" validate:"required"`
+}
+
+// passwordResetURL builds the link sent in reset emails from PASSWORD_RESET_URL
+func passwordResetURL(token string) string {
+	base := os.Getenv("PASSWORD_RESET_URL")
+	if base == "" {
+		base = "http://localhost:3000/reset-password"
+	}
+	return base + "?token=" + url.QueryEscape(token)
+}
+
+// checkPasswordPolicy answers 400 with every broken rule and returns false when password
+// doesn't satisfy the password policy. personalInfo holds the user's email and names.
+func checkPasswordPolicy(c *gin.Context, password string, personalInfo ...string) bool {
+	violations := passwords.DefaultPolicy().Check(password, personalInfo...)
+	if len(violations) == 0 {
+		return true
+	}
+
+	helpers.ErrorWithDetails(c, http.StatusBadRequest, "weak_password", violations[0].Message, gin.H{
+		"violations": violations,
+	})
+	return false
+}
+
+// personalInfo returns the values of user that a password must not contain
+func personalInfo(user models.User) []string {
+	var info []string
+	for _, value := range []*string{user.Email, user.First_Name, user.Last_Name} {
+		if value != nil {
+			info = append(info, *value)
+		}
+	}
+	return info
+}
+
+// ForgotPassword emails a password reset link. It answers the same way whether or not
+// the email belongs to an account, so it can't be used to discover accounts.
+func ForgotPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var req forgotPasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+		if err := validate.Struct(req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+
+		const message = "If an account exists for this email, a password reset link has been sent"
+
+		var foundUser models.User
+		err := UserCollection.FindOne(ctx, bson.M{"email": req.Email}).Decode(&foundUser)
+		if err != nil {
+			helpers.Success(c, message, nil)
+			return
+		}
+
+		// Failures past this point are only logged: an error answered for existing accounts
+		// alone would tell them apart from unknown emails
+		token, err := database.CreatePasswordReset(PasswordResetCollection, foundUser.User_ID, passwordResetTTL)
+		if err != nil {
+			log.Printf("error creating password reset token for user %s: %v", foundUser.User_ID, err)
+			helpers.Success(c, message, nil)
+			return
+		}
+
+		err = Mailer.Send(ctx, mailer.Message{
+			To:      req.Email,
+			Subject: "Reset your password",
+			Body: fmt.Sprintf("Hi %s,\n\nUse the link below to choose a new password. It expires in %d minutes and can only be used once.\n\n%s\n\nIf you didn't ask for this, you can ignore this email.\n",
+				*foundUser.First_Name, int(passwordResetTTL.Minutes()), passwordResetURL(token)),
+		})
+		if err != nil {
+			log.Printf("error sending password reset email to user %s: %v", foundUser.User_ID, err)
+		}
+
+		helpers.Success(c, message, nil)
+	}
+}
+
+// ResetPassword sets a new password using a reset token and revokes all existing tokens of the user
+func ResetPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var req resetPasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+		if err := validate.Struct(req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+
+		// Check the new password before the token is used up, so the user can pick another one
+		userID, err := database.FindPasswordReset(PasswordResetCollection, req.Token)
+		if err != nil {
+			if err == database.ErrInvalidResetToken {
+				helpers.BadRequest(c, err.Error())
+				return
+			}
+			helpers.InternalServerError(c, "error checking reset token")
+			return
+		}
+
+		var foundUser models.User
+		err = UserCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&foundUser)
+		if err != nil {
+			helpers.BadRequest(c, database.ErrInvalidResetToken.Error())
+			return
+		}
+		if !checkPasswordPolicy(c, req.New_Password, personalInfo(foundUser)...) {
+			return
+		}
+
+		userID, err = database.ConsumePasswordReset(PasswordResetCollection, req.Token)
+		if err != nil {
+			if err == database.ErrInvalidResetToken {
+				helpers.BadRequest(c, err.Error())
+				return
+			}
+			helpers.InternalServerError(c, "error checking reset token")
+			return
+		}
+
+		password, err := HashPassword(req.New_Password)
+		if err != nil {
+			helpers.InternalServerError(c, "error hashing password")
+			return
+		}
+		if err := database.UpdatePassword(UserCollection, userID, password); err != nil {
+			helpers.InternalServerError(c, "error updating password")
+			return
+		}
+
+		endAllSessions(userID)
+		recordAudit(c, "password_reset", userID, userID, nil)
+
+		helpers.Success(c, "Password reset successfully", nil)
+	}
+}
@@ -0,0 +1,198 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github/akhil/ecommerce-yt/mailer"
+	generate "github/akhil/ecommerce-yt/tokens"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// recordingMailer keeps the messages it is asked to send and fails with err when it is set
+type recordingMailer struct {
+	sent []mailer.Message
+	err  error
+}
+
+func (m *recordingMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.sent = append(m.sent, msg)
+	return m.err
+}
+
+// useMailer replaces Mailer with m for the rest of the test
+func useMailer(t testing.TB, m mailer.Mailer) {
+	previous := Mailer
+	Mailer = m
+	t.Cleanup(func() { Mailer = previous })
+}
+
+var resetLink = regexp.MustCompile(`\?token=(\S+)`)
+
+func TestForgotPasswordAnswersTheSameWay(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	deleted := bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 0}}
+	tests := []struct {
+		name      string
+		responses []bson.D
+		mailErr   error
+		wantMail  bool
+	}{
+		{
+			name:      "unknown email",
+			responses: []bson.D{mtest.CreateCursorResponse(0, "test.Users", mtest.FirstBatch)},
+		},
+		{
+			name: "mail can't be sent",
+			responses: []bson.D{
+				mtest.CreateCursorResponse(0, "test.Users", mtest.FirstBatch, userDocument("user-1", 0)),
+				deleted, mtest.CreateSuccessResponse(),
+			},
+			mailErr:  errors.New("connection refused"),
+			wantMail: true,
+		},
+		{
+			name: "link sent",
+			responses: []bson.D{
+				mtest.CreateCursorResponse(0, "test.Users", mtest.FirstBatch, userDocument("user-1", 0)),
+				deleted, mtest.CreateSuccessResponse(),
+			},
+			wantMail: true,
+		},
+	}
+
+	var answers []string
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			useMockCollections(mt)
+			outbox := &recordingMailer{err: tt.mailErr}
+			useMailer(mt, outbox)
+			mt.AddMockResponses(tt.responses...)
+
+			w := serve(ForgotPassword(), http.MethodPost, forgotPasswordRequest{Email: "jane@example.com"}, nil)
+			if w.Code != http.StatusOK {
+				mt.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+			}
+			answers = append(answers, w.Body.String())
+
+			if !tt.wantMail {
+				if len(outbox.sent) != 0 {
+					mt.Errorf("%d emails sent for an unknown address", len(outbox.sent))
+				}
+				return
+			}
+			if len(outbox.sent) != 1 {
+				mt.Fatalf("%d emails sent, want 1", len(outbox.sent))
+			}
+
+			// Only the hash of the emailed token is stored, so a database leak can't be used
+			// to reset passwords
+			match := resetLink.FindStringSubmatch(outbox.sent[0].Body)
+			if match == nil {
+				mt.Fatalf("email has no reset link: %q", outbox.sent[0].Body)
+			}
+			token, err := url.QueryUnescape(match[1])
+			if err != nil {
+				mt.Fatal(err)
+			}
+			inserts := commandsOn(mt, "insert", "PasswordResets")
+			if len(inserts) != 1 {
+				mt.Fatalf("%d reset tokens stored, want 1", len(inserts))
+			}
+			stored := inserts[0].Lookup("documents").Array().Index(0).Value().Document()
+			if hash := stored.Lookup("token_hash").StringValue(); hash != generate.HashOpaqueToken(token) {
+				mt.Errorf("stored token_hash = %q, want the hash of the emailed token", hash)
+			}
+			if strings.Contains(stored.String(), token) {
+				mt.Errorf("the reset token is stored in clear text: %v", stored)
+			}
+		})
+	}
+
+	for i := 1; i < len(answers); i++ {
+		if answers[i] != answers[0] {
+			t.Errorf("%s answered %s, %s answered %s", tests[i].name, answers[i], tests[0].name, answers[0])
+		}
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	reset := bson.D{
+		{Key: "token_hash", Value: generate.HashOpaqueToken("reset-token")},
+		{Key: "user_id", Value: "user-1"},
+		{Key: "expires_at", Value: time.Now().Add(time.Hour)},
+	}
+	updated := bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}, {Key: "nModified", Value: 1}}
+
+	mt.Run("new password set", func(mt *mtest.T) {
+		useMockCollections(mt)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "test.PasswordResets", mtest.FirstBatch, reset),
+			mtest.CreateCursorResponse(0, "test.Users", mtest.FirstBatch, userDocument("user-1", 0)),
+			bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: reset}},
+			updated, updated, mtest.CreateSuccessResponse(),
+		)
+
+		w := serve(ResetPassword(), http.MethodPost, resetPasswordRequest{Token: "reset-token", New_Password: "Plum-Orbit-73!"}, nil)
+		if w.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+		}
+
+		// Tokens issued before the reset stop working with the token version
+		userUpdates := commandsOn(mt, "update", "Users")
+		if len(userUpdates) != 1 {
+			mt.Fatalf("%d updates of the user, want 1", len(userUpdates))
+		}
+		update := userUpdates[0].Lookup("updates").Array().Index(0).Value().Document().Lookup("u").Document()
+		if update.Lookup("$inc", "token_version").AsInt64() != 1 {
+			mt.Errorf("token_version wasn't bumped: %v", update)
+		}
+		if password := update.Lookup("$set", "password").StringValue(); password == "" || password == "Plum-Orbit-73!" {
+			mt.Errorf("password stored as %q, want its hash", password)
+		}
+		if len(commandsOn(mt, "update", "Sessions")) != 1 {
+			mt.Error("the user's sessions weren't ended")
+		}
+	})
+
+	mt.Run("weak password keeps the token", func(mt *mtest.T) {
+		useMockCollections(mt)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "test.PasswordResets", mtest.FirstBatch, reset),
+			mtest.CreateCursorResponse(0, "test.Users", mtest.FirstBatch, userDocument("user-1", 0)),
+		)
+
+		w := serve(ResetPassword(), http.MethodPost, resetPasswordRequest{Token: "reset-token", New_Password: "short"}, nil)
+		if w.Code != http.StatusBadRequest {
+			mt.Fatalf("status = %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+		}
+		// The user can try another password with the same link
+		if len(commandsOn(mt, "findAndModify", "PasswordResets")) != 0 {
+			mt.Errorf("the reset token was used up by a rejected password")
+		}
+	})
+
+	mt.Run("unknown token", func(mt *mtest.T) {
+		useMockCollections(mt)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "test.PasswordResets", mtest.FirstBatch))
+
+		w := serve(ResetPassword(), http.MethodPost, resetPasswordRequest{Token: "reset-token", New_Password: "Plum-Orbit-73!"}, nil)
+		if w.Code != http.StatusBadRequest {
+			mt.Fatalf("status = %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+		}
+		if len(commandsOn(mt, "update", "Users")) != 0 {
+			mt.Error("the password was changed with an unknown token")
+		}
+	})
+}
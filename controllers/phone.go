@@ -0,0 +1,244 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github/akhil/ecommerce-yt/database"
+	"github/akhil/ecommerce-yt/helpers"
+	"github/akhil/ecommerce-yt/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// phoneLoginRequest is the body accepted by RequestPhoneLogin
+type phoneLoginRequest struct {
+	Phone string `json:"phone" validate:"required"`
+}
+
+// phoneCodeRequest is the body accepted by PhoneLogin
+type phoneCodeRequest struct {
+	Phone string `json:"phone" validate:"required"`
+	Code  string `json:"code" validate:"required,len=6,numeric"`
+}
+
+// verifyPhoneRequest is the body accepted by VerifyPhone
+type verifyPhoneRequest struct {
+	Code string `json:"code" validate:"required,len=6,numeric"`
+}
+
+// otpLimitsFromEnv reads OTP_TTL_MINUTES, OTP_RESEND_SECONDS, OTP_MAX_PER_HOUR,
+// OTP_IP_MAX_PER_HOUR and OTP_MAX_ATTEMPTS
+func otpLimitsFromEnv() database.OTPLimits {
+	return database.OTPLimits{
+		TTL:            time.Duration(envInt("OTP_TTL_MINUTES", 5)) * time.Minute,
+		ResendInterval: time.Duration(envInt("OTP_RESEND_SECONDS", 60)) * time.Second,
+		MaxPerHour:     envInt("OTP_MAX_PER_HOUR", 5),
+		MaxIPPerHour:   envInt("OTP_IP_MAX_PER_HOUR", 20),
+		MaxAttempts:    envInt("OTP_MAX_ATTEMPTS", 5),
+	}
+}
+
+// issuePhoneOTP creates a code for phone and texts it unless send is false. It answers and
+// returns false on errors, with 429 and Retry-After when too many codes were requested.
+func issuePhoneOTP(c *gin.Context, ctx context.Context, phone, purpose, userID string, send bool) bool {
+	limits := otpLimitsFromEnv()
+	code, wait, err := database.CreatePhoneOTP(PhoneOTPCollection, phone, purpose, userID, c.ClientIP(), limits)
+	if err != nil {
+		if err == database.ErrOTPRateLimited {
+			seconds := int(wait.Round(time.Second).Seconds())
+			if seconds < 1 {
+				seconds = 1
+			}
+			c.Header("Retry-After", strconv.Itoa(seconds))
+			helpers.ErrorWithCode(c, http.StatusTooManyRequests, "otp_rate_limited",
+				fmt.Sprintf("too many codes requested, try again in %d seconds", seconds))
+			return false
+		}
+		helpers.InternalServerError(c, "error creating code")
+		return false
+	}
+
+	if !send {
+		return true
+	}
+
+	minutes := int(limits.TTL.Minutes())
+	err = SMS.Send(ctx, phone, fmt.Sprintf("Your verification code is %s. It expires in %d minutes. Never share it with anyone.", code, minutes))
+	if err != nil {
+		log.Printf("error sending code to user %s: %v", userID, err)
+		helpers.InternalServerError(c, "error sending code")
+		return false
+	}
+	return true
+}
+
+// RequestPhoneLogin texts a login code to a verified phone number. It answers the same way
+// whether or not the number belongs to an account, so it can't be used to discover accounts.
+func RequestPhoneLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var req phoneLoginRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+		if err := validate.Struct(req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+		phone := strings.TrimSpace(req.Phone)
+
+		var foundUser models.User
+		err := UserCollection.FindOne(ctx, bson.M{"phone": phone, "phone_verified": true, "deleted_at": bson.M{"$exists": false}}).Decode(&foundUser)
+		found := err == nil
+
+		// Unknown numbers get a code too, which is never sent, so the limits apply alike
+		if !issuePhoneOTP(c, ctx, phone, database.OTPPurposeLogin, foundUser.User_ID, found) {
+			return
+		}
+
+		helpers.Success(c, "If this number belongs to a verified account, a login code has been sent", nil)
+	}
+}
+
+// PhoneLogin logs in with a code sent by RequestPhoneLogin. Two-factor authentication still applies.
+func PhoneLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var req phoneCodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+		if err := validate.Struct(req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+		phone := strings.TrimSpace(req.Phone)
+
+		ipKey := ipAttemptKey(c.ClientIP())
+		if !allowLoginAttempt(c, ipKey) {
+			return
+		}
+
+		otp, err := database.VerifyPhoneOTP(PhoneOTPCollection, phone, database.OTPPurposeLogin, req.Code, otpLimitsFromEnv().MaxAttempts)
+		if err != nil && err != database.ErrInvalidOTP {
+			helpers.InternalServerError(c, "error checking code")
+			return
+		}
+
+		var foundUser models.User
+		if err == nil && otp.User_ID != "" {
+			err = UserCollection.FindOne(ctx, bson.M{
+				"user_id":        otp.User_ID,
+				"phone":          phone,
+				"phone_verified": true,
+				"deleted_at":     bson.M{"$exists": false},
+			}).Decode(&foundUser)
+		}
+		if err != nil || otp.User_ID == "" {
+			recordLoginFailure(c, ipKey, loginThrottleFromEnv().MaxIPFailures, otp.User_ID)
+			helpers.Unauthorized(c, database.ErrInvalidOTP.Error())
+			return
+		}
+
+		completeLogin(c, foundUser)
+	}
+}
+
+// SendPhoneVerification texts a code to the authenticated user's phone number to verify it
+func SendPhoneVerification() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		foundUser, ok := findAuthenticatedUser(c, ctx)
+		if !ok {
+			return
+		}
+
+		if foundUser.Phone == nil || *foundUser.Phone == "" {
+			helpers.BadRequest(c, "add a phone number to your profile first")
+			return
+		}
+		if foundUser.Phone_Verified {
+			helpers.BadRequest(c, "phone number is already verified")
+			return
+		}
+
+		if !issuePhoneOTP(c, ctx, *foundUser.Phone, database.OTPPurposeVerifyPhone, foundUser.User_ID, true) {
+			return
+		}
+
+		helpers.Success(c, "A verification code has been sent to your phone", nil)
+	}
+}
+
+// VerifyPhone marks the authenticated user's phone number as verified with a code sent by SendPhoneVerification
+func VerifyPhone() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var req verifyPhoneRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+		if err := validate.Struct(req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+
+		foundUser, ok := findAuthenticatedUser(c, ctx)
+		if !ok {
+			return
+		}
+		if foundUser.Phone == nil || *foundUser.Phone == "" {
+			helpers.BadRequest(c, "add a phone number to your profile first")
+			return
+		}
+		phone := *foundUser.Phone
+
+		otp, err := database.VerifyPhoneOTP(PhoneOTPCollection, phone, database.OTPPurposeVerifyPhone, req.Code, otpLimitsFromEnv().MaxAttempts)
+		if err != nil {
+			if err == database.ErrInvalidOTP {
+				helpers.BadRequest(c, err.Error())
+				return
+			}
+			helpers.InternalServerError(c, "error checking code")
+			return
+		}
+		if otp.User_ID != foundUser.User_ID {
+			helpers.BadRequest(c, database.ErrInvalidOTP.Error())
+			return
+		}
+
+		if err := database.MarkPhoneVerified(UserCollection, foundUser.User_ID, phone); err != nil {
+			switch err {
+			case database.ErrCantFindUser:
+				helpers.BadRequest(c, "your phone number changed, request a new code")
+			case database.ErrPhoneInUse:
+				helpers.Error(c, http.StatusConflict, err.Error())
+			default:
+				helpers.InternalServerError(c, "error verifying phone number")
+			}
+			return
+		}
+
+		recordAudit(c, "phone_verified", foundUser.User_ID, foundUser.User_ID, nil)
+
+		helpers.Success(c, "Phone number verified successfully", nil)
+	}
+}
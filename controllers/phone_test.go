@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github/akhil/ecommerce-yt/sms"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// recordingSender keeps the text messages it is asked to send
+type recordingSender struct {
+	sent []string
+}
+
+func (s *recordingSender) Send(ctx context.Context, to, body string) error {
+	s.sent = append(s.sent, to)
+	return nil
+}
+
+// useSMS replaces SMS with s for the rest of the test
+func useSMS(t testing.TB, s sms.SMSSender) {
+	previous := SMS
+	SMS = s
+	t.Cleanup(func() { SMS = previous })
+}
+
+func TestRequestPhoneLoginAnswersTheSameWay(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	user := append(userDocument("user-1", 0), bson.E{Key: "phone", Value: "+15550100"}, bson.E{Key: "phone_verified", Value: true})
+	none := mtest.CreateCursorResponse(0, "test.PhoneOTPs", mtest.FirstBatch)
+	// Creating the code: the last code, the phone and IP counts, expiring earlier codes, storing it
+	createCode := []bson.D{none, none, none, {{Key: "ok", Value: 1}, {Key: "n", Value: 0}, {Key: "nModified", Value: 0}}, mtest.CreateSuccessResponse()}
+
+	tests := []struct {
+		name     string
+		found    bson.D
+		wantSent int
+	}{
+		{"unknown number", mtest.CreateCursorResponse(0, "test.Users", mtest.FirstBatch), 0},
+		{"verified number", mtest.CreateCursorResponse(0, "test.Users", mtest.FirstBatch, user), 1},
+	}
+
+	var answers []string
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			useMockCollections(mt)
+			outbox := &recordingSender{}
+			useSMS(mt, outbox)
+			mt.AddMockResponses(tt.found)
+			mt.AddMockResponses(createCode...)
+
+			w := serve(RequestPhoneLogin(), http.MethodPost, phoneLoginRequest{Phone: " +15550100 "}, nil)
+			if w.Code != http.StatusOK {
+				mt.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+			}
+			answers = append(answers, w.Body.String())
+
+			if len(outbox.sent) != tt.wantSent {
+				mt.Errorf("%d texts sent, want %d", len(outbox.sent), tt.wantSent)
+			}
+			// Unknown numbers get a code too, so the limits apply alike
+			if len(commandsOn(mt, "insert", "PhoneOTPs")) != 1 {
+				mt.Error("no code was stored")
+			}
+		})
+	}
+
+	if len(answers) == 2 && answers[0] != answers[1] {
+		t.Errorf("unknown number answered %s, verified number %s", answers[0], answers[1])
+	}
+}
@@ -0,0 +1,386 @@
+package controllers
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github/akhil/ecommerce-yt/database"
+	"github/akhil/ecommerce-yt/helpers"
+	"github/akhil/ecommerce-yt/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// replaceProductRequest is the body accepted by ReplaceProduct. Every field but the category
+// and tags is required; omitting those removes them.
+type replaceProductRequest struct {
+	Product_Name *string  `json:"product_name" validate:"required,min=1,max=200"`
+	Price        *uint64  `json:"price" validate:"required"`
+	Rating       *uint8   `json:"rating" validate:"required,max=5"`
+	Image        *string  `json:"image" validate:"required,min=1"`
+	Category_ID  string   `json:"category_id"`
+	Tags         []string `json:"tags"`
+}
+
+// restockRequest is the body accepted by RestockProduct
+type restockRequest struct {
+	Quantity int64  `json:"quantity" validate:"required,min=1,max=1000000"`
+	SKU      string `json:"sku"`
+}
+
+// adjustStockRequest is the body accepted by AdjustProductStock
+type adjustStockRequest struct {
+	Delta  int64  `json:"delta" validate:"required,min=-1000000,max=1000000"`
+	Reason string `json:"reason" validate:"required,min=1,max=200"`
+	SKU    string `json:"sku"`
+}
+
+// patchProductRequest is the body accepted by PatchProduct. Omitted fields are left unchanged;
+// an empty category_id or tags list removes it.
+type patchProductRequest struct {
+	Product_Name *string   `json:"product_name" validate:"omitempty,min=1,max=200"`
+	Price        *uint64   `json:"price"`
+	Rating       *uint8    `json:"rating" validate:"omitempty,max=5"`
+	Image        *string   `json:"image" validate:"omitempty,min=1"`
+	Category_ID  *string   `json:"category_id"`
+	Tags         *[]string `json:"tags"`
+}
+
+// fields returns the product fields to set
+func (req patchProductRequest) fields() bson.M {
+	fields := bson.M{}
+	if req.Product_Name != nil {
+		fields["product_name"] = strings.TrimSpace(*req.Product_Name)
+	}
+	if req.Price != nil {
+		fields["price"] = *req.Price
+	}
+	if req.Rating != nil {
+		fields["rating"] = *req.Rating
+	}
+	if req.Image != nil {
+		fields["image"] = *req.Image
+	}
+	return fields
+}
+
+// productIDParam parses the product_id path parameter, answering 400 when it is invalid
+func productIDParam(c *gin.Context) (primitive.ObjectID, bool) {
+	productID, err := primitive.ObjectIDFromHex(c.Param("product_id"))
+	if err != nil {
+		helpers.BadRequest(c, "invalid product id")
+		return primitive.NilObjectID, false
+	}
+	return productID, true
+}
+
+// handleProductError answers for errors returned by the database product functions
+func handleProductError(c *gin.Context, err error, action string) {
+	switch err {
+	case database.ErrCantFindProduct:
+		helpers.NotFound(c, "product not found")
+	case database.ErrCantFindVariant:
+		helpers.NotFound(c, "variant not found")
+	case database.ErrDuplicateSKU:
+		helpers.Error(c, http.StatusConflict, err.Error())
+	case database.ErrProductArchived, database.ErrProductNotArchived, database.ErrInsufficientStock,
+		database.ErrVariantRequired, database.ErrProductHasVariants:
+		helpers.BadRequest(c, err.Error())
+	default:
+		helpers.InternalServerError(c, "error "+action+" product")
+	}
+}
+
+// Availability of a product as shown to customers
+const (
+	productAvailable  = "available"
+	productOutOfStock = "out_of_stock"
+)
+
+// productDetail is a product as returned by GetProduct
+type productDetail struct {
+	models.Product
+	Status    string `json:"status"`
+	Available bool   `json:"available"`
+}
+
+// GetProduct returns a single product with whether it can still be bought.
+// Archived products are answered with 404 like unknown ones.
+func GetProduct() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, ok := productIDParam(c)
+		if !ok {
+			return
+		}
+
+		product, err := database.FindProduct(ProductCollection, productID)
+		if err == nil && product.Archived_At != nil {
+			err = database.ErrCantFindProduct
+		}
+		if err != nil {
+			handleProductError(c, err, "fetching")
+			return
+		}
+
+		detail := productDetail{Product: product, Status: productAvailable, Available: true}
+		if product.Stock == nil || *product.Stock < 1 {
+			detail.Status = productOutOfStock
+			detail.Available = false
+		}
+
+		helpers.Success(c, "", detail)
+	}
+}
+
+// AdminListProducts returns all products with pagination, archived ones included.
+// Query parameters:
+//   - status: optional, "active" or "archived" to only list those products
+func AdminListProducts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		pagination := helpers.GetPaginationParams(c)
+
+		filter := bson.M{}
+		switch c.Query("status") {
+		case "":
+		case "active":
+			filter["archived_at"] = database.NotArchived
+		case "archived":
+			filter["archived_at"] = bson.M{"$exists": true}
+		default:
+			helpers.BadRequest(c, "status must be active or archived")
+			return
+		}
+
+		products, total, err := database.ListProducts(ProductCollection, filter, pagination.Skip, pagination.PageSize)
+		if err != nil {
+			helpers.InternalServerError(c, "error fetching products")
+			return
+		}
+
+		helpers.PaginatedSuccess(c, products, total, pagination)
+	}
+}
+
+// AdminGetProduct returns a product, even when it is archived
+func AdminGetProduct() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, ok := productIDParam(c)
+		if !ok {
+			return
+		}
+
+		product, err := database.FindProduct(ProductCollection, productID)
+		if err != nil {
+			handleProductError(c, err, "fetching")
+			return
+		}
+
+		helpers.Success(c, "", product)
+	}
+}
+
+// ReplaceProduct replaces every editable field of a product
+func ReplaceProduct() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, ok := productIDParam(c)
+		if !ok {
+			return
+		}
+
+		var req replaceProductRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+		if err := validate.Struct(req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+
+		fields := patchProductRequest{
+			Product_Name: req.Product_Name,
+			Price:        req.Price,
+			Rating:       req.Rating,
+			Image:        req.Image,
+		}.fields()
+		if !classificationFields(c, fields, &req.Category_ID, &req.Tags) {
+			return
+		}
+
+		updateProduct(c, productID, fields)
+	}
+}
+
+// PatchProduct updates the fields of a product present in the body
+func PatchProduct() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, ok := productIDParam(c)
+		if !ok {
+			return
+		}
+
+		var req patchProductRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+		if err := validate.Struct(req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+
+		fields := req.fields()
+		if !classificationFields(c, fields, req.Category_ID, req.Tags) {
+			return
+		}
+		if len(fields) == 0 {
+			helpers.BadRequest(c, "no fields to update")
+			return
+		}
+
+		updateProduct(c, productID, fields)
+	}
+}
+
+// updateProduct stores the new field values, audits the change and answers with the product
+func updateProduct(c *gin.Context, productID primitive.ObjectID, fields bson.M) {
+	if name, ok := fields["product_name"].(string); ok && name == "" {
+		helpers.BadRequest(c, "product_name cannot be blank")
+		return
+	}
+
+	product, err := database.UpdateProduct(ProductCollection, productID, fields)
+	if err != nil {
+		handleProductError(c, err, "updating")
+		return
+	}
+
+	changed := make([]string, 0, len(fields))
+	for field := range fields {
+		changed = append(changed, field)
+	}
+	sort.Strings(changed)
+	recordAudit(c, "product_updated", c.GetString("user_id"), productID.Hex(), map[string]interface{}{
+		"fields": changed,
+	})
+
+	helpers.Success(c, "Product updated successfully", product)
+}
+
+// DeleteProduct archives a product, taking it off sale while keeping it for admins and past
+// orders. With ?permanent=true the product is removed for good instead.
+func DeleteProduct() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, ok := productIDParam(c)
+		if !ok {
+			return
+		}
+
+		if c.Query("permanent") == "true" {
+			if err := database.DeleteProduct(ProductCollection, productID); err != nil {
+				handleProductError(c, err, "deleting")
+				return
+			}
+			recordAudit(c, "product_deleted", c.GetString("user_id"), productID.Hex(), nil)
+			helpers.Success(c, "Product deleted successfully", nil)
+			return
+		}
+
+		if err := database.ArchiveProduct(ProductCollection, productID); err != nil {
+			handleProductError(c, err, "archiving")
+			return
+		}
+		recordAudit(c, "product_archived", c.GetString("user_id"), productID.Hex(), nil)
+
+		helpers.Success(c, "Product archived successfully", nil)
+	}
+}
+
+// RestoreProduct puts an archived product back on sale
+func RestoreProduct() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, ok := productIDParam(c)
+		if !ok {
+			return
+		}
+
+		if err := database.RestoreProduct(ProductCollection, productID); err != nil {
+			handleProductError(c, err, "restoring")
+			return
+		}
+		recordAudit(c, "product_restored", c.GetString("user_id"), productID.Hex(), nil)
+
+		helpers.Success(c, "Product restored successfully", nil)
+	}
+}
+
+// RestockProduct adds received units to the stock of a product, or of the variant given by sku
+func RestockProduct() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, ok := productIDParam(c)
+		if !ok {
+			return
+		}
+
+		var req restockRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+		if err := validate.Struct(req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+
+		product, err := database.AdjustStock(ProductCollection, productID, req.SKU, req.Quantity)
+		if err != nil {
+			handleProductError(c, err, "restocking")
+			return
+		}
+		recordAudit(c, "product_restocked", c.GetString("user_id"), productID.Hex(), map[string]interface{}{
+			"sku":      req.SKU,
+			"quantity": req.Quantity,
+			"stock":    product.Stock,
+		})
+
+		helpers.Success(c, "Product restocked successfully", product)
+	}
+}
+
+// AdjustProductStock corrects the stock of a product, or of the variant given by sku, for example
+// after a stock count or for damaged goods. A negative delta removes units; stock can't go below zero.
+func AdjustProductStock() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, ok := productIDParam(c)
+		if !ok {
+			return
+		}
+
+		var req adjustStockRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+		if err := validate.Struct(req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+
+		product, err := database.AdjustStock(ProductCollection, productID, req.SKU, req.Delta)
+		if err != nil {
+			handleProductError(c, err, "adjusting stock of")
+			return
+		}
+		recordAudit(c, "product_stock_adjusted", c.GetString("user_id"), productID.Hex(), map[string]interface{}{
+			"sku":    req.SKU,
+			"delta":  req.Delta,
+			"reason": strings.TrimSpace(req.Reason),
+			"stock":  product.Stock,
+		})
+
+		helpers.Success(c, "Product stock adjusted successfully", product)
+	}
+}
@@ -0,0 +1,393 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github/akhil/ecommerce-yt/database"
+	"github/akhil/ecommerce-yt/helpers"
+	"github/akhil/ecommerce-yt/mailer"
+	"github/akhil/ecommerce-yt/models"
+	"github/akhil/ecommerce-yt/roles"
+	generate "github/akhil/ecommerce-yt/tokens"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// userProfile is the view of a user returned to the user themselves. It leaves out the
+// password hash, stored tokens and MFA secrets.
+type userProfile struct {
+	User_ID           string                    `json:"user_id"`
+	First_Name        *string                   `json:"first_name"`
+	Last_Name         *string                   `json:"last_name"`
+	Email             *string                   `json:"email"`
+	Email_Verified    bool                      `json:"email_verified"`
+	Email_Verified_At *time.Time                `json:"email_verified_at,omitempty"`
+	Pending_Email     *string                   `json:"pending_email,omitempty"`
+	Phone             *string                   `json:"phone"`
+	Phone_Verified    bool                      `json:"phone_verified"`
+	Roles             []string                  `json:"roles"`
+	MFA_Enabled       bool                      `json:"mfa_enabled"`
+	Identities        []models.ExternalIdentity `json:"identities,omitempty"`
+	Created_At        time.Time                 `json:"created_at"`
+	Updated_At        time.Time                 `json:"updated_at"`
+}
+
+// updateProfileRequest is the body accepted by UpdateMe. Omitted fields are left unchanged;
+// fields that are present can't be blank.
+type updateProfileRequest struct {
+	First_Name *string `json:"first_name" validate:"omitnil,min=2,max=30"`
+	Last_Name  *string `json:"last_name" validate:"omitnil,min=2,max=30"`
+	Phone      *string `json:"phone" validate:"omitnil,min=1"`
+}
+
+// trim removes surrounding spaces from the fields present in the request
+func (req *updateProfileRequest) trim() {
+	for _, field := range []*string{req.First_Name, req.Last_Name, req.Phone} {
+		if field != nil {
+			*field = strings.TrimSpace(*field)
+		}
+	}
+}
+
+// changePasswordRequest is the body accepted by ChangePassword
+type changePasswordRequest struct {
+	Current_Password string `json:"current_password" validate:"required"`
+	New_Password     string `json:"new_password" validate:"required"`
+}
+
+// changeEmailRequest is the body accepted by ChangeEmail
+type changeEmailRequest struct {
+	New_Email        string `json:"new_email" validate:"required,email"`
+	Current_Password string `json:"current_password" validate:"required"`
+}
+
+// newUserProfile builds the profile view of user
+func newUserProfile(user models.User) userProfile {
+	return userProfile{
+		User_ID:           user.User_ID,
+		First_Name:        user.First_Name,
+		Last_Name:         user.Last_Name,
+		Email:             user.Email,
+		Email_Verified:    user.Email_Verified,
+		Email_Verified_At: user.Email_Verified_At,
+		Pending_Email:     user.Pending_Email,
+		Phone:             user.Phone,
+		Phone_Verified:    user.Phone_Verified,
+		Roles:             roles.EffectiveRoles(user.Roles, user.IsAdmin),
+		MFA_Enabled:       user.MFA_Enabled,
+		Identities:        user.Identities,
+		Created_At:        user.Created_At,
+		Updated_At:        user.Updated_At,
+	}
+}
+
+// emailChangeURL builds the link sent to confirm a new address from EMAIL_CHANGE_URL
+func emailChangeURL(token string) string {
+	base := os.Getenv("EMAIL_CHANGE_URL")
+	if base == "" {
+		base = "http://localhost:8000/api/v1/users/me/email/confirm"
+	}
+	return base + "?token=" + url.QueryEscape(token)
+}
+
+// checkCurrentPassword answers and returns false unless password is the user's current password.
+// Wrong guesses count towards the login throttle of the account.
+func checkCurrentPassword(c *gin.Context, user models.User, password string) bool {
+	attemptKey := emailAttemptKey(*user.Email)
+	if !allowLoginAttempt(c, attemptKey) {
+		return false
+	}
+
+	if user.Password == nil {
+		helpers.BadRequest(c, "your account has no password yet, set one with the forgot password link first")
+		return false
+	}
+	if ok, _ := VerifyPassword(password, *user.Password, user.User_ID); !ok {
+		recordLoginFailure(c, attemptKey, loginThrottleFromEnv().MaxFailures, user.User_ID)
+		helpers.Unauthorized(c, "current password is incorrect")
+		return false
+	}
+	return true
+}
+
+// GetMe returns the profile of the authenticated user
+func GetMe() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		foundUser, ok := findAuthenticatedUser(c, ctx)
+		if !ok {
+			return
+		}
+
+		helpers.Success(c, "", newUserProfile(foundUser))
+	}
+}
+
+// UpdateMe changes the name or phone number of the authenticated user
+func UpdateMe() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var req updateProfileRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+		// Validate the values that will be stored, so "  a " doesn't pass as a two letter name
+		req.trim()
+		if err := validate.Struct(req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+
+		foundUser, ok := findAuthenticatedUser(c, ctx)
+		if !ok {
+			return
+		}
+
+		fields := bson.M{}
+		if req.First_Name != nil {
+			fields["first_name"] = *req.First_Name
+		}
+		if req.Last_Name != nil {
+			fields["last_name"] = *req.Last_Name
+		}
+		if req.Phone != nil && (foundUser.Phone == nil || *req.Phone != *foundUser.Phone) {
+			count, err := UserCollection.CountDocuments(ctx, bson.M{"phone": *req.Phone, "user_id": bson.M{"$ne": foundUser.User_ID}})
+			if err != nil {
+				helpers.InternalServerError(c, "error checking phone number")
+				return
+			}
+			if count > 0 {
+				helpers.Error(c, http.StatusConflict, "this phone number is already in use")
+				return
+			}
+			fields["phone"] = *req.Phone
+			// The new number has to be verified again
+			fields["phone_verified"] = false
+			fields["phone_verified_at"] = nil
+		}
+
+		if len(fields) == 0 {
+			helpers.BadRequest(c, "nothing to update")
+			return
+		}
+
+		if err := database.UpdateProfile(UserCollection, foundUser.User_ID, fields); err != nil {
+			helpers.InternalServerError(c, "error updating profile")
+			return
+		}
+
+		err := UserCollection.FindOne(ctx, bson.M{"user_id": foundUser.User_ID}).Decode(&foundUser)
+		if err != nil {
+			helpers.InternalServerError(c, "error loading profile")
+			return
+		}
+
+		helpers.Success(c, "Profile updated successfully", newUserProfile(foundUser))
+	}
+}
+
+// ChangePassword sets a new password after checking the current one. Every other session of
+// the user is signed out; the current one receives new tokens.
+func ChangePassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var req changePasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+		if err := validate.Struct(req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+
+		foundUser, ok := findAuthenticatedUser(c, ctx)
+		if !ok {
+			return
+		}
+
+		if !checkCurrentPassword(c, foundUser, req.Current_Password) {
+			return
+		}
+		if !checkPasswordPolicy(c, req.New_Password, personalInfo(foundUser)...) {
+			return
+		}
+
+		password, err := HashPassword(req.New_Password)
+		if err != nil {
+			helpers.InternalServerError(c, "error hashing password")
+			return
+		}
+		if err := database.UpdatePassword(UserCollection, foundUser.User_ID, password); err != nil {
+			helpers.InternalServerError(c, "error updating password")
+			return
+		}
+
+		endAllSessions(foundUser.User_ID)
+		recordAudit(c, "password_changed", foundUser.User_ID, foundUser.User_ID, nil)
+
+		// UpdatePassword revoked every token, including the ones used for this request, so this
+		// device continues in a new session
+		err = UserCollection.FindOne(ctx, bson.M{"user_id": foundUser.User_ID}).Decode(&foundUser)
+		if err != nil {
+			helpers.Success(c, "Password changed successfully, please log in again", nil)
+			return
+		}
+		// A browser signed in with cookies gets its new tokens as cookies again
+		if c.GetBool("auth_via_cookie") {
+			c.Set("cookie_session", true)
+		}
+		issueLoginTokens(c, foundUser)
+	}
+}
+
+// ChangeEmail starts an email change. The new address takes effect once the link sent to it is opened.
+func ChangeEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var req changeEmailRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+		if err := validate.Struct(req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+		newEmail := strings.TrimSpace(req.New_Email)
+
+		foundUser, ok := findAuthenticatedUser(c, ctx)
+		if !ok {
+			return
+		}
+
+		if !checkCurrentPassword(c, foundUser, req.Current_Password) {
+			return
+		}
+
+		if strings.EqualFold(newEmail, *foundUser.Email) {
+			helpers.BadRequest(c, "this is already your email address")
+			return
+		}
+
+		count, err := UserCollection.CountDocuments(ctx, bson.M{"email": newEmail})
+		if err != nil {
+			helpers.InternalServerError(c, "error checking email")
+			return
+		}
+		if count > 0 {
+			helpers.Error(c, http.StatusConflict, "this email address is already in use")
+			return
+		}
+
+		if err := database.SetPendingEmail(UserCollection, foundUser.User_ID, newEmail); err != nil {
+			helpers.InternalServerError(c, "error updating email")
+			return
+		}
+
+		token, err := generate.GenerateEmailChangeToken(foundUser.User_ID, newEmail)
+		if err != nil {
+			helpers.InternalServerError(c, "error generating confirmation link")
+			return
+		}
+
+		err = Mailer.Send(ctx, mailer.Message{
+			To:      newEmail,
+			Subject: "Confirm your new email address",
+			Body: fmt.Sprintf("Hi %s,\n\nOpen the link below to start using this address for your account. It expires in 24 hours.\n\n%s\n",
+				*foundUser.First_Name, emailChangeURL(token)),
+		})
+		if err != nil {
+			log.Printf("error sending email change confirmation to user %s: %v", foundUser.User_ID, err)
+			helpers.InternalServerError(c, "error sending confirmation email")
+			return
+		}
+
+		recordAudit(c, "email_change_requested", foundUser.User_ID, foundUser.User_ID, nil)
+
+		helpers.Success(c, "A confirmation link has been sent to the new address", nil)
+	}
+}
+
+// ConfirmEmailChange switches the user to the new address in an email change link and
+// tells the old address about it
+func ConfirmEmailChange() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		token := c.Query("token")
+		if token == "" {
+			helpers.BadRequest(c, "token is required")
+			return
+		}
+
+		claims, msg := generate.ValidateEmailChangeToken(token)
+		if msg != "" {
+			helpers.BadRequest(c, "confirmation link is invalid or has expired")
+			return
+		}
+
+		var foundUser models.User
+		err := UserCollection.FindOne(ctx, bson.M{"user_id": claims.User_ID}).Decode(&foundUser)
+		if err != nil {
+			helpers.BadRequest(c, "confirmation link is no longer valid")
+			return
+		}
+
+		// The address may have been taken since the change was requested
+		count, err := UserCollection.CountDocuments(ctx, bson.M{"email": claims.Email})
+		if err != nil {
+			helpers.InternalServerError(c, "error checking email")
+			return
+		}
+		if count > 0 {
+			helpers.Error(c, http.StatusConflict, "this email address is already in use")
+			return
+		}
+
+		err = database.ConfirmEmailChange(UserCollection, claims.User_ID, claims.Email)
+		if err != nil {
+			if err == database.ErrCantFindUser {
+				helpers.BadRequest(c, "confirmation link is no longer valid")
+				return
+			}
+			helpers.InternalServerError(c, "error updating email")
+			return
+		}
+
+		recordAudit(c, "email_changed", foundUser.User_ID, foundUser.User_ID, map[string]interface{}{
+			"old_email": *foundUser.Email,
+			"new_email": claims.Email,
+		})
+
+		err = Mailer.Send(ctx, mailer.Message{
+			To:      *foundUser.Email,
+			Subject: "Your email address was changed",
+			Body: fmt.Sprintf("Hi %s,\n\nThe email address of your account was changed to %s. If you didn't do this, please contact support right away.\n",
+				*foundUser.First_Name, claims.Email),
+		})
+		if err != nil {
+			log.Printf("error sending email change notice to user %s: %v", foundUser.User_ID, err)
+		}
+
+		helpers.Success(c, "Email address changed successfully", nil)
+	}
+}
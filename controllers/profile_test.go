@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestUpdateProfileRequestValidation(t *testing.T) {
+	name := func(s string) *string { return &s }
+
+	tests := []struct {
+		name      string
+		req       updateProfileRequest
+		valid     bool
+		wantFirst string
+	}{
+		{"omitted fields", updateProfileRequest{}, true, ""},
+		{"surrounding spaces", updateProfileRequest{First_Name: name("  Jane ")}, true, "Jane"},
+		// Trimmed before validation, so spaces don't make up the minimum length
+		{"one letter padded with spaces", updateProfileRequest{First_Name: name("  a ")}, false, ""},
+		{"blank name", updateProfileRequest{Last_Name: name("   ")}, false, ""},
+		{"blank phone", updateProfileRequest{Phone: name(" ")}, false, ""},
+		{"too long", updateProfileRequest{First_Name: name(strings.Repeat("a", 31))}, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.trim()
+			err := validate.Struct(tt.req)
+			if (err == nil) != tt.valid {
+				t.Fatalf("validate.Struct() = %v, want valid %v", err, tt.valid)
+			}
+			if tt.wantFirst != "" && *tt.req.First_Name != tt.wantFirst {
+				t.Errorf("first name = %q, want %q", *tt.req.First_Name, tt.wantFirst)
+			}
+		})
+	}
+}
+
+func TestGetMeLeavesOutSecrets(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("profile", func(mt *mtest.T) {
+		useMockCollections(mt)
+		user := append(userDocument("user-1", 3),
+			bson.E{Key: "password", Value: "password-hash"},
+			bson.E{Key: "token", Value: "stored-access-token"},
+			bson.E{Key: "refresh_token", Value: "stored-refresh-token"},
+			bson.E{Key: "mfa_secret", Value: "totp-secret"},
+			bson.E{Key: "mfa_recovery_codes", Value: bson.A{"recovery-code-hash"}},
+		)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "test.Users", mtest.FirstBatch, user))
+
+		w := serve(GetMe(), http.MethodGet, nil, func(c *gin.Context) { c.Set("user_id", "user-1") })
+		if w.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+		}
+		for _, secret := range []string{"password", "token", "mfa_secret", "recovery", "totp-secret"} {
+			if strings.Contains(w.Body.String(), secret) {
+				mt.Errorf("profile contains %q: %s", secret, w.Body)
+			}
+		}
+	})
+}
+
+func TestUpdateMePhone(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	user := append(userDocument("user-1", 0),
+		bson.E{Key: "phone", Value: "+15550100"},
+		bson.E{Key: "phone_verified", Value: true},
+	)
+	onAccount := func(c *gin.Context) { c.Set("user_id", "user-1") }
+
+	mt.Run("new number must be verified again", func(mt *mtest.T) {
+		useMockCollections(mt)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "test.Users", mtest.FirstBatch, user),
+			mtest.CreateCursorResponse(0, "test.Users", mtest.FirstBatch),
+			bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}, {Key: "nModified", Value: 1}},
+			mtest.CreateCursorResponse(0, "test.Users", mtest.FirstBatch, user),
+		)
+
+		w := serve(UpdateMe(), http.MethodPatch, gin.H{"phone": " +15550199 "}, onAccount)
+		if w.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+		}
+
+		updates := commandsOn(mt, "update", "Users")
+		if len(updates) != 1 {
+			mt.Fatalf("%d updates of the user, want 1", len(updates))
+		}
+		set := updates[0].Lookup("updates").Array().Index(0).Value().Document().Lookup("u", "$set").Document()
+		if phone := set.Lookup("phone").StringValue(); phone != "+15550199" {
+			mt.Errorf("phone stored as %q, want the trimmed number", phone)
+		}
+		if verified, ok := set.Lookup("phone_verified").BooleanOK(); !ok || verified {
+			mt.Errorf("phone_verified isn't reset: %v", set)
+		}
+	})
+
+	mt.Run("number of another account", func(mt *mtest.T) {
+		useMockCollections(mt)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "test.Users", mtest.FirstBatch, user),
+			mtest.CreateCursorResponse(0, "test.Users", mtest.FirstBatch, bson.D{{Key: "n", Value: 1}}),
+		)
+
+		w := serve(UpdateMe(), http.MethodPatch, gin.H{"phone": "+15550199"}, onAccount)
+		if w.Code != http.StatusConflict {
+			mt.Fatalf("status = %d, want %d: %s", w.Code, http.StatusConflict, w.Body)
+		}
+		if len(commandsOn(mt, "update", "Users")) != 0 {
+			mt.Error("the number of another account was stored")
+		}
+	})
+}
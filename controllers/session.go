@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"log"
+	"time"
+
+	"github/akhil/ecommerce-yt/database"
+	"github/akhil/ecommerce-yt/helpers"
+	"github/akhil/ecommerce-yt/models"
+	"github/akhil/ecommerce-yt/roles"
+	generate "github/akhil/ecommerce-yt/tokens"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// sessionView is a session as listed to its user
+type sessionView struct {
+	models.Session
+	Current bool `json:"current"`
+}
+
+// startSession creates a session for the device making the request and returns its tokens
+func startSession(c *gin.Context, user models.User) (token, refreshToken string, err error) {
+	sessionID := primitive.NewObjectID().Hex()
+
+	token, refreshToken, err = generate.TokenGenerator(*user.Email, *user.First_Name, *user.Last_Name, user.User_ID, sessionID, roles.EffectiveRoles(user.Roles, user.IsAdmin), user.Token_Version)
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	err = database.CreateSession(SessionCollection, models.Session{
+		Session_ID:         sessionID,
+		User_ID:            user.User_ID,
+		Refresh_Token_Hash: generate.HashOpaqueToken(refreshToken),
+		User_Agent:         c.Request.UserAgent(),
+		IP:                 c.ClientIP(),
+		Created_At:         now,
+		Last_Seen_At:       now,
+		Expires_At:         now.Add(generate.RefreshTokenTTL),
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return token, refreshToken, nil
+}
+
+// endAllSessions marks every session of a user as ended after their tokens were revoked
+func endAllSessions(userID string) {
+	if err := database.RevokeAllSessions(SessionCollection, userID); err != nil {
+		log.Printf("error ending sessions of user %s: %v", userID, err)
+	}
+}
+
+// ListSessions returns the devices the authenticated user is signed in on
+func ListSessions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetString("user_id")
+		currentID := c.GetString("session_id")
+
+		sessions, err := database.ListSessions(SessionCollection, userID)
+		if err != nil {
+			helpers.InternalServerError(c, "error fetching sessions")
+			return
+		}
+
+		views := make([]sessionView, len(sessions))
+		for i, session := range sessions {
+			views[i] = sessionView{Session: session, Current: session.Session_ID == currentID}
+		}
+
+		helpers.Success(c, "", views)
+	}
+}
+
+// RevokeSession signs the authenticated user out of one of their sessions
+func RevokeSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetString("user_id")
+		sessionID := c.Param("session_id")
+
+		err := database.RevokeSession(SessionCollection, RevokedTokenCollection, userID, sessionID)
+		if err != nil {
+			if err == database.ErrSessionNotFound {
+				helpers.NotFound(c, err.Error())
+				return
+			}
+			helpers.InternalServerError(c, "error revoking session")
+			return
+		}
+
+		recordAudit(c, "session_revoked", userID, userID, map[string]interface{}{"session_id": sessionID})
+
+		if sessionID == c.GetString("session_id") {
+			helpers.ClearSessionCookies(c)
+		}
+
+		helpers.Success(c, "Session revoked successfully", nil)
+	}
+}
@@ -0,0 +1,158 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github/akhil/ecommerce-yt/database"
+	"github/akhil/ecommerce-yt/helpers"
+	"github/akhil/ecommerce-yt/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// loginThrottle holds the login limits configured through the environment
+type loginThrottle struct {
+	// Failures allowed for one account before it is locked
+	MaxFailures int
+	// Failures allowed from one client IP, across accounts, before it is locked
+	MaxIPFailures int
+	Lockout       time.Duration
+}
+
+// loginThrottleFromEnv reads LOGIN_MAX_FAILURES, LOGIN_IP_MAX_FAILURES and LOGIN_LOCKOUT_MINUTES
+func loginThrottleFromEnv() loginThrottle {
+	return loginThrottle{
+		MaxFailures:   envInt("LOGIN_MAX_FAILURES", 5),
+		MaxIPFailures: envInt("LOGIN_IP_MAX_FAILURES", 20),
+		Lockout:       time.Duration(envInt("LOGIN_LOCKOUT_MINUTES", 15)) * time.Minute,
+	}
+}
+
+// envInt returns a positive integer environment variable, or fallback when it is unset or invalid
+func envInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// Keys that failed attempts are counted under
+func emailAttemptKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipAttemptKey(ip string) string {
+	return "ip:" + ip
+}
+
+func mfaAttemptKey(userID string) string {
+	return "mfa:" + userID
+}
+
+// allowLoginAttempt answers 429 and returns false while any of keys is blocked
+func allowLoginAttempt(c *gin.Context, keys ...string) bool {
+	wait, err := database.LoginRetryAfter(LoginAttemptCollection, keys)
+	if err != nil {
+		// Don't lock everyone out because the counters are unavailable
+		log.Printf("error checking login attempts: %v", err)
+		return true
+	}
+	if wait <= 0 {
+		return true
+	}
+
+	seconds := int(wait.Round(time.Second).Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	helpers.ErrorWithCode(c, http.StatusTooManyRequests, "login_throttled",
+		fmt.Sprintf("too many failed login attempts, try again in %d seconds", seconds))
+	return false
+}
+
+// recordLoginFailure counts a failed attempt under key and audits the failure that locks it.
+// userID is the account the attempt targeted, if known.
+func recordLoginFailure(c *gin.Context, key string, maxFailures int, userID string) {
+	throttle := loginThrottleFromEnv()
+
+	attempt, locked, err := database.RecordLoginFailure(LoginAttemptCollection, key, maxFailures, throttle.Lockout)
+	if err != nil {
+		log.Printf("error recording failed login for %s: %v", key, err)
+		return
+	}
+
+	if locked {
+		recordAudit(c, "login_locked", "", userID, map[string]interface{}{
+			"key":           key,
+			"failures":      attempt.Failures,
+			"blocked_until": attempt.Blocked_Until,
+		})
+	}
+}
+
+// recordPasswordFailure counts a failed password login against the email and the client IP
+func recordPasswordFailure(c *gin.Context, email, userID string) {
+	throttle := loginThrottleFromEnv()
+	recordLoginFailure(c, emailAttemptKey(email), throttle.MaxFailures, userID)
+	recordLoginFailure(c, ipAttemptKey(c.ClientIP()), throttle.MaxIPFailures, userID)
+}
+
+// clearLoginFailures forgets the failures of a key after a successful login. The client IP is
+// left alone so that one valid account can't be used to reset an IP's count.
+func clearLoginFailures(key string) {
+	if _, err := database.ClearLoginFailures(LoginAttemptCollection, key); err != nil {
+		log.Printf("error clearing failed logins for %s: %v", key, err)
+	}
+}
+
+// UnlockUser clears the failed login attempts of a user so a locked account can log in again
+func UnlockUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireUserCaller(c) {
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		adminID := c.GetString("user_id")
+		targetID := c.Param("user_id")
+
+		var foundUser models.User
+		err := UserCollection.FindOne(ctx, bson.M{"user_id": targetID, "deleted_at": bson.M{"$exists": false}}).Decode(&foundUser)
+		if err != nil {
+			helpers.NotFound(c, "user not found")
+			return
+		}
+
+		keys := []string{mfaAttemptKey(foundUser.User_ID)}
+		if foundUser.Email != nil {
+			keys = append(keys, emailAttemptKey(*foundUser.Email))
+		}
+
+		unlocked := false
+		for _, key := range keys {
+			cleared, err := database.ClearLoginFailures(LoginAttemptCollection, key)
+			if err != nil {
+				helpers.InternalServerError(c, "error unlocking user")
+				return
+			}
+			unlocked = unlocked || cleared
+		}
+
+		recordAudit(c, "login_unlocked", adminID, targetID, map[string]interface{}{
+			"had_failures": unlocked,
+		})
+
+		helpers.Success(c, "User unlocked successfully", gin.H{"user_id": targetID})
+	}
+}
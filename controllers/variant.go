@@ -0,0 +1,283 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github/akhil/ecommerce-yt/database"
+	"github/akhil/ecommerce-yt/helpers"
+	"github/akhil/ecommerce-yt/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// setOptionsRequest is the body accepted by SetProductOptions
+type setOptionsRequest struct {
+	Options []models.ProductOption `json:"options" validate:"max=5,dive"`
+}
+
+// updateVariantRequest is the body accepted by UpdateProductVariant. Omitted fields are left unchanged.
+type updateVariantRequest struct {
+	Price *uint64 `json:"price"`
+	Image *string `json:"image" validate:"omitempty,min=1"`
+}
+
+// checkOptions trims option names and values and rejects duplicates
+func checkOptions(productOptions []models.ProductOption) error {
+	names := map[string]bool{}
+	for i := range productOptions {
+		option := &productOptions[i]
+		option.Name = strings.TrimSpace(option.Name)
+		if option.Name == "" || names[option.Name] {
+			return errors.New("option names must be unique and not blank")
+		}
+		names[option.Name] = true
+
+		values := map[string]bool{}
+		for j, value := range option.Values {
+			value = strings.TrimSpace(value)
+			if value == "" || values[value] {
+				return fmt.Errorf("values of option %s must be unique and not blank", option.Name)
+			}
+			values[value] = true
+			option.Values[j] = value
+		}
+	}
+	return nil
+}
+
+// variantKey identifies a variant by its option values, in the order of the product's options
+func variantKey(productOptions []models.ProductOption, variant models.ProductVariant) string {
+	values := make([]string, 0, len(productOptions))
+	for _, option := range productOptions {
+		values = append(values, variant.Options[option.Name])
+	}
+	return strings.Join(values, "\x00")
+}
+
+// checkVariant makes sure variant has exactly one allowed value for each option and differs
+// from the existing variants in SKU and option values. A missing stock defaults to 1.
+func checkVariant(productOptions []models.ProductOption, existing []models.ProductVariant, variant *models.ProductVariant) error {
+	variant.SKU = strings.TrimSpace(variant.SKU)
+	if variant.SKU == "" {
+		return errors.New("sku is required")
+	}
+	if len(productOptions) == 0 {
+		return errors.New("set the product's options before adding variants")
+	}
+	if len(variant.Options) != len(productOptions) {
+		return fmt.Errorf("variant %s must have a value for each option", variant.SKU)
+	}
+	for _, option := range productOptions {
+		value, ok := variant.Options[option.Name]
+		if !ok {
+			return fmt.Errorf("variant %s has no value for option %s", variant.SKU, option.Name)
+		}
+		allowed := false
+		for _, v := range option.Values {
+			if v == value {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("variant %s has an unknown value for option %s: %s", variant.SKU, option.Name, value)
+		}
+	}
+
+	key := variantKey(productOptions, *variant)
+	for _, other := range existing {
+		if other.SKU == variant.SKU {
+			return database.ErrDuplicateSKU
+		}
+		if variantKey(productOptions, other) == key {
+			return fmt.Errorf("variant %s has the same options as variant %s", variant.SKU, other.SKU)
+		}
+	}
+
+	if variant.Stock == nil {
+		stock := int64(1)
+		variant.Stock = &stock
+	}
+	return nil
+}
+
+// prepareVariants checks the options and variants of a new product. A product with variants
+// gets the lowest variant price and the total variant stock.
+func prepareVariants(product *models.Product) error {
+	if err := checkOptions(product.Options); err != nil {
+		return err
+	}
+	if len(product.Variants) == 0 {
+		return nil
+	}
+
+	var stock int64
+	for i := range product.Variants {
+		if err := checkVariant(product.Options, product.Variants[:i], &product.Variants[i]); err != nil {
+			return err
+		}
+		variant := product.Variants[i]
+		stock += *variant.Stock
+		if product.Price == nil || *variant.Price < *product.Price {
+			price := *variant.Price
+			product.Price = &price
+		}
+	}
+	product.Stock = &stock
+	return nil
+}
+
+// SetProductOptions replaces the option axes, such as size and color, of a product without variants
+func SetProductOptions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, ok := productIDParam(c)
+		if !ok {
+			return
+		}
+
+		var req setOptionsRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+		if err := validate.Struct(req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+		if err := checkOptions(req.Options); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+
+		product, err := database.SetProductOptions(ProductCollection, productID, req.Options)
+		if err != nil {
+			handleProductError(c, err, "updating")
+			return
+		}
+		recordAudit(c, "product_options_set", c.GetString("user_id"), productID.Hex(), map[string]interface{}{
+			"options": req.Options,
+		})
+
+		helpers.Success(c, "Product options updated successfully", product)
+	}
+}
+
+// AddProductVariant adds a variant with its own SKU, price, stock and image to a product
+func AddProductVariant() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, ok := productIDParam(c)
+		if !ok {
+			return
+		}
+
+		var variant models.ProductVariant
+		if err := c.ShouldBindJSON(&variant); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+		if err := validate.Struct(variant); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+
+		product, err := database.FindProduct(ProductCollection, productID)
+		if err != nil {
+			handleProductError(c, err, "fetching")
+			return
+		}
+		if err := checkVariant(product.Options, product.Variants, &variant); err != nil {
+			if err == database.ErrDuplicateSKU {
+				handleProductError(c, err, "adding variant to")
+				return
+			}
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+
+		product, err = database.AddVariant(ProductCollection, productID, variant)
+		if err != nil {
+			handleProductError(c, err, "adding variant to")
+			return
+		}
+		recordAudit(c, "product_variant_added", c.GetString("user_id"), productID.Hex(), map[string]interface{}{
+			"sku":     variant.SKU,
+			"options": variant.Options,
+			"price":   variant.Price,
+			"stock":   variant.Stock,
+		})
+
+		helpers.Success(c, "Variant added successfully", product)
+	}
+}
+
+// UpdateProductVariant changes the price or image of a variant. Its stock is changed with the
+// restock and adjust endpoints.
+func UpdateProductVariant() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, ok := productIDParam(c)
+		if !ok {
+			return
+		}
+		sku := c.Param("sku")
+
+		var req updateVariantRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+		if err := validate.Struct(req); err != nil {
+			helpers.BadRequest(c, err.Error())
+			return
+		}
+
+		fields := bson.M{}
+		if req.Price != nil {
+			fields["price"] = *req.Price
+		}
+		if req.Image != nil {
+			fields["image"] = *req.Image
+		}
+		if len(fields) == 0 {
+			helpers.BadRequest(c, "no fields to update")
+			return
+		}
+
+		product, err := database.UpdateVariant(ProductCollection, productID, sku, fields)
+		if err != nil {
+			handleProductError(c, err, "updating variant of")
+			return
+		}
+		recordAudit(c, "product_variant_updated", c.GetString("user_id"), productID.Hex(), map[string]interface{}{
+			"sku":   sku,
+			"price": req.Price,
+			"image": req.Image,
+		})
+
+		helpers.Success(c, "Variant updated successfully", product)
+	}
+}
+
+// RemoveProductVariant removes a variant and its stock from a product
+func RemoveProductVariant() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, ok := productIDParam(c)
+		if !ok {
+			return
+		}
+		sku := c.Param("sku")
+
+		product, err := database.RemoveVariant(ProductCollection, productID, sku)
+		if err != nil {
+			handleProductError(c, err, "removing variant of")
+			return
+		}
+		recordAudit(c, "product_variant_removed", c.GetString("user_id"), productID.Hex(), map[string]interface{}{
+			"sku": sku,
+		})
+
+		helpers.Success(c, "Variant removed successfully", product)
+	}
+}
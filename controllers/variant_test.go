@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	"github/akhil/ecommerce-yt/database"
+	"github/akhil/ecommerce-yt/models"
+)
+
+func uint64Ptr(v uint64) *uint64 { return &v }
+
+func int64Ptr(v int64) *int64 { return &v }
+
+// testOptions returns the options of a t-shirt sold in two sizes and two colors
+func testOptions() []models.ProductOption {
+	return []models.ProductOption{
+		{Name: "size", Values: []string{"M", "L"}},
+		{Name: "color", Values: []string{"red", "blue"}},
+	}
+}
+
+func TestCheckOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []models.ProductOption
+		want    []models.ProductOption
+		wantErr bool
+	}{
+		{"no options", nil, nil, false},
+		{"trims names and values",
+			[]models.ProductOption{{Name: " size ", Values: []string{" M", "L "}}},
+			[]models.ProductOption{{Name: "size", Values: []string{"M", "L"}}}, false},
+		{"blank name", []models.ProductOption{{Name: "  ", Values: []string{"M"}}}, nil, true},
+		{"duplicate name", []models.ProductOption{{Name: "size", Values: []string{"M"}}, {Name: "size ", Values: []string{"L"}}}, nil, true},
+		{"blank value", []models.ProductOption{{Name: "size", Values: []string{"M", " "}}}, nil, true},
+		{"duplicate value", []models.ProductOption{{Name: "size", Values: []string{"M", " M"}}}, nil, true},
+		// Values only need to be unique within their option
+		{"same value in two options",
+			[]models.ProductOption{{Name: "size", Values: []string{"one"}}, {Name: "fit", Values: []string{"one"}}},
+			[]models.ProductOption{{Name: "size", Values: []string{"one"}}, {Name: "fit", Values: []string{"one"}}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkOptions(tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkOptions() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(tt.options, tt.want) {
+				t.Errorf("checkOptions() left %v, want %v", tt.options, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckVariant(t *testing.T) {
+	existing := []models.ProductVariant{
+		{SKU: "TEE-M-RED", Options: map[string]string{"size": "M", "color": "red"}, Price: uint64Ptr(20), Stock: int64Ptr(3)},
+	}
+
+	tests := []struct {
+		name    string
+		options []models.ProductOption
+		variant models.ProductVariant
+		wantErr bool
+		// is, when set, is the error handlers compare against
+		is error
+	}{
+		{"valid", testOptions(), models.ProductVariant{SKU: "TEE-L-BLUE", Options: map[string]string{"size": "L", "color": "blue"}}, false, nil},
+		{"blank sku", testOptions(), models.ProductVariant{SKU: " ", Options: map[string]string{"size": "L", "color": "blue"}}, true, nil},
+		{"product without options", nil, models.ProductVariant{SKU: "TEE", Options: map[string]string{}}, true, nil},
+		{"missing a value", testOptions(), models.ProductVariant{SKU: "TEE-L", Options: map[string]string{"size": "L"}}, true, nil},
+		{"extra value", testOptions(), models.ProductVariant{SKU: "TEE-L-BLUE", Options: map[string]string{"size": "L", "color": "blue", "fit": "slim"}}, true, nil},
+		{"unknown option", testOptions(), models.ProductVariant{SKU: "TEE-L-SLIM", Options: map[string]string{"size": "L", "fit": "slim"}}, true, nil},
+		{"unknown value", testOptions(), models.ProductVariant{SKU: "TEE-XL-BLUE", Options: map[string]string{"size": "XL", "color": "blue"}}, true, nil},
+		{"duplicate sku", testOptions(), models.ProductVariant{SKU: " TEE-M-RED ", Options: map[string]string{"size": "L", "color": "blue"}}, true, database.ErrDuplicateSKU},
+		{"same options as another variant", testOptions(), models.ProductVariant{SKU: "TEE-M-RED-2", Options: map[string]string{"size": "M", "color": "red"}}, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkVariant(tt.options, existing, &tt.variant)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkVariant() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.is != nil && err != tt.is {
+				t.Errorf("checkVariant() = %v, want %v", err, tt.is)
+			}
+		})
+	}
+}
+
+func TestCheckVariantStock(t *testing.T) {
+	tests := []struct {
+		name  string
+		stock *int64
+		want  int64
+	}{
+		{"defaults to 1", nil, 1},
+		{"keeps the given stock", int64Ptr(7), 7},
+		{"keeps zero", int64Ptr(0), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			variant := models.ProductVariant{SKU: "TEE-L-BLUE", Options: map[string]string{"size": "L", "color": "blue"}, Stock: tt.stock}
+			if err := checkVariant(testOptions(), nil, &variant); err != nil {
+				t.Fatalf("checkVariant: %v", err)
+			}
+			if *variant.Stock != tt.want {
+				t.Errorf("stock = %d, want %d", *variant.Stock, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrepareVariants(t *testing.T) {
+	product := models.Product{
+		Options: testOptions(),
+		Variants: []models.ProductVariant{
+			{SKU: "TEE-M-RED", Options: map[string]string{"size": "M", "color": "red"}, Price: uint64Ptr(25), Stock: int64Ptr(4)},
+			{SKU: "TEE-L-RED", Options: map[string]string{"size": "L", "color": "red"}, Price: uint64Ptr(20), Stock: int64Ptr(0)},
+			{SKU: "TEE-L-BLUE", Options: map[string]string{"size": "L", "color": "blue"}, Price: uint64Ptr(30)},
+		},
+	}
+	if err := prepareVariants(&product); err != nil {
+		t.Fatalf("prepareVariants: %v", err)
+	}
+	// The listing shows the lowest variant price and the stock of all variants
+	if *product.Price != 20 {
+		t.Errorf("price = %d, want the lowest variant price 20", *product.Price)
+	}
+	if *product.Stock != 5 {
+		t.Errorf("stock = %d, want 5", *product.Stock)
+	}
+
+	duplicate := models.Product{
+		Options: testOptions(),
+		Variants: []models.ProductVariant{
+			{SKU: "TEE-M-RED", Options: map[string]string{"size": "M", "color": "red"}, Price: uint64Ptr(25)},
+			{SKU: "TEE-M-RED", Options: map[string]string{"size": "L", "color": "red"}, Price: uint64Ptr(25)},
+		},
+	}
+	if err := prepareVariants(&duplicate); err != database.ErrDuplicateSKU {
+		t.Errorf("prepareVariants() with a repeated sku = %v, want %v", err, database.ErrDuplicateSKU)
+	}
+
+	plain := models.Product{Price: uint64Ptr(15), Stock: int64Ptr(2)}
+	if err := prepareVariants(&plain); err != nil || *plain.Price != 15 || *plain.Stock != 2 {
+		t.Errorf("prepareVariants() changed a product without variants: %v", err)
+	}
+}
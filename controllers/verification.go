@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"time"
+
+	"github/akhil/ecommerce-yt/database"
+	"github/akhil/ecommerce-yt/helpers"
+	"github/akhil/ecommerce-yt/mailer"
+	"github/akhil/ecommerce-yt/models"
+	generate "github/akhil/ecommerce-yt/tokens"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// emailVerificationURL builds the link sent in verification emails from EMAIL_VERIFICATION_URL
+func emailVerificationURL(token string) string {
+	base := os.Getenv("EMAIL_VERIFICATION_URL")
+	if base == "" {
+		base = "http://localhost:8000/api/v1/users/verify-email"
+	}
+	return base + "?token=" + url.QueryEscape(token)
+}
+
+// sendVerificationEmail emails a signed verification link for the user's current address
+func sendVerificationEmail(ctx context.Context, user models.User) error {
+	token, err := generate.GenerateEmailVerificationToken(user.User_ID, *user.Email)
+	if err != nil {
+		return err
+	}
+
+	return Mailer.Send(ctx, mailer.Message{
+		To:      *user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening the link below. It expires in 24 hours.\n\n%s\n",
+			*user.First_Name, emailVerificationURL(token)),
+	})
+}
+
+// VerifyEmail marks the email address in a verification link as verified
+func VerifyEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Query("token")
+		if token == "" {
+			helpers.BadRequest(c, "token is required")
+			return
+		}
+
+		claims, msg := generate.ValidateEmailVerificationToken(token)
+		if msg != "" {
+			helpers.BadRequest(c, "verification link is invalid or has expired")
+			return
+		}
+
+		err := database.MarkEmailVerified(UserCollection, claims.User_ID, claims.Email)
+		if err != nil {
+			if err == database.ErrCantFindUser {
+				helpers.BadRequest(c, "verification link is no longer valid")
+				return
+			}
+			helpers.InternalServerError(c, "error verifying email")
+			return
+		}
+
+		helpers.Success(c, "Email verified successfully", nil)
+	}
+}
+
+// ResendVerificationEmail sends a new verification link to the authenticated user
+func ResendVerificationEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		userID, exists := c.Get("user_id")
+		if !exists {
+			helpers.Unauthorized(c, "user not authenticated")
+			return
+		}
+
+		var foundUser models.User
+		err := UserCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&foundUser)
+		if err != nil {
+			helpers.NotFound(c, "user not found")
+			return
+		}
+
+		if foundUser.Email_Verified {
+			helpers.BadRequest(c, "email address is already verified")
+			return
+		}
+
+		if err := sendVerificationEmail(ctx, foundUser); err != nil {
+			log.Printf("error sending verification email to user %s: %v", foundUser.User_ID, err)
+			helpers.InternalServerError(c, "error sending verification email")
+			return
+		}
+
+		helpers.Success(c, "Verification email sent", nil)
+	}
+}
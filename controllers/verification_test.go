@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	generate "github/akhil/ecommerce-yt/tokens"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestVerifyEmail(t *testing.T) {
+	useTestKeys(t)
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	verification, err := generate.GenerateEmailVerificationToken("user-1", "jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	access, _, err := generate.TokenGenerator("jane@example.com", "Jane", "Doe", "user-1", "session-1", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		matched int32
+		want    int
+	}{
+		{"valid link", verification, 1, http.StatusOK},
+		// The account's address changed after the link was sent
+		{"address changed", verification, 0, http.StatusBadRequest},
+		{"access token", access, 0, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			useMockCollections(mt)
+			mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: tt.matched}, {Key: "nModified", Value: tt.matched}})
+
+			w := serve(VerifyEmail(), http.MethodGet, nil, func(c *gin.Context) {
+				c.Request.URL.RawQuery = "token=" + url.QueryEscape(tt.token)
+			})
+			if w.Code != tt.want {
+				mt.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+
+			updates := commandsOn(mt, "update", "Users")
+			if tt.token == access {
+				if len(updates) != 0 {
+					mt.Error("an access token verified the email address")
+				}
+				return
+			}
+			if len(updates) != 1 {
+				mt.Fatalf("%d updates of the user, want 1", len(updates))
+			}
+			// Only the address the link was sent to is marked as verified
+			filter := updates[0].Lookup("updates").Array().Index(0).Value().Document().Lookup("q")
+			if email := filter.Document().Lookup("email").StringValue(); email != "jane@example.com" {
+				mt.Errorf("update matches email %q, want the address of the link", email)
+			}
+		})
+	}
+}
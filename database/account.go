@@ -0,0 +1,89 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// AnonymizeUser removes the personal data of a deleted account. The record itself is kept,
+// without anything that identifies the person, so its orders stay in the books. Every
+// token of the user is revoked and the account can no longer log in.
+func AnonymizeUser(userCollection *mongo.Collection, userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	result, err := userCollection.UpdateOne(ctx,
+		bson.M{"user_id": userID, "deleted_at": bson.M{"$exists": false}},
+		bson.M{
+			"$set": bson.M{
+				"first_name":      nil,
+				"last_name":       nil,
+				"email":           nil,
+				"phone":           nil,
+				"password":        nil,
+				"token":           nil,
+				"refresh_token":   nil,
+				"email_verified":  false,
+				"phone_verified":  false,
+				"is_admin":        false,
+				"roles":           []string{},
+				"mfa_enabled":     false,
+				"user_cart":       []interface{}{},
+				"address_details": []interface{}{},
+				"deleted_at":      now,
+				"updated_at":      now,
+			},
+			"$unset": bson.M{
+				"email_verified_at":  "",
+				"phone_verified_at":  "",
+				"pending_email":      "",
+				"mfa_secret":         "",
+				"mfa_pending_secret": "",
+				"mfa_recovery_codes": "",
+				"mfa_last_step":      "",
+				"identities":         "",
+			},
+			"$inc": bson.M{"token_version": 1},
+		},
+	)
+	if err != nil {
+		return ErrCantUpdateUser
+	}
+	if result.MatchedCount == 0 {
+		return ErrCantFindUser
+	}
+
+	InvalidateUserAuthState(userID)
+	return nil
+}
+
+// ScrubAuditLogs removes client IPs and email addresses from the audit entries of a user.
+// The entries themselves are kept as a record of what happened to the account.
+func ScrubAuditLogs(auditCollection *mongo.Collection, userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := auditCollection.UpdateMany(ctx,
+		bson.M{"$or": []bson.M{{"actor_id": userID}, {"target_id": userID}}},
+		bson.M{"$unset": bson.M{
+			"ip":                "",
+			"details.email":     "",
+			"details.old_email": "",
+			"details.new_email": "",
+		}},
+	)
+	return err
+}
+
+// DeletePasswordResets discards every password reset token of a user
+func DeletePasswordResets(resetCollection *mongo.Collection, userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := resetCollection.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
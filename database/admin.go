@@ -0,0 +1,162 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github/akhil/ecommerce-yt/models"
+	"github/akhil/ecommerce-yt/tokens"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrInvalidInvite  = errors.New("invite code is invalid, expired or already used")
+	ErrInviteNotFound = errors.New("invite not found")
+	ErrInviteClosed   = errors.New("invite has already been used or revoked")
+)
+
+// CreateAdminInvite stores a new invite and returns it along with the clear-text code.
+// The code is only ever returned here; the database keeps its hash.
+func CreateAdminInvite(inviteCollection *mongo.Collection, createdBy string, email *string, role string, ttl time.Duration) (models.AdminInvite, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	code, err := tokens.GenerateOpaqueToken(24)
+	if err != nil {
+		return models.AdminInvite{}, "", err
+	}
+
+	now := time.Now()
+	invite := models.AdminInvite{
+		Invite_ID:  primitive.NewObjectID(),
+		Code_Hash:  tokens.HashOpaqueToken(code),
+		Email:      email,
+		Role:       role,
+		Created_By: createdBy,
+		Created_At: now,
+		Expires_At: now.Add(ttl),
+	}
+
+	if _, err := inviteCollection.InsertOne(ctx, invite); err != nil {
+		return models.AdminInvite{}, "", err
+	}
+
+	return invite, code, nil
+}
+
+// ListAdminInvites returns all invites, newest first
+func ListAdminInvites(inviteCollection *mongo.Collection) ([]models.AdminInvite, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := inviteCollection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	invites := make([]models.AdminInvite, 0)
+	if err := cursor.All(ctx, &invites); err != nil {
+		return nil, err
+	}
+	return invites, nil
+}
+
+// RevokeAdminInvite marks an unused invite as revoked
+func RevokeAdminInvite(inviteCollection *mongo.Collection, inviteID primitive.ObjectID, revokedBy string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	result, err := inviteCollection.UpdateOne(ctx,
+		bson.M{"invite_id": inviteID, "used_at": bson.M{"$exists": false}, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": now, "revoked_by": revokedBy}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		count, err := inviteCollection.CountDocuments(ctx, bson.M{"invite_id": inviteID})
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrInviteNotFound
+		}
+		return ErrInviteClosed
+	}
+	return nil
+}
+
+// RedeemAdminInvite atomically marks the invite matching code as used by userID.
+// Invites restricted to an email can only be redeemed with that email.
+func RedeemAdminInvite(inviteCollection *mongo.Collection, code, email, userID string) (models.AdminInvite, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{
+		"code_hash":  tokens.HashOpaqueToken(code),
+		"used_at":    bson.M{"$exists": false},
+		"revoked_at": bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": now},
+		"$or": []bson.M{
+			{"email": bson.M{"$exists": false}},
+			{"email": email},
+		},
+	}
+	update := bson.M{"$set": bson.M{"used_at": now, "used_by": userID}}
+
+	var invite models.AdminInvite
+	err := inviteCollection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&invite)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return models.AdminInvite{}, ErrInvalidInvite
+		}
+		return models.AdminInvite{}, err
+	}
+	return invite, nil
+}
+
+// ReleaseAdminInvite makes a redeemed invite usable again, used when account creation fails after redemption
+func ReleaseAdminInvite(inviteCollection *mongo.Collection, inviteID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := inviteCollection.UpdateOne(ctx,
+		bson.M{"invite_id": inviteID},
+		bson.M{"$unset": bson.M{"used_at": "", "used_by": ""}},
+	)
+	return err
+}
+
+// SetUserRoles replaces the roles of a user. is_admin is kept in sync so it still marks staff accounts.
+func SetUserRoles(userCollection *mongo.Collection, userID string, userRoles []string, isStaff bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := userCollection.UpdateOne(ctx,
+		bson.M{"user_id": userID},
+		bson.M{"$set": bson.M{
+			"roles":      userRoles,
+			"is_admin":   isStaff,
+			"updated_at": time.Now(),
+		}},
+	)
+	if err != nil {
+		return ErrCantUpdateUser
+	}
+	if result.MatchedCount == 0 {
+		return ErrCantFindUser
+	}
+
+	// Demotions must apply to tokens that still carry the old roles
+	InvalidateUserAuthState(userID)
+	return nil
+}
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github/akhil/ecommerce-yt/tokens"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestAdminInviteStoresOnlyTheHash(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("create", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		invite, code, err := CreateAdminInvite(mt.Coll, "admin-1", nil, "support", time.Hour)
+		if err != nil {
+			mt.Fatalf("CreateAdminInvite: %v", err)
+		}
+		if invite.Code_Hash != tokens.HashOpaqueToken(code) {
+			mt.Error("the stored hash isn't the hash of the returned code")
+		}
+
+		stored := mt.GetStartedEvent().Command.Lookup("documents").Array().Index(0).Value().Document()
+		if strings.Contains(stored.String(), code) {
+			mt.Errorf("the clear-text code was stored: %v", stored)
+		}
+	})
+}
+
+func TestRedeemAdminInvite(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("unknown, used, revoked or expired", func(mt *mtest.T) {
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: nil}})
+
+		if _, err := RedeemAdminInvite(mt.Coll, "code-1", "jane@example.com", "user-1"); err != ErrInvalidInvite {
+			mt.Fatalf("RedeemAdminInvite() = %v, want %v", err, ErrInvalidInvite)
+		}
+
+		// A single conditional update claims the invite, so two signups can't both use it
+		command := mt.GetStartedEvent().Command
+		filter := command.Lookup("query").Document()
+		if hash := filter.Lookup("code_hash").StringValue(); hash != tokens.HashOpaqueToken("code-1") {
+			mt.Errorf("filter matches code_hash %q, want the hash of the code", hash)
+		}
+		for _, field := range []string{"used_at", "revoked_at"} {
+			if exists, err := filter.LookupErr(field, "$exists"); err != nil || exists.Boolean() {
+				mt.Errorf("filter doesn't exclude invites with %s", field)
+			}
+		}
+		if _, err := filter.LookupErr("expires_at", "$gt"); err != nil {
+			mt.Error("filter doesn't exclude expired invites")
+		}
+		if used := command.Lookup("update", "$set", "used_by").StringValue(); used != "user-1" {
+			mt.Errorf("update sets used_by %q, want user-1", used)
+		}
+	})
+
+	mt.Run("open invite", func(mt *mtest.T) {
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: bson.D{
+			{Key: "role", Value: "catalog_manager"},
+			{Key: "used_by", Value: "user-1"},
+		}}})
+
+		invite, err := RedeemAdminInvite(mt.Coll, "code-1", "jane@example.com", "user-1")
+		if err != nil {
+			mt.Fatalf("RedeemAdminInvite: %v", err)
+		}
+		if invite.Role != "catalog_manager" {
+			mt.Errorf("role = %q, want catalog_manager", invite.Role)
+		}
+	})
+}
@@ -0,0 +1,129 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github/akhil/ecommerce-yt/models"
+	"github/akhil/ecommerce-yt/tokens"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrInvalidAPIKey  = errors.New("API key is invalid, expired or revoked")
+	ErrAPIKeyNotFound = errors.New("API key not found")
+	ErrAPIKeyRevoked  = errors.New("API key has already been revoked")
+)
+
+// APIKeyPrefix starts every API key so leaked keys are easy to recognise
+const APIKeyPrefix = "ek_"
+
+// CreateAPIKey stores a new API key and returns it along with the clear-text key.
+// The key is only ever returned here; the database keeps its hash. A nil expiresAt never expires.
+func CreateAPIKey(apiKeyCollection *mongo.Collection, createdBy, name string, scopes []string, expiresAt *time.Time) (models.APIKey, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	secret, err := tokens.GenerateOpaqueToken(32)
+	if err != nil {
+		return models.APIKey{}, "", err
+	}
+	key := APIKeyPrefix + secret
+
+	apiKey := models.APIKey{
+		Key_ID:     primitive.NewObjectID(),
+		Name:       name,
+		Prefix:     key[:len(APIKeyPrefix)+6],
+		Key_Hash:   tokens.HashOpaqueToken(key),
+		Scopes:     scopes,
+		Created_By: createdBy,
+		Created_At: time.Now(),
+		Expires_At: expiresAt,
+	}
+
+	if _, err := apiKeyCollection.InsertOne(ctx, apiKey); err != nil {
+		return models.APIKey{}, "", err
+	}
+
+	return apiKey, key, nil
+}
+
+// ListAPIKeys returns all API keys, newest first
+func ListAPIKeys(apiKeyCollection *mongo.Collection) ([]models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := apiKeyCollection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	keys := make([]models.APIKey, 0)
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey marks an API key as revoked; it stops working immediately
+func RevokeAPIKey(apiKeyCollection *mongo.Collection, keyID primitive.ObjectID, revokedBy string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	result, err := apiKeyCollection.UpdateOne(ctx,
+		bson.M{"key_id": keyID, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": now, "revoked_by": revokedBy}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		count, err := apiKeyCollection.CountDocuments(ctx, bson.M{"key_id": keyID})
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrAPIKeyNotFound
+		}
+		return ErrAPIKeyRevoked
+	}
+	return nil
+}
+
+// UseAPIKey looks up the active API key matching key and records the use from ip
+func UseAPIKey(apiKeyCollection *mongo.Collection, key, ip string) (models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{
+		"key_hash":   tokens.HashOpaqueToken(key),
+		"revoked_at": bson.M{"$exists": false},
+		"$or": []bson.M{
+			{"expires_at": bson.M{"$exists": false}},
+			{"expires_at": bson.M{"$gt": now}},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{"last_used_at": now, "last_used_ip": ip},
+		"$inc": bson.M{"use_count": 1},
+	}
+
+	var apiKey models.APIKey
+	err := apiKeyCollection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&apiKey)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return models.APIKey{}, ErrInvalidAPIKey
+		}
+		return models.APIKey{}, err
+	}
+	return apiKey, nil
+}
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github/akhil/ecommerce-yt/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RecordAudit stores an audit log entry
+func RecordAudit(auditCollection *mongo.Collection, entry models.AuditLog) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if entry.Audit_ID.IsZero() {
+		entry.Audit_ID = primitive.NewObjectID()
+	}
+	if entry.Created_At.IsZero() {
+		entry.Created_At = time.Now()
+	}
+
+	_, err := auditCollection.InsertOne(ctx, entry)
+	return err
+}
+
+// ListAuditLogs returns a page of audit log entries, newest first, optionally filtered by action
+func ListAuditLogs(auditCollection *mongo.Collection, action string, skip, limit int64) ([]models.AuditLog, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	if action != "" {
+		filter["action"] = action
+	}
+
+	total, err := auditCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(skip).
+		SetLimit(limit)
+
+	cursor, err := auditCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	logs := make([]models.AuditLog, 0)
+	if err := cursor.All(ctx, &logs); err != nil {
+		return nil, 0, err
+	}
+	return logs, total, nil
+}
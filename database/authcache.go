@@ -0,0 +1,104 @@
+package database
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// UserAuthState is the part of a user record needed to authorize a request
+type UserAuthState struct {
+	Roles         []string `bson:"roles"`
+	IsAdmin       bool     `bson:"is_admin"`
+	Token_Version int      `bson:"token_version"`
+}
+
+type authCacheEntry struct {
+	state    UserAuthState
+	loadedAt time.Time
+}
+
+// authCacheTTL bounds how long a role or token version change can go unnoticed by
+// other instances. Changes made by this instance invalidate the entry immediately.
+var authCacheTTL = loadAuthCacheTTL()
+
+// authCache only holds entries younger than authCacheTTL. Expired entries are swept at most
+// once per TTL when a new entry is stored, so users that stop sending requests don't stay
+// in memory until a restart.
+var authCache = struct {
+	sync.RWMutex
+	entries   map[string]authCacheEntry
+	lastSweep time.Time
+}{entries: make(map[string]authCacheEntry)}
+
+func loadAuthCacheTTL() time.Duration {
+	seconds, err := strconv.Atoi(os.Getenv("AUTH_CACHE_TTL_SECONDS"))
+	if err != nil || seconds < 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// GetUserAuthState returns the roles and token version of a user, served from an
+// in-process cache when the entry is younger than AUTH_CACHE_TTL_SECONDS
+func GetUserAuthState(userCollection *mongo.Collection, userID string) (UserAuthState, error) {
+	authCache.RLock()
+	entry, ok := authCache.entries[userID]
+	authCache.RUnlock()
+	if ok {
+		if time.Since(entry.loadedAt) < authCacheTTL {
+			return entry.state, nil
+		}
+		InvalidateUserAuthState(userID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var state UserAuthState
+	opts := options.FindOne().SetProjection(bson.M{"roles": 1, "is_admin": 1, "token_version": 1})
+	err := userCollection.FindOne(ctx, bson.M{"user_id": userID}, opts).Decode(&state)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return UserAuthState{}, ErrCantFindUser
+		}
+		return UserAuthState{}, err
+	}
+
+	storeUserAuthState(userID, state, time.Now())
+
+	return state, nil
+}
+
+// storeUserAuthState caches the state of a user loaded at now and sweeps expired entries
+func storeUserAuthState(userID string, state UserAuthState, now time.Time) {
+	if authCacheTTL <= 0 {
+		return
+	}
+
+	authCache.Lock()
+	defer authCache.Unlock()
+
+	if now.Sub(authCache.lastSweep) >= authCacheTTL {
+		for id, entry := range authCache.entries {
+			if now.Sub(entry.loadedAt) >= authCacheTTL {
+				delete(authCache.entries, id)
+			}
+		}
+		authCache.lastSweep = now
+	}
+	authCache.entries[userID] = authCacheEntry{state: state, loadedAt: now}
+}
+
+// InvalidateUserAuthState drops the cached state of a user; call it whenever roles or the token version change
+func InvalidateUserAuthState(userID string) {
+	authCache.Lock()
+	delete(authCache.entries, userID)
+	authCache.Unlock()
+}
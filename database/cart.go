@@ -21,18 +21,52 @@ var (
 	ErrCantGetItem          = errors.New("no item in cart")
 	ErrCantBuyCartItem      = errors.New("cannot buy cart item")
 	ErrProductAlreadyInCart = errors.New("product already in cart")
-	ErrProductAlreadySold   = errors.New("product has already been sold")
 	ErrDuplicateOrder       = errors.New("order already processed")
+	ErrEmailNotVerified     = errors.New("email address has not been verified")
 )
 
-// AddProductToCart adds a product to the user's cart
-func AddProductToCart(productCollection, userCollection *mongo.Collection, productID primitive.ObjectID, userID string) error {
+// cartItem converts a product, or its variant when sku is set, to the ProductUser format used in
+// carts and orders. Products with variants can only be bought by variant.
+func cartItem(product models.Product, sku string) (models.ProductUser, error) {
+	price := int(*product.Price)
+	rating := uint(*product.Rating)
+	item := models.ProductUser{
+		Product_ID:   product.Product_ID,
+		Product_Name: product.Product_Name,
+		Price:        &price,
+		Rating:       &rating,
+		Image:        product.Image,
+	}
+
+	if sku == "" {
+		if len(product.Variants) > 0 {
+			return item, ErrVariantRequired
+		}
+		return item, nil
+	}
+
+	variant, ok := FindVariant(product, sku)
+	if !ok {
+		return item, ErrCantFindVariant
+	}
+	*item.Price = int(*variant.Price)
+	item.SKU = variant.SKU
+	item.Options = variant.Options
+	if variant.Image != nil {
+		item.Image = variant.Image
+	}
+	return item, nil
+}
+
+// AddProductToCart adds a product to the user's cart. sku chooses the variant of a product with variants.
+func AddProductToCart(productCollection, userCollection *mongo.Collection, productID primitive.ObjectID, sku, userID string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	// Find the product
 	var product models.Product
-	err := productCollection.FindOne(ctx, bson.M{"product_id": productID}).Decode(&product)
+	// Archived products are off sale
+	err := productCollection.FindOne(ctx, bson.M{"product_id": productID, "archived_at": NotArchived}).Decode(&product)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return ErrCantFindProduct
@@ -40,6 +74,19 @@ func AddProductToCart(productCollection, userCollection *mongo.Collection, produ
 		return ErrCantDecodeProducts
 	}
 
+	productUser, err := cartItem(product, sku)
+	if err != nil {
+		return err
+	}
+	stock := product.Stock
+	if sku != "" {
+		variant, _ := FindVariant(product, sku)
+		stock = variant.Stock
+	}
+	if stock == nil || *stock < 1 {
+		return ErrOutOfStock
+	}
+
 	// Find the user
 	var user models.User
 	err = userCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&user)
@@ -52,22 +99,11 @@ func AddProductToCart(productCollection, userCollection *mongo.Collection, produ
 
 	// Check if product already exists in cart
 	for _, item := range user.User_Cart {
-		if item.Product_ID == productID {
+		if item.Product_ID == productID && item.SKU == sku {
 			return ErrProductAlreadyInCart
 		}
 	}
 
-	// Convert product to ProductUser format
-	price := int(*product.Price)
-	rating := uint(*product.Rating)
-	productUser := models.ProductUser{
-		Product_ID:   product.Product_ID,
-		Product_Name: product.Product_Name,
-		Price:        &price,
-		Rating:       &rating,
-		Image:        product.Image,
-	}
-
 	// Add product to cart
 	user.User_Cart = append(user.User_Cart, productUser)
 
@@ -93,8 +129,9 @@ func AddProductToCart(productCollection, userCollection *mongo.Collection, produ
 	return nil
 }
 
-// RemoveProductFromCart removes a product from the user's cart
-func RemoveProductFromCart(userCollection *mongo.Collection, productID primitive.ObjectID, userID string) error {
+// RemoveProductFromCart removes a product from the user's cart. When sku is set only that variant
+// is removed, otherwise every variant of the product is.
+func RemoveProductFromCart(userCollection *mongo.Collection, productID primitive.ObjectID, sku, userID string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -112,7 +149,7 @@ func RemoveProductFromCart(userCollection *mongo.Collection, productID primitive
 	var updatedCart []models.ProductUser
 	found := false
 	for _, item := range user.User_Cart {
-		if item.Product_ID != productID {
+		if item.Product_ID != productID || (sku != "" && item.SKU != sku) {
 			updatedCart = append(updatedCart, item)
 		} else {
 			found = true
@@ -156,7 +193,7 @@ func GetUserCart(userCollection *mongo.Collection, userID string) ([]models.Prod
 	return user.User_Cart, nil
 }
 
-func BuyItemFromCart(userCollection *mongo.Collection, userID string, paymentMethod *models.Payment) (string, int, error) {
+func BuyItemFromCart(productCollection, userCollection *mongo.Collection, userID string, paymentMethod *models.Payment) (string, int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -170,6 +207,11 @@ func BuyItemFromCart(userCollection *mongo.Collection, userID string, paymentMet
 		return "", 0, ErrCantUpdateUser
 	}
 
+	// Only verified accounts can place orders
+	if !user.Email_Verified {
+		return "", 0, ErrEmailNotVerified
+	}
+
 	// Check if cart is empty - if so, check for recent duplicate order (idempotency)
 	if len(user.User_Cart) == 0 {
 		// Check if there's a recent order (within last 10 seconds) - idempotency check
@@ -183,15 +225,14 @@ func BuyItemFromCart(userCollection *mongo.Collection, userID string, paymentMet
 		return "", 0, ErrCantGetItem
 	}
 
-	// Check if any product in cart has already been sold
-	soldProductIDs, err := GetSoldProductIDs(userCollection)
-	if err != nil {
-		return "", 0, ErrCantDecodeProducts
-	}
+	// Take every product in the cart out of stock, or none of them
+	reserved := make([]models.ProductUser, 0, len(user.User_Cart))
 	for _, item := range user.User_Cart {
-		if soldProductIDs[item.Product_ID] {
-			return "", 0, ErrProductAlreadySold
+		if _, err := reserveStock(ctx, productCollection, item.Product_ID, item.SKU); err != nil {
+			releaseStock(productCollection, reserved)
+			return "", 0, err
 		}
+		reserved = append(reserved, item)
 	}
 
 	// Calculate total price
@@ -220,53 +261,42 @@ func BuyItemFromCart(userCollection *mongo.Collection, userID string, paymentMet
 		Payment_Method: paymentMethod,
 	}
 
-	// Add order to user's order status
-	user.Order_Status = append(user.Order_Status, order)
-
-	// Clear cart and update user
-	filter := bson.D{primitive.E{Key: "user_id", Value: userID}}
-	update := bson.D{{Key: "$set", Value: bson.D{
-		{Key: "user_cart", Value: []models.ProductUser{}},
-		{Key: "order_status", Value: user.Order_Status},
-		{Key: "updated_at", Value: time.Now()},
-	}}}
+	// Add the order and clear the cart, provided no concurrent checkout has already ordered it
+	filter := bson.D{
+		primitive.E{Key: "user_id", Value: userID},
+		primitive.E{Key: "user_cart", Value: bson.M{"$ne": []models.ProductUser{}}},
+	}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "user_cart", Value: []models.ProductUser{}},
+			{Key: "updated_at", Value: time.Now()},
+		}},
+		{Key: "$push", Value: bson.D{{Key: "order_status", Value: order}}},
+	}
 
-	_, err = userCollection.UpdateOne(ctx, filter, update)
+	result, err := userCollection.UpdateOne(ctx, filter, update)
 	if err != nil {
+		releaseStock(productCollection, reserved)
 		return "", 0, ErrCantBuyCartItem
 	}
+	if result.MatchedCount == 0 {
+		releaseStock(productCollection, reserved)
+		return "", 0, ErrDuplicateOrder
+	}
 
 	return order.Order_ID.Hex(), totalPrice, nil
 }
 
 // InstantBuy processes an instant purchase without adding to cart and returns order ID and price
+// sku chooses the variant of a product with variants
 // paymentMethod can be nil, in which case it defaults to COD
-func InstantBuy(productCollection, userCollection *mongo.Collection, productID primitive.ObjectID, userID string, paymentMethod *models.Payment) (string, int64, error) {
+func InstantBuy(productCollection, userCollection *mongo.Collection, productID primitive.ObjectID, sku, userID string, paymentMethod *models.Payment) (string, int64, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// Check if product has already been sold
-	soldProductIDs, err := GetSoldProductIDs(userCollection)
-	if err != nil {
-		return "", 0, ErrCantDecodeProducts
-	}
-	if soldProductIDs[productID] {
-		return "", 0, ErrProductAlreadySold
-	}
-
-	// Find the product
-	var product models.Product
-	err = productCollection.FindOne(ctx, bson.M{"product_id": productID}).Decode(&product)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return "", 0, ErrCantFindProduct
-		}
-		return "", 0, ErrCantDecodeProducts
-	}
-
 	// Find the user
 	var user models.User
-	err = userCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&user)
+	err := userCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&user)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return "", 0, ErrCantFindProduct
@@ -274,15 +304,22 @@ func InstantBuy(productCollection, userCollection *mongo.Collection, productID p
 		return "", 0, ErrCantUpdateUser
 	}
 
+	// Only verified accounts can place orders
+	if !user.Email_Verified {
+		return "", 0, ErrEmailNotVerified
+	}
+
+	// Take the product out of stock
+	product, err := reserveStock(ctx, productCollection, productID, sku)
+	if err != nil {
+		return "", 0, err
+	}
+
 	// Convert product to ProductUser format
-	price := int(*product.Price)
-	rating := uint(*product.Rating)
-	productUser := models.ProductUser{
-		Product_ID:   product.Product_ID,
-		Product_Name: product.Product_Name,
-		Price:        &price,
-		Rating:       &rating,
-		Image:        product.Image,
+	productUser, err := cartItem(product, sku)
+	if err != nil {
+		releaseStock(productCollection, []models.ProductUser{{Product_ID: productID, SKU: sku}})
+		return "", 0, err
 	}
 
 	// Set default payment method if not provided (defaults to COD)
@@ -298,30 +335,29 @@ func InstantBuy(productCollection, userCollection *mongo.Collection, productID p
 		Order_ID:       primitive.NewObjectID(),
 		Order_Cart:     []models.ProductUser{productUser},
 		Ordered_At:     time.Now(),
-		Price:          int(*product.Price),
+		Price:          *productUser.Price,
 		Discount:       0,
 		Payment_Method: paymentMethod,
 	}
 
-	// Add order to user's order status
-	user.Order_Status = append(user.Order_Status, order)
-
-	// Update user
+	// Add the order without rewriting the others, so concurrent purchases don't overwrite each other
 	filter := bson.D{primitive.E{Key: "user_id", Value: userID}}
-	update := bson.D{{Key: "$set", Value: bson.D{
-		{Key: "order_status", Value: user.Order_Status},
-		{Key: "updated_at", Value: time.Now()},
-	}}}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "updated_at", Value: time.Now()}}},
+		{Key: "$push", Value: bson.D{{Key: "order_status", Value: order}}},
+	}
 
-	_, err = userCollection.UpdateOne(ctx, filter, update)
-	if err != nil {
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+	if err != nil || result.MatchedCount == 0 {
+		releaseStock(productCollection, []models.ProductUser{productUser})
 		return "", 0, ErrCantBuyCartItem
 	}
 
-	return order.Order_ID.Hex(), int64(*product.Price), nil
+	return order.Order_ID.Hex(), int64(*productUser.Price), nil
 }
 
-// GetSoldProductIDs retrieves all product IDs that have been sold (appear in any order)
+// GetSoldProductIDs retrieves all product IDs that have been sold (appear in any order).
+// Only needed to migrate products created before stock was tracked, see MigrateProductStock.
 func GetSoldProductIDs(userCollection *mongo.Collection) (map[primitive.ObjectID]bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
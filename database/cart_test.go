@@ -0,0 +1,42 @@
+package database
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestCheckoutRequiresVerifiedEmail(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	unverified := bson.D{
+		{Key: "user_id", Value: "user-1"},
+		{Key: "email_verified", Value: false},
+		{Key: "user_cart", Value: bson.A{bson.D{{Key: "product_name", Value: "Phone"}, {Key: "price", Value: 100}}}},
+	}
+	checkouts := map[string]func(mt *mtest.T) error{
+		"cart checkout": func(mt *mtest.T) error {
+			_, _, err := BuyItemFromCart(mt.Coll, mt.Coll, "user-1", nil)
+			return err
+		},
+		"instant buy": func(mt *mtest.T) error {
+			_, _, err := InstantBuy(mt.Coll, mt.Coll, primitive.NewObjectID(), "", "user-1", nil)
+			return err
+		},
+	}
+	for name, checkout := range checkouts {
+		mt.Run(name, func(mt *mtest.T) {
+			mt.AddMockResponses(mtest.CreateCursorResponse(0, "test.Users", mtest.FirstBatch, unverified))
+
+			if err := checkout(mt); err != ErrEmailNotVerified {
+				mt.Fatalf("err = %v, want %v", err, ErrEmailNotVerified)
+			}
+			// Nothing is taken out of stock or ordered for an unverified account
+			if events := mt.GetAllStartedEvents(); len(events) != 1 {
+				mt.Errorf("%d commands sent, want only the user lookup", len(events))
+			}
+		})
+	}
+}
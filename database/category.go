@@ -0,0 +1,253 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github/akhil/ecommerce-yt/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantFindCategory = errors.New("can't find category")
+	ErrDuplicateSlug    = errors.New("slug is already in use")
+	ErrCategoryInUse    = errors.New("category still has subcategories or products")
+	ErrCategoryCycle    = errors.New("a category can't be moved below itself")
+)
+
+// CreateCategory stores a new category below parentID, or at the root when parentID is nil
+func CreateCategory(categoryCollection *mongo.Collection, name, slug string, parentID *primitive.ObjectID) (models.Category, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	category := models.Category{
+		Category_ID: primitive.NewObjectID(),
+		Name:        name,
+		Slug:        slug,
+		Parent_ID:   parentID,
+		Ancestors:   []primitive.ObjectID{},
+		Created_At:  now,
+		Updated_At:  now,
+	}
+	if parentID != nil {
+		parent, err := FindCategory(categoryCollection, *parentID)
+		if err != nil {
+			return category, err
+		}
+		category.Ancestors = append(parent.Ancestors, parent.Category_ID)
+	}
+
+	if _, err := categoryCollection.InsertOne(ctx, category); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return category, ErrDuplicateSlug
+		}
+		return category, err
+	}
+	return category, nil
+}
+
+// FindCategory returns a category by id
+func FindCategory(categoryCollection *mongo.Collection, categoryID primitive.ObjectID) (models.Category, error) {
+	return findCategory(categoryCollection, bson.M{"category_id": categoryID})
+}
+
+// FindCategoryBySlug returns a category by slug
+func FindCategoryBySlug(categoryCollection *mongo.Collection, slug string) (models.Category, error) {
+	return findCategory(categoryCollection, bson.M{"slug": slug})
+}
+
+func findCategory(categoryCollection *mongo.Collection, filter bson.M) (models.Category, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var category models.Category
+	err := categoryCollection.FindOne(ctx, filter).Decode(&category)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return category, ErrCantFindCategory
+		}
+		return category, err
+	}
+	return category, nil
+}
+
+// ListCategories returns every category sorted by name
+func ListCategories(categoryCollection *mongo.Collection) ([]models.Category, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := categoryCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	categories := make([]models.Category, 0)
+	if err := cursor.All(ctx, &categories); err != nil {
+		return nil, err
+	}
+	return categories, nil
+}
+
+// CategoryTreeIDs returns the id of a category followed by the ids of all its descendants
+func CategoryTreeIDs(categoryCollection *mongo.Collection, categoryID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetProjection(bson.M{"category_id": 1})
+	cursor, err := categoryCollection.Find(ctx, bson.M{"ancestors": categoryID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var descendants []models.Category
+	if err := cursor.All(ctx, &descendants); err != nil {
+		return nil, err
+	}
+
+	ids := []primitive.ObjectID{categoryID}
+	for _, descendant := range descendants {
+		ids = append(ids, descendant.Category_ID)
+	}
+	return ids, nil
+}
+
+// UpdateCategory sets the name and slug fields of a category and returns the updated category
+func UpdateCategory(categoryCollection *mongo.Collection, categoryID primitive.ObjectID, fields bson.M) (models.Category, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fields["updated_at"] = time.Now()
+
+	var category models.Category
+	err := categoryCollection.FindOneAndUpdate(ctx,
+		bson.M{"category_id": categoryID},
+		bson.M{"$set": fields},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&category)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return category, ErrCantFindCategory
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			return category, ErrDuplicateSlug
+		}
+		return category, err
+	}
+	return category, nil
+}
+
+// CategoryAncestors returns the ancestors a category gets when it is moved below parentID, or
+// to the root when parentID is nil. It fails with ErrCantFindCategory when the parent doesn't
+// exist and with ErrCategoryCycle when the parent is the category or one of its descendants.
+func CategoryAncestors(categoryCollection *mongo.Collection, categoryID primitive.ObjectID, parentID *primitive.ObjectID) ([]primitive.ObjectID, error) {
+	ancestors := []primitive.ObjectID{}
+	if parentID == nil {
+		return ancestors, nil
+	}
+
+	parent, err := FindCategory(categoryCollection, *parentID)
+	if err != nil {
+		return nil, err
+	}
+	if parent.Category_ID == categoryID {
+		return nil, ErrCategoryCycle
+	}
+	for _, ancestor := range parent.Ancestors {
+		if ancestor == categoryID {
+			return nil, ErrCategoryCycle
+		}
+	}
+	return append(ancestors, append(parent.Ancestors, parent.Category_ID)...), nil
+}
+
+// MoveCategory moves a category, with all its descendants, below parentID or to the root when
+// parentID is nil, and returns the moved category
+func MoveCategory(categoryCollection *mongo.Collection, categoryID primitive.ObjectID, parentID *primitive.ObjectID) (models.Category, error) {
+	category, err := FindCategory(categoryCollection, categoryID)
+	if err != nil {
+		return category, err
+	}
+
+	ancestors, err := CategoryAncestors(categoryCollection, categoryID, parentID)
+	if err != nil {
+		return category, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Descendants keep the part of their path below the moved category
+	cursor, err := categoryCollection.Find(ctx, bson.M{"ancestors": categoryID})
+	if err != nil {
+		return category, err
+	}
+	var descendants []models.Category
+	if err := cursor.All(ctx, &descendants); err != nil {
+		return category, err
+	}
+
+	now := time.Now()
+	update := bson.M{"$set": bson.M{"parent_id": parentID, "ancestors": ancestors, "updated_at": now}}
+	if parentID == nil {
+		update = bson.M{"$set": bson.M{"ancestors": ancestors, "updated_at": now}, "$unset": bson.M{"parent_id": ""}}
+	}
+	writes := []mongo.WriteModel{
+		mongo.NewUpdateOneModel().SetFilter(bson.M{"category_id": categoryID}).SetUpdate(update),
+	}
+	for _, descendant := range descendants {
+		path := append([]primitive.ObjectID{}, ancestors...)
+		path = append(path, categoryID)
+		for i, ancestor := range descendant.Ancestors {
+			if ancestor == categoryID {
+				path = append(path, descendant.Ancestors[i+1:]...)
+				break
+			}
+		}
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"category_id": descendant.Category_ID}).
+			SetUpdate(bson.M{"$set": bson.M{"ancestors": path}}))
+	}
+	if _, err := categoryCollection.BulkWrite(ctx, writes); err != nil {
+		return category, err
+	}
+
+	category.Parent_ID = parentID
+	category.Ancestors = ancestors
+	category.Updated_At = now
+	return category, nil
+}
+
+// DeleteCategory removes a category that has no subcategories and no products
+func DeleteCategory(categoryCollection, productCollection *mongo.Collection, categoryID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	children, err := categoryCollection.CountDocuments(ctx, bson.M{"parent_id": categoryID})
+	if err != nil {
+		return err
+	}
+	products, err := productCollection.CountDocuments(ctx, bson.M{"category_id": categoryID})
+	if err != nil {
+		return err
+	}
+	if children > 0 || products > 0 {
+		return ErrCategoryInUse
+	}
+
+	result, err := categoryCollection.DeleteOne(ctx, bson.M{"category_id": categoryID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrCantFindCategory
+	}
+	return nil
+}
@@ -10,7 +10,8 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// DBSet initializes and returns a connected MongoDB client.
+// DBSet initializes and returns a MongoDB client. The driver connects in the background;
+// main calls CheckConnection before using the database.
 func DBSet() *mongo.Client {
 	mongoURI := os.Getenv("MONGODB_URL")
 	if mongoURI == "" {
@@ -25,15 +26,19 @@ func DBSet() *mongo.Client {
 	if err != nil {
 		log.Fatalf("Error connecting to MongoDB: %v", err)
 	}
+	return client
+}
 
-	// Ping the database to verify connection
-	err = client.Ping(ctx, nil)
-	if err != nil {
-		log.Fatalf("Error pinging MongoDB: %v", err)
-	}
+// CheckConnection pings the database to verify the client can reach it
+func CheckConnection(client *mongo.Client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
+	if err := client.Ping(ctx, nil); err != nil {
+		return err
+	}
 	log.Println("Successfully connected to MongoDB!")
-	return client
+	return nil
 }
 
 var Client *mongo.Client = DBSet()
@@ -0,0 +1,85 @@
+package database
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureIndexes creates the indexes the application relies on. It is safe to call on every startup.
+func EnsureIndexes(client *mongo.Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	indexes := map[string][]mongo.IndexModel{
+		"AdminInvites": {
+			{Keys: bson.D{{Key: "code_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		"APIKeys": {
+			{Keys: bson.D{{Key: "key_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		"AuditLogs": {
+			{Keys: bson.D{{Key: "action", Value: 1}, {Key: "created_at", Value: -1}}},
+		},
+		"Categories": {
+			{Keys: bson.D{{Key: "category_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "slug", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "parent_id", Value: 1}}},
+			{Keys: bson.D{{Key: "ancestors", Value: 1}}},
+		},
+		"LoginAttempts": {
+			{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		"OIDCStates": {
+			{Keys: bson.D{{Key: "state_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		"PasswordResets": {
+			{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "user_id", Value: 1}}},
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		"Products": {
+			{Keys: bson.D{{Key: "product_id", Value: 1}}},
+			{Keys: bson.D{{Key: "category_id", Value: 1}}},
+			{Keys: bson.D{{Key: "tags", Value: 1}}},
+			{Keys: bson.D{{Key: "variants.sku", Value: 1}}, Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"variants.sku": bson.M{"$exists": true}})},
+		},
+		"Sessions": {
+			{Keys: bson.D{{Key: "session_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "last_seen_at", Value: -1}}},
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		"PhoneOTPs": {
+			{Keys: bson.D{{Key: "phone", Value: 1}, {Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "ip", Value: 1}, {Key: "created_at", Value: -1}}},
+			// Codes are kept for an hour so they count towards the hourly limits
+			{Keys: bson.D{{Key: "created_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(3600)},
+		},
+		"RevokedTokens": {
+			{Keys: bson.D{{Key: "token_id", Value: 1}}},
+			// Drop revocation entries once the token would have expired anyway
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		"Users": {
+			{Keys: bson.D{{Key: "identities.provider", Value: 1}, {Key: "identities.subject", Value: 1}}},
+			{Keys: bson.D{{Key: "phone", Value: 1}}},
+			// Phone login needs every verified number to belong to a single account
+			{Keys: bson.D{{Key: "phone", Value: 1}, {Key: "phone_verified", Value: 1}}, Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"phone_verified": true})},
+		},
+	}
+
+	for collectionName, models := range indexes {
+		_, err := UserData(client, collectionName).Indexes().CreateMany(ctx, models)
+		if err != nil {
+			log.Printf("Error creating indexes for %s: %v", collectionName, err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github/akhil/ecommerce-yt/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Longest wait imposed between failed attempts before the lockout threshold is reached
+const maxLoginBackoff = 30 * time.Second
+
+// LoginRetryAfter returns how long the caller must wait before trying to log in with
+// any of keys again. It is zero when no key is blocked.
+func LoginRetryAfter(attemptCollection *mongo.Collection, keys []string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	cursor, err := attemptCollection.Find(ctx, bson.M{
+		"key":           bson.M{"$in": keys},
+		"blocked_until": bson.M{"$gt": now},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var attempts []models.LoginAttempt
+	if err := cursor.All(ctx, &attempts); err != nil {
+		return 0, err
+	}
+
+	var wait time.Duration
+	for _, attempt := range attempts {
+		if remaining := attempt.Blocked_Until.Sub(now); remaining > wait {
+			wait = remaining
+		}
+	}
+	return wait, nil
+}
+
+// RecordLoginFailure counts a failed attempt for key and blocks the key for a growing delay:
+// one second after the first failure, doubling up to 30 seconds, and for lockout once
+// maxFailures is reached. locked is true for the failure that triggered the lockout.
+// The count is forgotten once the key has been quiet for lockout.
+func RecordLoginFailure(attemptCollection *mongo.Collection, key string, maxFailures int, lockout time.Duration) (attempt models.LoginAttempt, locked bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err = attemptCollection.FindOneAndUpdate(ctx,
+		bson.M{"key": key},
+		bson.M{
+			"$inc": bson.M{"failures": 1},
+			"$set": bson.M{"last_failure_at": now},
+		},
+		opts,
+	).Decode(&attempt)
+	if err != nil {
+		return attempt, false, err
+	}
+
+	blockedUntil := now.Add(loginBackoff(attempt.Failures, maxFailures, lockout))
+	attempt.Blocked_Until = &blockedUntil
+	attempt.Expires_At = blockedUntil.Add(lockout)
+
+	_, err = attemptCollection.UpdateOne(ctx,
+		bson.M{"key": key},
+		bson.M{"$set": bson.M{"blocked_until": blockedUntil, "expires_at": attempt.Expires_At}},
+	)
+	if err != nil {
+		return attempt, false, err
+	}
+
+	return attempt, attempt.Failures == maxFailures, nil
+}
+
+// loginBackoff returns how long a key is blocked after its failures-th failed attempt:
+// one second after the first, doubling up to maxLoginBackoff, and lockout from maxFailures on.
+func loginBackoff(failures, maxFailures int, lockout time.Duration) time.Duration {
+	switch {
+	case failures >= maxFailures:
+		return lockout
+	case failures < 1:
+		return 0
+	case failures > 5:
+		return maxLoginBackoff
+	}
+	return time.Second << (failures - 1)
+}
+
+// ClearLoginFailures forgets the failed attempts of key. It returns false if there were none.
+func ClearLoginFailures(attemptCollection *mongo.Collection, key string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := attemptCollection.DeleteOne(ctx, bson.M{"key": key})
+	if err != nil {
+		return false, err
+	}
+	return result.DeletedCount > 0, nil
+}
@@ -0,0 +1,41 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginBackoff(t *testing.T) {
+	const (
+		maxFailures = 10
+		lockout     = 15 * time.Minute
+	)
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 16 * time.Second},
+		// Doubling again would pass the cap
+		{6, maxLoginBackoff},
+		{9, maxLoginBackoff},
+		{10, lockout},
+		// Failures keep counting while locked, and every one of them extends the lockout
+		{11, lockout},
+	}
+	for _, tt := range tests {
+		if got := loginBackoff(tt.failures, maxFailures, lockout); got != tt.want {
+			t.Errorf("loginBackoff(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+
+	// A threshold below the doubling range locks out directly
+	if got := loginBackoff(3, 3, lockout); got != lockout {
+		t.Errorf("loginBackoff(3) with 3 allowed failures = %v, want %v", got, lockout)
+	}
+}
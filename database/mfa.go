@@ -0,0 +1,136 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrMFANotPending = errors.New("two-factor setup has not been started")
+	ErrMFACodeReused = errors.New("this code has already been used")
+)
+
+// SetPendingMFASecret stores a secret that becomes active once the user confirms a code from it
+func SetPendingMFASecret(userCollection *mongo.Collection, userID, secret string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := userCollection.UpdateOne(ctx,
+		bson.M{"user_id": userID},
+		bson.M{"$set": bson.M{"mfa_pending_secret": secret, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return ErrCantUpdateUser
+	}
+	if result.MatchedCount == 0 {
+		return ErrCantFindUser
+	}
+	return nil
+}
+
+// EnableMFA activates the pending secret with the given recovery code hashes.
+// step is the time step of the code that confirmed the secret.
+func EnableMFA(userCollection *mongo.Collection, userID, secret string, recoveryCodeHashes []string, step int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := userCollection.UpdateOne(ctx,
+		bson.M{"user_id": userID, "mfa_pending_secret": secret},
+		bson.M{
+			"$set": bson.M{
+				"mfa_enabled":        true,
+				"mfa_secret":         secret,
+				"mfa_recovery_codes": recoveryCodeHashes,
+				"mfa_last_step":      step,
+				"updated_at":         time.Now(),
+			},
+			"$unset": bson.M{"mfa_pending_secret": ""},
+		},
+	)
+	if err != nil {
+		return ErrCantUpdateUser
+	}
+	if result.MatchedCount == 0 {
+		return ErrMFANotPending
+	}
+	return nil
+}
+
+// DisableMFA removes the user's secret and recovery codes
+func DisableMFA(userCollection *mongo.Collection, userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := userCollection.UpdateOne(ctx,
+		bson.M{"user_id": userID},
+		bson.M{
+			"$set": bson.M{"mfa_enabled": false, "updated_at": time.Now()},
+			"$unset": bson.M{
+				"mfa_secret":         "",
+				"mfa_pending_secret": "",
+				"mfa_recovery_codes": "",
+				"mfa_last_step":      "",
+			},
+		},
+	)
+	if err != nil {
+		return ErrCantUpdateUser
+	}
+	return nil
+}
+
+// ReplaceRecoveryCodes replaces every recovery code of the user
+func ReplaceRecoveryCodes(userCollection *mongo.Collection, userID string, recoveryCodeHashes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := userCollection.UpdateOne(ctx,
+		bson.M{"user_id": userID},
+		bson.M{"$set": bson.M{"mfa_recovery_codes": recoveryCodeHashes, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return ErrCantUpdateUser
+	}
+	return nil
+}
+
+// UseMFAStep records the time step of an accepted code. It fails with ErrMFACodeReused
+// when that step, or a later one, was already used, so a code works only once.
+func UseMFAStep(userCollection *mongo.Collection, userID string, step int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := userCollection.UpdateOne(ctx,
+		bson.M{"user_id": userID, "$or": []bson.M{
+			{"mfa_last_step": bson.M{"$exists": false}},
+			{"mfa_last_step": bson.M{"$lt": step}},
+		}},
+		bson.M{"$set": bson.M{"mfa_last_step": step}},
+	)
+	if err != nil {
+		return ErrCantUpdateUser
+	}
+	if result.MatchedCount == 0 {
+		return ErrMFACodeReused
+	}
+	return nil
+}
+
+// UseRecoveryCode atomically removes a recovery code hash. It returns false if the user doesn't have it.
+func UseRecoveryCode(userCollection *mongo.Collection, userID, recoveryCodeHash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := userCollection.UpdateOne(ctx,
+		bson.M{"user_id": userID, "mfa_recovery_codes": recoveryCodeHash},
+		bson.M{"$pull": bson.M{"mfa_recovery_codes": recoveryCodeHash}},
+	)
+	if err != nil {
+		return false, ErrCantUpdateUser
+	}
+	return result.MatchedCount > 0, nil
+}
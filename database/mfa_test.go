@@ -0,0 +1,44 @@
+package database
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestUseMFAStep(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	tests := []struct {
+		name    string
+		matched int32
+		want    error
+	}{
+		{"new step", 1, nil},
+		// No user matched: the step, or a later one, is already recorded
+		{"replayed step", 0, ErrMFACodeReused},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: tt.matched}, {Key: "nModified", Value: tt.matched}})
+
+			if err := UseMFAStep(mt.Coll, "user-1", 42); err != tt.want {
+				mt.Errorf("UseMFAStep() = %v, want %v", err, tt.want)
+			}
+
+			// The step must only be stored when it is later than the last used one
+			filter := mt.GetStartedEvent().Command.Lookup("updates").Array().Index(0).Value().Document().Lookup("q").Document()
+			conditions, err := filter.Lookup("$or").Array().Values()
+			if err != nil {
+				mt.Fatal(err)
+			}
+			if len(conditions) != 2 {
+				mt.Fatalf("filter has %d conditions, want 2", len(conditions))
+			}
+			if lt := conditions[1].Document().Lookup("mfa_last_step", "$lt").AsInt64(); lt != 42 {
+				mt.Errorf("filter requires mfa_last_step < %d, want < 42", lt)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github/akhil/ecommerce-yt/models"
+	"github/akhil/ecommerce-yt/tokens"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrInvalidOIDCState = errors.New("login state is invalid, expired or already used")
+
+// CreateOIDCState stores the state of an OpenID Connect login under the hash of state
+func CreateOIDCState(stateCollection *mongo.Collection, state string, oidcState models.OIDCState) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	oidcState.State_Hash = tokens.HashOpaqueToken(state)
+	_, err := stateCollection.InsertOne(ctx, oidcState)
+	return err
+}
+
+// ConsumeOIDCState atomically removes and returns the unexpired login state matching state,
+// so each callback can only be completed once
+func ConsumeOIDCState(stateCollection *mongo.Collection, state string) (models.OIDCState, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"state_hash": tokens.HashOpaqueToken(state),
+		"expires_at": bson.M{"$gt": time.Now()},
+	}
+
+	var oidcState models.OIDCState
+	err := stateCollection.FindOneAndDelete(ctx, filter).Decode(&oidcState)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return models.OIDCState{}, ErrInvalidOIDCState
+		}
+		return models.OIDCState{}, err
+	}
+	return oidcState, nil
+}
+
+// LinkIdentity adds an identity provider account to a user and marks the user's email as
+// verified, since the provider vouched for it. When the address wasn't verified before, the
+// password is removed and every token revoked: whoever registered it without proving
+// ownership of the address must not keep access to the account.
+func LinkIdentity(userCollection *mongo.Collection, userID string, identity models.ExternalIdentity, wasVerified bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	set := bson.M{"updated_at": now}
+	update := bson.M{"$push": bson.M{"identities": identity}}
+	if !wasVerified {
+		set["email_verified"] = true
+		set["email_verified_at"] = now
+		set["password"] = nil
+		set["token"] = nil
+		set["refresh_token"] = nil
+		update["$inc"] = bson.M{"token_version": 1}
+	}
+	update["$set"] = set
+
+	result, err := userCollection.UpdateOne(ctx,
+		bson.M{"user_id": userID, "email": identity.Email},
+		update,
+	)
+	if err != nil {
+		return ErrCantUpdateUser
+	}
+	if result.MatchedCount == 0 {
+		return ErrCantFindUser
+	}
+
+	if !wasVerified {
+		InvalidateUserAuthState(userID)
+	}
+	return nil
+}
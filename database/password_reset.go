@@ -0,0 +1,92 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github/akhil/ecommerce-yt/models"
+	"github/akhil/ecommerce-yt/tokens"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrInvalidResetToken = errors.New("reset token is invalid, expired or already used")
+
+// CreatePasswordReset stores a new reset token for the user and returns it in clear text.
+// Earlier unused tokens of the user are discarded.
+func CreatePasswordReset(resetCollection *mongo.Collection, userID string, ttl time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	token, err := tokens.GenerateOpaqueToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = resetCollection.DeleteMany(ctx, bson.M{"user_id": userID, "used_at": bson.M{"$exists": false}})
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	_, err = resetCollection.InsertOne(ctx, models.PasswordReset{
+		Token_Hash: tokens.HashOpaqueToken(token),
+		User_ID:    userID,
+		Created_At: now,
+		Expires_At: now.Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// FindPasswordReset returns the user a usable reset token belongs to without consuming it
+func FindPasswordReset(resetCollection *mongo.Collection, token string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"token_hash": tokens.HashOpaqueToken(token),
+		"used_at":    bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": time.Now()},
+	}
+
+	var reset models.PasswordReset
+	err := resetCollection.FindOne(ctx, filter).Decode(&reset)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return "", ErrInvalidResetToken
+		}
+		return "", err
+	}
+
+	return reset.User_ID, nil
+}
+
+// ConsumePasswordReset atomically marks a reset token as used and returns the user it belongs to
+func ConsumePasswordReset(resetCollection *mongo.Collection, token string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{
+		"token_hash": tokens.HashOpaqueToken(token),
+		"used_at":    bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": now},
+	}
+
+	var reset models.PasswordReset
+	err := resetCollection.FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{"used_at": now}}).Decode(&reset)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return "", ErrInvalidResetToken
+		}
+		return "", err
+	}
+
+	return reset.User_ID, nil
+}
@@ -0,0 +1,181 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github/akhil/ecommerce-yt/models"
+	"github/akhil/ecommerce-yt/tokens"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrInvalidOTP     = errors.New("code is invalid or has expired")
+	ErrOTPRateLimited = errors.New("too many codes requested, try again later")
+)
+
+// What a phone OTP can be used for
+const (
+	OTPPurposeLogin       = "login"
+	OTPPurposeVerifyPhone = "verify_phone"
+)
+
+// OTPLimits bounds how often codes are sent and how often they can be guessed
+type OTPLimits struct {
+	TTL time.Duration
+	// Minimum time between two codes for the same phone
+	ResendInterval time.Duration
+	// Codes per phone, and per client IP across phones, in any hour
+	MaxPerHour   int
+	MaxIPPerHour int
+	// Wrong guesses allowed before a code stops working
+	MaxAttempts int
+}
+
+// otpHash hashes a code together with the id of its OTP, so equal codes don't share a hash
+func otpHash(otpID primitive.ObjectID, code string) string {
+	return tokens.HashOpaqueToken(otpID.Hex() + ":" + code)
+}
+
+// CreatePhoneOTP stores a new six digit code for phone and returns it in clear text. Earlier
+// unused codes for the same phone and purpose stop working. When a limit is hit it returns
+// ErrOTPRateLimited and how long to wait.
+func CreatePhoneOTP(otpCollection *mongo.Collection, phone, purpose, userID, ip string, limits OTPLimits) (string, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	hourAgo := now.Add(-time.Hour)
+
+	var latest models.PhoneOTP
+	opts := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	err := otpCollection.FindOne(ctx, bson.M{"phone": phone, "created_at": bson.M{"$gt": hourAgo}}, opts).Decode(&latest)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return "", 0, err
+	}
+	if err == nil {
+		if wait := latest.Created_At.Add(limits.ResendInterval).Sub(now); wait > 0 {
+			return "", wait, ErrOTPRateLimited
+		}
+	}
+
+	for _, limit := range []struct {
+		filter bson.M
+		max    int
+	}{
+		{bson.M{"phone": phone, "created_at": bson.M{"$gt": hourAgo}}, limits.MaxPerHour},
+		{bson.M{"ip": ip, "created_at": bson.M{"$gt": hourAgo}}, limits.MaxIPPerHour},
+	} {
+		count, err := otpCollection.CountDocuments(ctx, limit.filter)
+		if err != nil {
+			return "", 0, err
+		}
+		if count < int64(limit.max) {
+			continue
+		}
+		// Wait until the oldest code of the last hour no longer counts
+		var oldest models.PhoneOTP
+		opts := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: 1}})
+		if err := otpCollection.FindOne(ctx, limit.filter, opts).Decode(&oldest); err != nil {
+			return "", time.Hour, ErrOTPRateLimited
+		}
+		return "", oldest.Created_At.Add(time.Hour).Sub(now), ErrOTPRateLimited
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", 0, err
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+
+	_, err = otpCollection.UpdateMany(ctx,
+		bson.M{"phone": phone, "purpose": purpose, "used_at": bson.M{"$exists": false}, "expires_at": bson.M{"$gt": now}},
+		bson.M{"$set": bson.M{"expires_at": now}},
+	)
+	if err != nil {
+		return "", 0, err
+	}
+
+	otp := models.PhoneOTP{
+		OTP_ID:     primitive.NewObjectID(),
+		Phone:      phone,
+		Purpose:    purpose,
+		User_ID:    userID,
+		IP:         ip,
+		Created_At: now,
+		Expires_At: now.Add(limits.TTL),
+	}
+	otp.Code_Hash = otpHash(otp.OTP_ID, code)
+	if _, err := otpCollection.InsertOne(ctx, otp); err != nil {
+		return "", 0, err
+	}
+
+	return code, 0, nil
+}
+
+// VerifyPhoneOTP checks code against the current code for phone and purpose and consumes it.
+// Every check counts as an attempt, so a code stops working after maxAttempts guesses.
+func VerifyPhoneOTP(otpCollection *mongo.Collection, phone, purpose, code string, maxAttempts int) (models.PhoneOTP, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{
+		"phone":      phone,
+		"purpose":    purpose,
+		"used_at":    bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": now},
+		"attempts":   bson.M{"$lt": maxAttempts},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetReturnDocument(options.After)
+
+	var otp models.PhoneOTP
+	err := otpCollection.FindOneAndUpdate(ctx, filter, bson.M{"$inc": bson.M{"attempts": 1}}, opts).Decode(&otp)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return models.PhoneOTP{}, ErrInvalidOTP
+		}
+		return models.PhoneOTP{}, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(otpHash(otp.OTP_ID, code)), []byte(otp.Code_Hash)) != 1 {
+		return models.PhoneOTP{}, ErrInvalidOTP
+	}
+
+	result, err := otpCollection.UpdateOne(ctx,
+		bson.M{"otp_id": otp.OTP_ID, "used_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"used_at": now}},
+	)
+	if err != nil {
+		return models.PhoneOTP{}, err
+	}
+	if result.MatchedCount == 0 {
+		return models.PhoneOTP{}, ErrInvalidOTP
+	}
+	return otp, nil
+}
+
+// DeletePhoneOTPs discards every code requested by the user or sent to phone. phone may be
+// empty for users without one.
+func DeletePhoneOTPs(otpCollection *mongo.Collection, userID, phone string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"user_id": userID}
+	if phone != "" {
+		filter = bson.M{"$or": []bson.M{{"user_id": userID}, {"phone": phone}}}
+	}
+	_, err := otpCollection.DeleteMany(ctx, filter)
+	return err
+}
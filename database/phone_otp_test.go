@@ -0,0 +1,218 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestCreatePhoneOTPLimits(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	limits := OTPLimits{TTL: 5 * time.Minute, ResendInterval: time.Minute, MaxPerHour: 5, MaxIPPerHour: 20, MaxAttempts: 5}
+	sentAgo := func(d time.Duration) bson.D {
+		return bson.D{{Key: "phone", Value: "+15550100"}, {Key: "created_at", Value: time.Now().Add(-d)}}
+	}
+	none := mtest.CreateCursorResponse(0, "test.PhoneOTPs", mtest.FirstBatch)
+	count := func(n int) bson.D {
+		return mtest.CreateCursorResponse(0, "test.PhoneOTPs", mtest.FirstBatch, bson.D{{Key: "n", Value: n}})
+	}
+	found := func(doc bson.D) bson.D {
+		return mtest.CreateCursorResponse(0, "test.PhoneOTPs", mtest.FirstBatch, doc)
+	}
+
+	tests := []struct {
+		name      string
+		responses []bson.D
+		// wantWait is how long the caller is told to wait, give or take a second
+		wantWait time.Duration
+	}{
+		{
+			name:      "resent too soon",
+			responses: []bson.D{found(sentAgo(20 * time.Second))},
+			wantWait:  40 * time.Second,
+		},
+		{
+			// The oldest of the hour's codes stops counting 30 minutes from now
+			name:      "too many codes for the phone",
+			responses: []bson.D{found(sentAgo(2 * time.Minute)), count(5), found(sentAgo(30 * time.Minute))},
+			wantWait:  30 * time.Minute,
+		},
+		{
+			name:      "too many codes from the IP",
+			responses: []bson.D{none, none, count(20), found(sentAgo(50 * time.Minute))},
+			wantWait:  10 * time.Minute,
+		},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			mt.AddMockResponses(tt.responses...)
+
+			code, wait, err := CreatePhoneOTP(mt.Coll, "+15550100", OTPPurposeLogin, "user-1", "203.0.113.7", limits)
+			if err != ErrOTPRateLimited {
+				mt.Fatalf("CreatePhoneOTP() = %q, %v, want %v", code, err, ErrOTPRateLimited)
+			}
+			if diff := wait - tt.wantWait; diff < -time.Second || diff > time.Second {
+				mt.Errorf("wait = %v, want %v", wait, tt.wantWait)
+			}
+			if len(commandsNamed(mt, "insert")) != 0 {
+				mt.Error("a code was created past the limit")
+			}
+		})
+	}
+
+	mt.Run("within the limits", func(mt *mtest.T) {
+		mt.AddMockResponses(none, none, none,
+			bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}, {Key: "nModified", Value: 1}},
+			mtest.CreateSuccessResponse(),
+		)
+
+		code, _, err := CreatePhoneOTP(mt.Coll, "+15550100", OTPPurposeLogin, "user-1", "203.0.113.7", limits)
+		if err != nil {
+			mt.Fatalf("CreatePhoneOTP: %v", err)
+		}
+		if len(code) != 6 || strings.Trim(code, "0123456789") != "" {
+			mt.Errorf("code = %q, want six digits", code)
+		}
+
+		// Earlier codes for the phone stop working
+		if len(commandsNamed(mt, "update")) != 1 {
+			mt.Error("earlier codes were left usable")
+		}
+		inserts := commandsNamed(mt, "insert")
+		if len(inserts) != 1 {
+			mt.Fatalf("%d codes stored, want 1", len(inserts))
+		}
+		stored := inserts[0].Lookup("documents").Array().Index(0).Value().Document()
+		otpID := stored.Lookup("otp_id").ObjectID()
+		if hash := stored.Lookup("code_hash").StringValue(); hash != otpHash(otpID, code) {
+			mt.Errorf("stored code_hash = %q, want the hash of the code", hash)
+		}
+		if strings.Contains(stored.String(), `"`+code+`"`) {
+			mt.Errorf("the code is stored in clear text: %v", stored)
+		}
+	})
+}
+
+func TestVerifyPhoneOTP(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	otpID := primitive.NewObjectID()
+	otp := bson.D{
+		{Key: "otp_id", Value: otpID},
+		{Key: "phone", Value: "+15550100"},
+		{Key: "user_id", Value: "user-1"},
+		{Key: "code_hash", Value: otpHash(otpID, "123456")},
+		{Key: "attempts", Value: 1},
+	}
+
+	tests := []struct {
+		name      string
+		code      string
+		responses []bson.D
+		want      error
+	}{
+		{
+			name:      "right code",
+			code:      "123456",
+			responses: []bson.D{{{Key: "ok", Value: 1}, {Key: "value", Value: otp}}, {{Key: "ok", Value: 1}, {Key: "n", Value: 1}, {Key: "nModified", Value: 1}}},
+		},
+		{
+			name:      "wrong code",
+			code:      "654321",
+			responses: []bson.D{{{Key: "ok", Value: 1}, {Key: "value", Value: otp}}},
+			want:      ErrInvalidOTP,
+		},
+		{
+			// No usable code: expired, used, or out of attempts
+			name:      "no usable code",
+			code:      "123456",
+			responses: []bson.D{{{Key: "ok", Value: 1}, {Key: "value", Value: nil}}},
+			want:      ErrInvalidOTP,
+		},
+		{
+			// Another request used the code between the check and the update
+			name:      "used concurrently",
+			code:      "123456",
+			responses: []bson.D{{{Key: "ok", Value: 1}, {Key: "value", Value: otp}}, {{Key: "ok", Value: 1}, {Key: "n", Value: 0}, {Key: "nModified", Value: 0}}},
+			want:      ErrInvalidOTP,
+		},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			mt.AddMockResponses(tt.responses...)
+
+			if _, err := VerifyPhoneOTP(mt.Coll, "+15550100", OTPPurposeLogin, tt.code, 5); err != tt.want {
+				mt.Fatalf("VerifyPhoneOTP() = %v, want %v", err, tt.want)
+			}
+
+			// Every check counts as an attempt, and codes out of attempts aren't matched
+			check := commandsNamed(mt, "findAndModify")[0]
+			if check.Lookup("query", "attempts", "$lt").AsInt64() != 5 {
+				mt.Errorf("check doesn't limit attempts: %v", check.Lookup("query"))
+			}
+			if check.Lookup("update", "$inc", "attempts").AsInt64() != 1 {
+				mt.Errorf("check doesn't count the attempt: %v", check.Lookup("update"))
+			}
+			if tt.code != "123456" && len(commandsNamed(mt, "update")) != 0 {
+				mt.Error("a wrong code was marked as used")
+			}
+		})
+	}
+}
+
+// commandsNamed returns the commands named name that were sent
+func commandsNamed(mt *mtest.T, name string) []bson.Raw {
+	var commands []bson.Raw
+	for _, event := range mt.GetAllStartedEvents() {
+		if event.CommandName == name {
+			commands = append(commands, event.Command)
+		}
+	}
+	return commands
+}
+
+func TestDeletePhoneOTPs(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("user with a phone", func(mt *mtest.T) {
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 2}})
+
+		if err := DeletePhoneOTPs(mt.Coll, "user-1", "+15550100"); err != nil {
+			mt.Fatalf("DeletePhoneOTPs: %v", err)
+		}
+
+		// Codes the user requested for another number, e.g. while changing phones, hold their IP too
+		filter := mt.GetStartedEvent().Command.Lookup("deletes").Array().Index(0).Value().Document().Lookup("q").Document()
+		conditions, err := filter.Lookup("$or").Array().Values()
+		if err != nil {
+			mt.Fatalf("filter %v has no $or: %v", filter, err)
+		}
+		if len(conditions) != 2 ||
+			conditions[0].Document().Lookup("user_id").StringValue() != "user-1" ||
+			conditions[1].Document().Lookup("phone").StringValue() != "+15550100" {
+			mt.Errorf("filter = %v, want codes of user-1 or sent to +15550100", filter)
+		}
+	})
+
+	mt.Run("user without a phone", func(mt *mtest.T) {
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 0}})
+
+		if err := DeletePhoneOTPs(mt.Coll, "user-1", ""); err != nil {
+			mt.Fatalf("DeletePhoneOTPs: %v", err)
+		}
+
+		// Without a phone only the user's own codes are deleted
+		filter := mt.GetStartedEvent().Command.Lookup("deletes").Array().Index(0).Value().Document().Lookup("q").Document()
+		if _, err := filter.LookupErr("phone"); err == nil {
+			mt.Errorf("filter = %v, want only the user's codes", filter)
+		}
+		if filter.Lookup("user_id").StringValue() != "user-1" {
+			mt.Errorf("filter = %v, want the codes of user-1", filter)
+		}
+	})
+}
@@ -0,0 +1,334 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github/akhil/ecommerce-yt/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantUpdateProduct  = errors.New("can't update product")
+	ErrProductArchived    = errors.New("product is archived")
+	ErrProductNotArchived = errors.New("product is not archived")
+	ErrOutOfStock         = errors.New("product is out of stock")
+	ErrInsufficientStock  = errors.New("stock can't go below zero")
+)
+
+// NotArchived is the filter condition matching products that are still offered
+var NotArchived = bson.M{"$exists": false}
+
+// FindProduct returns a product, including archived ones
+func FindProduct(productCollection *mongo.Collection, productID primitive.ObjectID) (models.Product, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var product models.Product
+	err := productCollection.FindOne(ctx, bson.M{"product_id": productID}).Decode(&product)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return product, ErrCantFindProduct
+		}
+		return product, ErrCantDecodeProducts
+	}
+	return product, nil
+}
+
+// ListProducts returns a page of products matching filter, archived ones included unless the
+// filter excludes them, along with the total number of matches
+func ListProducts(productCollection *mongo.Collection, filter bson.M, skip, limit int64) ([]models.Product, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	total, err := productCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().SetSkip(skip).SetLimit(limit)
+	cursor, err := productCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	products := make([]models.Product, 0)
+	if err := cursor.All(ctx, &products); err != nil {
+		return nil, 0, ErrCantDecodeProducts
+	}
+	return products, total, nil
+}
+
+// UpdateProduct sets the given fields of a product and returns the updated product. Fields
+// with a nil value are removed.
+func UpdateProduct(productCollection *mongo.Collection, productID primitive.ObjectID, fields bson.M) (models.Product, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	set, unset := bson.M{}, bson.M{}
+	for field, value := range fields {
+		if value == nil {
+			unset[field] = ""
+		} else {
+			set[field] = value
+		}
+	}
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	filter := bson.M{"product_id": productID}
+	if _, ok := fields["price"]; ok {
+		// The price of a product with variants follows from the variant prices
+		filter["variants.0"] = bson.M{"$exists": false}
+	}
+
+	var product models.Product
+	err := productCollection.FindOneAndUpdate(ctx,
+		filter,
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&product)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			return product, ErrCantUpdateProduct
+		}
+		if _, err := FindProduct(productCollection, productID); err != nil {
+			return product, err
+		}
+		return product, ErrProductHasVariants
+	}
+	return product, nil
+}
+
+// ArchiveProduct takes a product off sale. It disappears from listings and search and can no
+// longer be added to carts or bought, but stays available to admins and in past orders.
+func ArchiveProduct(productCollection *mongo.Collection, productID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := productCollection.UpdateOne(ctx,
+		bson.M{"product_id": productID, "archived_at": NotArchived},
+		bson.M{"$set": bson.M{"archived_at": time.Now()}},
+	)
+	if err != nil {
+		return ErrCantUpdateProduct
+	}
+	if result.MatchedCount == 0 {
+		if _, err := FindProduct(productCollection, productID); err != nil {
+			return err
+		}
+		return ErrProductArchived
+	}
+	return nil
+}
+
+// RestoreProduct puts an archived product back on sale
+func RestoreProduct(productCollection *mongo.Collection, productID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := productCollection.UpdateOne(ctx,
+		bson.M{"product_id": productID, "archived_at": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"archived_at": ""}},
+	)
+	if err != nil {
+		return ErrCantUpdateProduct
+	}
+	if result.MatchedCount == 0 {
+		if _, err := FindProduct(productCollection, productID); err != nil {
+			return err
+		}
+		return ErrProductNotArchived
+	}
+	return nil
+}
+
+// DeleteProduct removes a product for good. Orders keep their own copy of the product.
+func DeleteProduct(productCollection *mongo.Collection, productID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := productCollection.DeleteOne(ctx, bson.M{"product_id": productID})
+	if err != nil {
+		return ErrCantUpdateProduct
+	}
+	if result.DeletedCount == 0 {
+		return ErrCantFindProduct
+	}
+	return nil
+}
+
+// stockFilter matches a product that can be bought, or its variant when sku is set
+func stockFilter(productID primitive.ObjectID, sku string, minStock int64) bson.M {
+	filter := bson.M{"product_id": productID}
+	if sku == "" {
+		// Products with variants are only sold by variant
+		filter["variants.0"] = bson.M{"$exists": false}
+		if minStock > 0 {
+			filter["stock"] = bson.M{"$gte": minStock}
+		}
+		return filter
+	}
+	variant := bson.M{"sku": sku}
+	if minStock > 0 {
+		variant["stock"] = bson.M{"$gte": minStock}
+	}
+	filter["variants"] = bson.M{"$elemMatch": variant}
+	return filter
+}
+
+// stockUpdate adds delta to the stock of a product matched by stockFilter. For a variant the
+// product stock, which is the sum of the variant stocks, changes along with it.
+func stockUpdate(sku string, delta int64) bson.M {
+	if sku == "" {
+		return bson.M{"$inc": bson.M{"stock": delta}}
+	}
+	return bson.M{"$inc": bson.M{"stock": delta, "variants.$.stock": delta}}
+}
+
+// stockError explains why the stock of a product or variant couldn't be changed, given that
+// the product exists. shortage is returned when there is simply not enough stock.
+func stockError(product models.Product, sku string, shortage error) error {
+	if sku == "" {
+		if len(product.Variants) > 0 {
+			return ErrVariantRequired
+		}
+		return shortage
+	}
+	if _, ok := FindVariant(product, sku); !ok {
+		return ErrCantFindVariant
+	}
+	return shortage
+}
+
+// reserveStock takes one unit of a product, or of its variant when sku is set, out of stock and
+// returns the product. It fails with ErrOutOfStock when none is left, and with ErrProductArchived
+// when the product is off sale.
+func reserveStock(ctx context.Context, productCollection *mongo.Collection, productID primitive.ObjectID, sku string) (models.Product, error) {
+	filter := stockFilter(productID, sku, 1)
+	filter["archived_at"] = NotArchived
+
+	var product models.Product
+	err := productCollection.FindOneAndUpdate(ctx,
+		filter,
+		stockUpdate(sku, -1),
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&product)
+	if err == nil {
+		return product, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return product, ErrCantUpdateProduct
+	}
+
+	product, err = FindProduct(productCollection, productID)
+	if err != nil {
+		return product, err
+	}
+	if product.Archived_At != nil {
+		return product, ErrProductArchived
+	}
+	return product, stockError(product, sku, ErrOutOfStock)
+}
+
+// releaseStock puts back the units taken by reserveStock for an order that couldn't be placed.
+// Failures are logged, as the order has already failed.
+func releaseStock(productCollection *mongo.Collection, items []models.ProductUser) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, item := range items {
+		_, err := productCollection.UpdateOne(ctx, stockFilter(item.Product_ID, item.SKU, 0), stockUpdate(item.SKU, 1))
+		if err != nil {
+			log.Printf("error releasing stock of product %s: %v", item.Product_ID.Hex(), err)
+		}
+	}
+}
+
+// AdjustStock adds delta, which may be negative, to the stock of a product, or of its variant
+// when sku is set, and returns the updated product. Stock never goes below zero;
+// ErrInsufficientStock is returned instead.
+func AdjustStock(productCollection *mongo.Collection, productID primitive.ObjectID, sku string, delta int64) (models.Product, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var minStock int64
+	if delta < 0 {
+		minStock = -delta
+	}
+
+	var product models.Product
+	err := productCollection.FindOneAndUpdate(ctx,
+		stockFilter(productID, sku, minStock),
+		stockUpdate(sku, delta),
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&product)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			return product, ErrCantUpdateProduct
+		}
+		product, err := FindProduct(productCollection, productID)
+		if err != nil {
+			return product, err
+		}
+		return product, stockError(product, sku, ErrInsufficientStock)
+	}
+	return product, nil
+}
+
+// MigrateProductStock gives a stock to products created before stock was tracked. Such products
+// were one of a kind, so they get a stock of 1, or 0 when they have already been sold. It is
+// safe to call on every startup.
+func MigrateProductStock(client *mongo.Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	productCollection := ProductData(client, "Products")
+	missing := bson.M{"stock": bson.M{"$exists": false}}
+
+	count, err := productCollection.CountDocuments(ctx, missing)
+	if err != nil {
+		log.Printf("error checking product stock: %v", err)
+		return
+	}
+	if count == 0 {
+		return
+	}
+
+	soldProductIDs, err := GetSoldProductIDs(UserData(client, "Users"))
+	if err != nil {
+		log.Printf("error fetching sold products for the stock migration: %v", err)
+		return
+	}
+	sold := make([]primitive.ObjectID, 0, len(soldProductIDs))
+	for productID := range soldProductIDs {
+		sold = append(sold, productID)
+	}
+
+	soldOut, err := productCollection.UpdateMany(ctx,
+		bson.M{"stock": bson.M{"$exists": false}, "product_id": bson.M{"$in": sold}},
+		bson.M{"$set": bson.M{"stock": int64(0)}},
+	)
+	if err != nil {
+		log.Printf("error migrating product stock: %v", err)
+		return
+	}
+	inStock, err := productCollection.UpdateMany(ctx, missing, bson.M{"$set": bson.M{"stock": int64(1)}})
+	if err != nil {
+		log.Printf("error migrating product stock: %v", err)
+		return
+	}
+	log.Printf("migrated product stock: %d in stock, %d sold out", inStock.ModifiedCount, soldOut.ModifiedCount)
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestInstantBuyStock(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	productID := primitive.NewObjectID()
+	user := mtest.CreateCursorResponse(0, "test.Users", mtest.FirstBatch, bson.D{
+		{Key: "user_id", Value: "user-1"},
+		{Key: "email_verified", Value: true},
+	})
+	product := func(stock int64) bson.D {
+		return bson.D{
+			{Key: "product_id", Value: productID},
+			{Key: "product_name", Value: "Phone"},
+			{Key: "price", Value: int64(100)},
+			{Key: "rating", Value: int32(4)},
+			{Key: "stock", Value: stock},
+		}
+	}
+	updated := func(n int) bson.D {
+		return bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: n}, {Key: "nModified", Value: n}}
+	}
+
+	tests := []struct {
+		name      string
+		responses []bson.D
+		want      error
+		// wantRelease is whether the reserved unit is put back
+		wantRelease bool
+	}{
+		{
+			name:      "in stock",
+			responses: []bson.D{user, {{Key: "ok", Value: 1}, {Key: "value", Value: product(4)}}, updated(1)},
+		},
+		{
+			name: "out of stock",
+			responses: []bson.D{user, {{Key: "ok", Value: 1}, {Key: "value", Value: nil}},
+				mtest.CreateCursorResponse(0, "test.Products", mtest.FirstBatch, product(0))},
+			want: ErrOutOfStock,
+		},
+		{
+			// The user disappeared between the lookup and the order
+			name:        "order not stored",
+			responses:   []bson.D{user, {{Key: "ok", Value: 1}, {Key: "value", Value: product(4)}}, updated(0), updated(1)},
+			want:        ErrCantBuyCartItem,
+			wantRelease: true,
+		},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			mt.AddMockResponses(tt.responses...)
+
+			if _, _, err := InstantBuy(mt.Coll, mt.Coll, productID, "", "user-1", nil); err != tt.want {
+				mt.Fatalf("InstantBuy() = %v, want %v", err, tt.want)
+			}
+
+			// The unit is taken in the same command that checks there is one left
+			reserve := commandsNamed(mt, "findAndModify")[0]
+			if reserve.Lookup("query", "stock", "$gte").AsInt64() != 1 {
+				mt.Errorf("reserving doesn't require stock: %v", reserve.Lookup("query"))
+			}
+			if reserve.Lookup("update", "$inc", "stock").AsInt64() != -1 {
+				mt.Errorf("reserving doesn't decrement stock: %v", reserve.Lookup("update"))
+			}
+
+			updates := commandsNamed(mt, "update")
+			if tt.want == ErrOutOfStock {
+				if len(updates) != 0 {
+					mt.Error("an order was placed for a product out of stock")
+				}
+				return
+			}
+			// The order is appended, so concurrent purchases don't overwrite each other's orders
+			order := updates[0].Lookup("updates").Array().Index(0).Value().Document().Lookup("u")
+			if _, err := order.Document().LookupErr("$push", "order_status"); err != nil {
+				mt.Errorf("order isn't pushed: %v", order)
+			}
+
+			released := len(updates) == 2 &&
+				updates[1].Lookup("updates").Array().Index(0).Value().Document().Lookup("u", "$inc", "stock").AsInt64() == 1
+			if released != tt.wantRelease {
+				mt.Errorf("stock released = %v, want %v", released, tt.wantRelease)
+			}
+		})
+	}
+}
+
+func TestAdjustStock(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	productID := primitive.NewObjectID()
+	product := bson.D{
+		{Key: "product_id", Value: productID},
+		{Key: "stock", Value: int64(2)},
+		{Key: "variants", Value: bson.A{bson.D{{Key: "sku", Value: "PHONE-BLUE"}, {Key: "stock", Value: int64(2)}}}},
+	}
+	notMatched := bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: nil}}
+	found := mtest.CreateCursorResponse(0, "test.Products", mtest.FirstBatch, product)
+
+	tests := []struct {
+		name      string
+		sku       string
+		delta     int64
+		responses []bson.D
+		want      error
+		// wantMin is the stock the product or variant needs for the change, 0 when none
+		wantMin int64
+	}{
+		{"restock variant", "PHONE-BLUE", 5, []bson.D{{{Key: "ok", Value: 1}, {Key: "value", Value: product}}}, nil, 0},
+		{"more than in stock", "PHONE-BLUE", -3, []bson.D{notMatched, found}, ErrInsufficientStock, 3},
+		{"unknown variant", "PHONE-RED", 1, []bson.D{notMatched, found}, ErrCantFindVariant, 0},
+		{"product sold by variant", "", 1, []bson.D{notMatched, found}, ErrVariantRequired, 0},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			mt.AddMockResponses(tt.responses...)
+
+			if _, err := AdjustStock(mt.Coll, productID, tt.sku, tt.delta); err != tt.want {
+				mt.Fatalf("AdjustStock() = %v, want %v", err, tt.want)
+			}
+
+			command := commandsNamed(mt, "findAndModify")[0]
+			query := command.Lookup("query").Document()
+			stock := query.Lookup("stock")
+			if tt.sku != "" {
+				stock = query.Lookup("variants", "$elemMatch", "stock")
+			}
+			if tt.wantMin == 0 {
+				if stock.Type != 0 {
+					mt.Errorf("an increase requires stock: %v", query)
+				}
+			} else if stock.Document().Lookup("$gte").AsInt64() != tt.wantMin {
+				mt.Errorf("stock can go below zero: %v", query)
+			}
+			// The product stock is the sum of the variant stocks, so both change together
+			if tt.sku != "" && command.Lookup("update", "$inc", "stock").AsInt64() != tt.delta {
+				mt.Errorf("product stock doesn't follow the variant: %v", command.Lookup("update"))
+			}
+		})
+	}
+}
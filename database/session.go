@@ -0,0 +1,178 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github/akhil/ecommerce-yt/models"
+	"github/akhil/ecommerce-yt/tokens"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrSessionNotFound = errors.New("session not found or already ended")
+
+// sessionTouchInterval limits how often a session's last seen time is written
+const sessionTouchInterval = time.Minute
+
+var sessionTouches = struct {
+	sync.Mutex
+	at map[string]time.Time
+}{at: make(map[string]time.Time)}
+
+// CreateSession stores a new login session
+func CreateSession(sessionCollection *mongo.Collection, session models.Session) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := sessionCollection.InsertOne(ctx, session)
+	return err
+}
+
+// FindSession returns an active session of the user
+func FindSession(sessionCollection *mongo.Collection, userID, sessionID string) (models.Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var session models.Session
+	err := sessionCollection.FindOne(ctx, bson.M{
+		"session_id": sessionID,
+		"user_id":    userID,
+		"revoked_at": bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&session)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return session, ErrSessionNotFound
+		}
+		return session, err
+	}
+	return session, nil
+}
+
+// RotateSessionRefreshToken replaces the refresh token hash of a session, provided oldHash is
+// still the one on record. It returns false when that refresh token has already been used.
+func RotateSessionRefreshToken(sessionCollection *mongo.Collection, sessionID, oldHash, newHash, userAgent, ip string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	result, err := sessionCollection.UpdateOne(ctx,
+		bson.M{"session_id": sessionID, "refresh_token_hash": oldHash, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{
+			"refresh_token_hash": newHash,
+			"user_agent":         userAgent,
+			"ip":                 ip,
+			"last_seen_at":       now,
+			"expires_at":         now.Add(tokens.RefreshTokenTTL),
+		}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// TouchSession records that a session was just used from ip. Writes are skipped when the
+// session was touched by this instance less than a minute ago.
+func TouchSession(sessionCollection *mongo.Collection, sessionID, ip string) error {
+	now := time.Now()
+
+	sessionTouches.Lock()
+	last, ok := sessionTouches.at[sessionID]
+	if ok && now.Sub(last) < sessionTouchInterval {
+		sessionTouches.Unlock()
+		return nil
+	}
+	sessionTouches.at[sessionID] = now
+	// Drop old entries so the map doesn't grow with every session ever seen
+	if len(sessionTouches.at) > 10000 {
+		for id, at := range sessionTouches.at {
+			if now.Sub(at) >= sessionTouchInterval {
+				delete(sessionTouches.at, id)
+			}
+		}
+	}
+	sessionTouches.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := sessionCollection.UpdateOne(ctx,
+		bson.M{"session_id": sessionID},
+		bson.M{"$set": bson.M{"last_seen_at": now, "ip": ip}},
+	)
+	return err
+}
+
+// ListSessions returns the active sessions of a user, most recently used first
+func ListSessions(sessionCollection *mongo.Collection, userID string) ([]models.Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "last_seen_at", Value: -1}})
+	cursor, err := sessionCollection.Find(ctx, bson.M{
+		"user_id":    userID,
+		"revoked_at": bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": time.Now()},
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	sessions := make([]models.Session, 0)
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// RevokeSession ends a session of the user. Its refresh token stops working immediately, and
+// its access tokens through an entry in the revocation store.
+func RevokeSession(sessionCollection, revokedCollection *mongo.Collection, userID, sessionID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	result, err := sessionCollection.UpdateOne(ctx,
+		bson.M{"session_id": sessionID, "user_id": userID, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": now}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrSessionNotFound
+	}
+
+	// Access tokens of the session live at most AccessTokenTTL after the last refresh
+	return tokens.RevokeTokenID(tokens.SessionRevocationID(sessionID), userID, now.Add(tokens.AccessTokenTTL), revokedCollection, ctx)
+}
+
+// RevokeAllSessions marks every session of the user as ended. It doesn't revoke their
+// access tokens; callers bump the user's token version for that.
+func RevokeAllSessions(sessionCollection *mongo.Collection, userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := sessionCollection.UpdateMany(ctx,
+		bson.M{"user_id": userID, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	return err
+}
+
+// DeleteSessions removes every session of the user, ended ones included, along with the client
+// IP and user agent they record. Used when an account is deleted.
+func DeleteSessions(sessionCollection *mongo.Collection, userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := sessionCollection.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
@@ -0,0 +1,73 @@
+package database
+
+import (
+	"bytes"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestRotateSessionRefreshToken(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	tests := []struct {
+		name    string
+		matched int32
+		rotated bool
+	}{
+		// The presented token is the session's current one
+		{"current refresh token", 1, true},
+		// The presented token was already rotated out, or another request rotated it first
+		{"replayed refresh token", 0, false},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: tt.matched}, {Key: "nModified", Value: tt.matched}})
+
+			rotated, err := RotateSessionRefreshToken(mt.Coll, "session-1", "old-hash", "new-hash", "agent", "127.0.0.1")
+			if err != nil {
+				mt.Fatalf("RotateSessionRefreshToken: %v", err)
+			}
+			if rotated != tt.rotated {
+				mt.Errorf("rotated = %v, want %v", rotated, tt.rotated)
+			}
+
+			// Only the current hash of a live session may be swapped, in a single update
+			update := mt.GetStartedEvent().Command.Lookup("updates").Array().Index(0).Value().Document()
+			filter := update.Lookup("q").Document()
+			if hash := filter.Lookup("refresh_token_hash").StringValue(); hash != "old-hash" {
+				mt.Errorf("filter matches refresh_token_hash %q, want the presented hash", hash)
+			}
+			if _, err := filter.LookupErr("revoked_at"); err != nil {
+				mt.Error("filter doesn't exclude revoked sessions")
+			}
+			if hash := update.Lookup("u", "$set", "refresh_token_hash").StringValue(); hash != "new-hash" {
+				mt.Errorf("update sets refresh_token_hash %q, want the new hash", hash)
+			}
+		})
+	}
+}
+
+func TestDeleteSessions(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("deletes ended sessions too", func(mt *mtest.T) {
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 3}})
+
+		if err := DeleteSessions(mt.Coll, "user-1"); err != nil {
+			mt.Fatalf("DeleteSessions: %v", err)
+		}
+
+		// Revoked sessions still hold the IP and user agent, so the filter must not skip them
+		filter := mt.GetStartedEvent().Command.Lookup("deletes").Array().Index(0).Value().Document().Lookup("q").Document()
+		want := bson.D{{Key: "user_id", Value: "user-1"}}
+		got, err := bson.Marshal(want)
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if !bytes.Equal(filter, got) {
+			mt.Errorf("filter = %v, want %v", filter, want)
+		}
+	})
+}
@@ -0,0 +1,156 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrPhoneInUse is returned when a phone number is already verified on another account
+var ErrPhoneInUse = errors.New("this phone number is already verified on another account")
+
+// UpdatePassword stores a new password hash and revokes every token of the user
+func UpdatePassword(userCollection *mongo.Collection, userID, hashedPassword string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := userCollection.UpdateOne(ctx,
+		bson.M{"user_id": userID},
+		bson.M{
+			"$set": bson.M{
+				"password":      hashedPassword,
+				"token":         nil,
+				"refresh_token": nil,
+				"updated_at":    time.Now(),
+			},
+			"$inc": bson.M{"token_version": 1},
+		},
+	)
+	if err != nil {
+		return ErrCantUpdateUser
+	}
+	if result.MatchedCount == 0 {
+		return ErrCantFindUser
+	}
+
+	InvalidateUserAuthState(userID)
+	return nil
+}
+
+// MarkEmailVerified marks the user's email as verified, provided it is still the given address
+func MarkEmailVerified(userCollection *mongo.Collection, userID, email string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	result, err := userCollection.UpdateOne(ctx,
+		bson.M{"user_id": userID, "email": email},
+		bson.M{"$set": bson.M{
+			"email_verified":    true,
+			"email_verified_at": now,
+			"updated_at":        now,
+		}},
+	)
+	if err != nil {
+		return ErrCantUpdateUser
+	}
+	if result.MatchedCount == 0 {
+		return ErrCantFindUser
+	}
+	return nil
+}
+
+// MarkPhoneVerified marks the user's phone number as verified, provided it is still the given number.
+// A number can only be verified on one account, which a unique index enforces.
+func MarkPhoneVerified(userCollection *mongo.Collection, userID, phone string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	result, err := userCollection.UpdateOne(ctx,
+		bson.M{"user_id": userID, "phone": phone},
+		bson.M{"$set": bson.M{
+			"phone_verified":    true,
+			"phone_verified_at": now,
+			"updated_at":        now,
+		}},
+	)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrPhoneInUse
+		}
+		return ErrCantUpdateUser
+	}
+	if result.MatchedCount == 0 {
+		return ErrCantFindUser
+	}
+	return nil
+}
+
+// RehashPassword replaces a password hash with an upgraded hash of the same password.
+// Nothing changes if the password was changed in the meantime, and tokens stay valid.
+func RehashPassword(userCollection *mongo.Collection, userID, oldHash, newHash string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := userCollection.UpdateOne(ctx,
+		bson.M{"user_id": userID, "password": oldHash},
+		bson.M{"$set": bson.M{"password": newHash}},
+	)
+	if err != nil {
+		return ErrCantUpdateUser
+	}
+	return nil
+}
+
+// UpdateProfile sets the given profile fields of the user
+func UpdateProfile(userCollection *mongo.Collection, userID string, fields bson.M) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fields["updated_at"] = time.Now()
+	result, err := userCollection.UpdateOne(ctx, bson.M{"user_id": userID}, bson.M{"$set": fields})
+	if err != nil {
+		return ErrCantUpdateUser
+	}
+	if result.MatchedCount == 0 {
+		return ErrCantFindUser
+	}
+	return nil
+}
+
+// SetPendingEmail records an address the user wants to switch to once it is confirmed
+func SetPendingEmail(userCollection *mongo.Collection, userID, email string) error {
+	return UpdateProfile(userCollection, userID, bson.M{"pending_email": email})
+}
+
+// ConfirmEmailChange makes the pending address the user's verified email, provided it is
+// still the address the user asked for
+func ConfirmEmailChange(userCollection *mongo.Collection, userID, email string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	result, err := userCollection.UpdateOne(ctx,
+		bson.M{"user_id": userID, "pending_email": email},
+		bson.M{
+			"$set": bson.M{
+				"email":             email,
+				"email_verified":    true,
+				"email_verified_at": now,
+				"updated_at":        now,
+			},
+			"$unset": bson.M{"pending_email": ""},
+		},
+	)
+	if err != nil {
+		return ErrCantUpdateUser
+	}
+	if result.MatchedCount == 0 {
+		return ErrCantFindUser
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github/akhil/ecommerce-yt/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantFindVariant    = errors.New("can't find variant")
+	ErrVariantRequired    = errors.New("choose a variant of this product")
+	ErrProductHasVariants = errors.New("product is sold by variant, change its variants instead")
+	ErrDuplicateSKU       = errors.New("sku is already in use")
+)
+
+// FindVariant returns the variant of product with the given SKU
+func FindVariant(product models.Product, sku string) (models.ProductVariant, bool) {
+	for _, variant := range product.Variants {
+		if variant.SKU == sku {
+			return variant, true
+		}
+	}
+	return models.ProductVariant{}, false
+}
+
+// recomputeVariantTotals sets the price of a product with variants to the lowest variant price
+// and its stock to the sum of the variant stocks. It reads and writes in one update, so it is
+// correct even while variants are being bought. A product left without variants keeps its
+// price and has no stock.
+func recomputeVariantTotals(ctx context.Context, productCollection *mongo.Collection, productID primitive.ObjectID) (models.Product, error) {
+	var product models.Product
+	err := productCollection.FindOneAndUpdate(ctx,
+		bson.M{"product_id": productID},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"stock": bson.M{"$sum": "$variants.stock"},
+			"price": bson.M{"$ifNull": bson.A{bson.M{"$min": "$variants.price"}, "$price"}},
+		}}}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&product)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return product, ErrCantFindProduct
+		}
+		return product, ErrCantUpdateProduct
+	}
+	return product, nil
+}
+
+// SetProductOptions replaces the option axes of a product. Axes can only change while the
+// product has no variants, as every variant has a value for each of them.
+func SetProductOptions(productCollection *mongo.Collection, productID primitive.ObjectID, productOptions []models.ProductOption) (models.Product, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{"options": productOptions}}
+	if len(productOptions) == 0 {
+		update = bson.M{"$unset": bson.M{"options": ""}}
+	}
+
+	var product models.Product
+	err := productCollection.FindOneAndUpdate(ctx,
+		bson.M{"product_id": productID, "variants.0": bson.M{"$exists": false}},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&product)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			return product, ErrCantUpdateProduct
+		}
+		if _, err := FindProduct(productCollection, productID); err != nil {
+			return product, err
+		}
+		return product, ErrProductHasVariants
+	}
+	return product, nil
+}
+
+// AddVariant adds a variant to a product and returns the updated product. The first variant
+// turns a product into one that is sold by variant.
+func AddVariant(productCollection *mongo.Collection, productID primitive.ObjectID, variant models.ProductVariant) (models.Product, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := productCollection.UpdateOne(ctx,
+		bson.M{"product_id": productID, "variants.sku": bson.M{"$ne": variant.SKU}},
+		bson.M{"$push": bson.M{"variants": variant}},
+	)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return models.Product{}, ErrDuplicateSKU
+		}
+		return models.Product{}, ErrCantUpdateProduct
+	}
+	if result.MatchedCount == 0 {
+		if _, err := FindProduct(productCollection, productID); err != nil {
+			return models.Product{}, err
+		}
+		return models.Product{}, ErrDuplicateSKU
+	}
+
+	return recomputeVariantTotals(ctx, productCollection, productID)
+}
+
+// UpdateVariant sets the given fields of a variant and returns the updated product
+func UpdateVariant(productCollection *mongo.Collection, productID primitive.ObjectID, sku string, fields bson.M) (models.Product, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	set := bson.M{}
+	for field, value := range fields {
+		set["variants.$."+field] = value
+	}
+
+	result, err := productCollection.UpdateOne(ctx,
+		bson.M{"product_id": productID, "variants.sku": sku},
+		bson.M{"$set": set},
+	)
+	if err != nil {
+		return models.Product{}, ErrCantUpdateProduct
+	}
+	if result.MatchedCount == 0 {
+		if _, err := FindProduct(productCollection, productID); err != nil {
+			return models.Product{}, err
+		}
+		return models.Product{}, ErrCantFindVariant
+	}
+
+	return recomputeVariantTotals(ctx, productCollection, productID)
+}
+
+// RemoveVariant removes a variant, and its stock, from a product and returns the updated product.
+// Carts and past orders keep their copy of the variant.
+func RemoveVariant(productCollection *mongo.Collection, productID primitive.ObjectID, sku string) (models.Product, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := productCollection.UpdateOne(ctx,
+		bson.M{"product_id": productID, "variants.sku": sku},
+		bson.M{"$pull": bson.M{"variants": bson.M{"sku": sku}}},
+	)
+	if err != nil {
+		return models.Product{}, ErrCantUpdateProduct
+	}
+	if result.MatchedCount == 0 {
+		if _, err := FindProduct(productCollection, productID); err != nil {
+			return models.Product{}, err
+		}
+		return models.Product{}, ErrCantFindVariant
+	}
+
+	return recomputeVariantTotals(ctx, productCollection, productID)
+}
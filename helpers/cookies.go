@@ -0,0 +1,100 @@
+package helpers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+
+	"github/akhil/ecommerce-yt/tokens"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Cookie session names. The CSRF cookie is readable by scripts so the frontend can echo
+// it in CSRFHeader (double-submit); the token cookies are HttpOnly.
+const (
+	AccessTokenCookie  = "access_token"
+	RefreshTokenCookie = "refresh_token"
+	CSRFCookie         = "csrf_token"
+	CSRFHeader         = "X-CSRF-Token"
+)
+
+const (
+	accessCookieMaxAge  = 24 * 60 * 60
+	refreshCookieMaxAge = 7 * 24 * 60 * 60
+	refreshCookiePath   = "/api/v1/users/refresh"
+)
+
+// SessionLoginResponse represents a login response in cookie session mode; the tokens are only sent as cookies
+type SessionLoginResponse struct {
+	Success   bool   `json:"success"`
+	Message   string `json:"message"`
+	UserID    string `json:"user_id"`
+	CSRFToken string `json:"csrf_token"`
+}
+
+// WantsCookieSession reports whether the client asked for a cookie session with ?mode=cookie.
+// Handlers that learn the mode some other way, such as an identity provider callback, set
+// "cookie_session" on the context instead.
+func WantsCookieSession(c *gin.Context) bool {
+	return c.Query("mode") == "cookie" || c.GetBool("cookie_session")
+}
+
+// cookieSecure is true unless COOKIE_SECURE=false, which allows cookies over plain HTTP in development
+func cookieSecure() bool {
+	return os.Getenv("COOKIE_SECURE") != "false"
+}
+
+// SetSessionCookies stores the token pair in HttpOnly cookies and returns a new CSRF token,
+// which is also set as a readable cookie
+func SetSessionCookies(c *gin.Context, token, refreshToken string) (string, error) {
+	csrfToken, err := tokens.GenerateOpaqueToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	domain := os.Getenv("COOKIE_DOMAIN")
+	secure := cookieSecure()
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(AccessTokenCookie, token, accessCookieMaxAge, "/", domain, secure, true)
+	c.SetCookie(CSRFCookie, csrfToken, refreshCookieMaxAge, "/", domain, secure, false)
+
+	// The refresh token is only ever sent to the refresh endpoint
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(RefreshTokenCookie, refreshToken, refreshCookieMaxAge, refreshCookiePath, domain, secure, true)
+
+	return csrfToken, nil
+}
+
+// ValidCSRFToken reports whether the CSRF header matches the CSRF cookie
+func ValidCSRFToken(c *gin.Context) bool {
+	cookie, err := c.Cookie(CSRFCookie)
+	header := c.GetHeader(CSRFHeader)
+	if err != nil || cookie == "" || header == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie), []byte(header)) == 1
+}
+
+// ClearSessionCookies removes the session cookies
+func ClearSessionCookies(c *gin.Context) {
+	domain := os.Getenv("COOKIE_DOMAIN")
+	secure := cookieSecure()
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(AccessTokenCookie, "", -1, "/", domain, secure, true)
+	c.SetCookie(CSRFCookie, "", -1, "/", domain, secure, false)
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(RefreshTokenCookie, "", -1, refreshCookiePath, domain, secure, true)
+}
+
+// SessionLoginSuccess sends a successful login response for a cookie session
+func SessionLoginSuccess(c *gin.Context, message, userID, csrfToken string) {
+	c.JSON(http.StatusOK, SessionLoginResponse{
+		Success:   true,
+		Message:   message,
+		UserID:    userID,
+		CSRFToken: csrfToken,
+	})
+}
@@ -0,0 +1,81 @@
+package helpers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSetSessionCookies(t *testing.T) {
+	t.Setenv("COOKIE_SECURE", "")
+	t.Setenv("COOKIE_DOMAIN", "")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+
+	csrfToken, err := SetSessionCookies(c, "access", "refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cookies := map[string]*http.Cookie{}
+	for _, cookie := range w.Result().Cookies() {
+		cookies[cookie.Name] = cookie
+	}
+
+	tests := []struct {
+		name     string
+		value    string
+		httpOnly bool
+		sameSite http.SameSite
+		path     string
+	}{
+		{AccessTokenCookie, "access", true, http.SameSiteLaxMode, "/"},
+		// The frontend reads it to echo it in CSRFHeader
+		{CSRFCookie, csrfToken, false, http.SameSiteLaxMode, "/"},
+		{RefreshTokenCookie, "refresh", true, http.SameSiteStrictMode, refreshCookiePath},
+	}
+	for _, tt := range tests {
+		cookie, ok := cookies[tt.name]
+		if !ok {
+			t.Errorf("cookie %s wasn't set", tt.name)
+			continue
+		}
+		if cookie.Value != tt.value || cookie.HttpOnly != tt.httpOnly || cookie.SameSite != tt.sameSite || cookie.Path != tt.path || !cookie.Secure {
+			t.Errorf("cookie %s = %+v, want value %q, HttpOnly %v, SameSite %v, path %s and Secure",
+				tt.name, cookie, tt.value, tt.httpOnly, tt.sameSite, tt.path)
+		}
+	}
+}
+
+func TestValidCSRFToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		cookie string
+		header string
+		want   bool
+	}{
+		{"matching", "abc", "abc", true},
+		{"different", "abc", "abd", false},
+		{"no header", "abc", "", false},
+		{"no cookie", "", "abc", false},
+		// Two missing values must not count as a match
+		{"neither", "", "", false},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+		if tt.cookie != "" {
+			c.Request.AddCookie(&http.Cookie{Name: CSRFCookie, Value: tt.cookie})
+		}
+		if tt.header != "" {
+			c.Request.Header.Set(CSRFHeader, tt.header)
+		}
+		if got := ValidCSRFToken(c); got != tt.want {
+			t.Errorf("%s: ValidCSRFToken() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -12,6 +12,7 @@ type Response struct {
 	Message string      `json:"message,omitempty"`
 	Data    interface{} `json:"data,omitempty"`
 	Error   string      `json:"error,omitempty"`
+	Code    string      `json:"code,omitempty"`
 }
 
 // LoginResponse represents a login-specific response
@@ -23,6 +24,15 @@ type LoginResponse struct {
 	RefreshToken string `json:"refresh_token"`
 }
 
+// MFAChallengeResponse is sent instead of tokens when a login needs a second factor
+type MFAChallengeResponse struct {
+	Success          bool   `json:"success"`
+	Message          string `json:"message"`
+	MFARequired      bool   `json:"mfa_required"`
+	MFASetupRequired bool   `json:"mfa_setup_required,omitempty"`
+	MFAToken         string `json:"mfa_token"`
+}
+
 // Success sends a successful response
 func Success(c *gin.Context, message string, data interface{}) {
 	c.JSON(http.StatusOK, Response{
@@ -40,6 +50,31 @@ func Error(c *gin.Context, statusCode int, message string) {
 	})
 }
 
+// ErrorWithCode sends an error response with a machine-readable error code
+func ErrorWithCode(c *gin.Context, statusCode int, code, message string) {
+	c.JSON(statusCode, Response{
+		Success: false,
+		Error:   message,
+		Code:    code,
+	})
+}
+
+// ErrorWithDetails sends an error response with a machine-readable error code and details in data
+func ErrorWithDetails(c *gin.Context, statusCode int, code, message string, details interface{}) {
+	c.JSON(statusCode, Response{
+		Success: false,
+		Error:   message,
+		Code:    code,
+		Data:    details,
+	})
+}
+
+// JSONAttachment sends data as a JSON file download named filename
+func JSONAttachment(c *gin.Context, filename string, data interface{}) {
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.IndentedJSON(http.StatusOK, data)
+}
+
 // LoginSuccess sends a successful login response
 func LoginSuccess(c *gin.Context, userID, token, refreshToken string) {
 	c.JSON(http.StatusOK, LoginResponse{
@@ -51,6 +86,32 @@ func LoginSuccess(c *gin.Context, userID, token, refreshToken string) {
 	})
 }
 
+// RefreshSuccess sends a successful token refresh response
+func RefreshSuccess(c *gin.Context, userID, token, refreshToken string) {
+	c.JSON(http.StatusOK, LoginResponse{
+		Success:      true,
+		Message:      "Token Refreshed Successfully",
+		UserID:       userID,
+		Token:        token,
+		RefreshToken: refreshToken,
+	})
+}
+
+// MFAChallenge sends the response of a password step that needs a second factor
+func MFAChallenge(c *gin.Context, mfaToken string, setupRequired bool) {
+	message := "Enter the code from your authenticator app"
+	if setupRequired {
+		message = "Two-factor authentication must be set up before you can log in"
+	}
+	c.JSON(http.StatusOK, MFAChallengeResponse{
+		Success:          true,
+		Message:          message,
+		MFARequired:      true,
+		MFASetupRequired: setupRequired,
+		MFAToken:         mfaToken,
+	})
+}
+
 // BadRequest sends a 400 Bad Request response
 func BadRequest(c *gin.Context, message string) {
 	Error(c, http.StatusBadRequest, message)
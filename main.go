@@ -1,12 +1,17 @@
 package main
 
 import (
+	"flag"
 	"github/akhil/ecommerce-yt/controllers"
 	"github/akhil/ecommerce-yt/database"
+	"github/akhil/ecommerce-yt/mailer"
 	"github/akhil/ecommerce-yt/middleware"
 	"github/akhil/ecommerce-yt/routes"
+	"github/akhil/ecommerce-yt/sms"
+	"github/akhil/ecommerce-yt/tokens"
 	"log"
 	"os"
+	"path/filepath"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -23,6 +28,30 @@ func main() {
 		port = "8000"
 	}
 
+	// `go run main.go generate-jwt-key ...` writes a new signing key and exits without touching the database
+	if len(os.Args) > 1 && os.Args[1] == "generate-jwt-key" {
+		generateJWTKey(os.Args[2:])
+		return
+	}
+
+	if err := tokens.LoadKeys(); err != nil {
+		log.Fatalf("Error loading JWT keys: %v", err)
+	}
+	if err := database.CheckConnection(database.Client); err != nil {
+		log.Fatalf("Error pinging MongoDB: %v", err)
+	}
+	database.EnsureIndexes(database.Client)
+	database.MigrateProductStock(database.Client)
+
+	// `go run main.go bootstrap-admin ...` creates the first admin account and exits
+	if len(os.Args) > 1 && os.Args[1] == "bootstrap-admin" {
+		bootstrapAdmin(os.Args[2:])
+		return
+	}
+	controllers.BootstrapAdminFromEnv()
+	controllers.Mailer = mailer.FromEnv()
+	controllers.SMS = sms.FromEnv()
+
 	app := controllers.NewApplication(database.ProductData(database.Client, "Products"), database.UserData(database.Client, "Users"))
 
 	router := gin.New()
@@ -34,11 +63,17 @@ func main() {
 
 	// Public routes (no authentication required)
 	routes.UserRoutes(router)
+	routes.WellKnownRoutes(router)
 
-	// Protected routes (authentication required)
+	// Protected routes (authentication required; API keys are only accepted on admin routes)
 	protected := router.Group("/")
 	protected.Use(middleware.Authentication())
+	protected.Use(middleware.RejectAPIKeys())
+	protected.Use(middleware.CSRFProtection())
 	{
+		// Session and account routes (logout, profile, two-factor)
+		routes.AuthRoutes(protected)
+
 		// Product routes (accessible to all authenticated users)
 		routes.ProductRoutes(protected)
 
@@ -49,9 +84,10 @@ func main() {
 		routes.AddressRoutes(protected, app)
 	}
 
-	// Admin routes (authentication + admin privileges required)
+	// Admin routes (authentication + a staff role or an API key required; permissions are checked per route group)
 	admin := router.Group("/")
 	admin.Use(middleware.Authentication())
+	admin.Use(middleware.CSRFProtection())
 	admin.Use(middleware.AdminAuth())
 	{
 		routes.AdminRoutes(admin)
@@ -59,3 +95,45 @@ func main() {
 
 	log.Fatal(router.Run(":" + port))
 }
+
+// bootstrapAdmin handles the bootstrap-admin command
+func bootstrapAdmin(args []string) {
+	fs := flag.NewFlagSet("bootstrap-admin", flag.ExitOnError)
+	email := fs.String("email", "", "admin email (required)")
+	password := fs.String("password", "", "admin password (required)")
+	firstName := fs.String("first-name", "Admin", "admin first name")
+	lastName := fs.String("last-name", "User", "admin last name")
+	phone := fs.String("phone", "", "admin phone number (required)")
+	fs.Parse(args)
+
+	if err := controllers.BootstrapAdmin(*email, *password, *firstName, *lastName, *phone); err != nil {
+		log.Fatalf("Error bootstrapping admin account: %v", err)
+	}
+	log.Printf("Admin account %s created", *email)
+}
+
+// generateJWTKey handles the generate-jwt-key command
+func generateJWTKey(args []string) {
+	fs := flag.NewFlagSet("generate-jwt-key", flag.ExitOnError)
+	kid := fs.String("kid", "", "key id, used as the file name (required)")
+	alg := fs.String("alg", tokens.AlgEdDSA, "signing algorithm: EdDSA or RS256")
+	dir := fs.String("dir", "keys", "directory to write the key to")
+	fs.Parse(args)
+
+	if *kid == "" {
+		log.Fatal("-kid is required")
+	}
+
+	key, err := tokens.GenerateKeyPEM(*alg)
+	if err != nil {
+		log.Fatalf("Error generating key: %v", err)
+	}
+	if err := os.MkdirAll(*dir, 0700); err != nil {
+		log.Fatalf("Error creating key directory: %v", err)
+	}
+	path := filepath.Join(*dir, *kid+".pem")
+	if err := os.WriteFile(path, key, 0600); err != nil {
+		log.Fatalf("Error writing key: %v", err)
+	}
+	log.Printf("Wrote %s key %s", *alg, path)
+}
@@ -0,0 +1,138 @@
+package mfa
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of the RFC 6238 test vectors, "12345678901234567890", in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestGenerateCodeMatchesRFC6238(t *testing.T) {
+	key, err := encoding.DecodeString(rfcSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// RFC 6238 appendix B lists 8 digit codes; the 6 digit codes are their last 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if got := generateCode(key, tt.unix/period); got != tt.want {
+			t.Errorf("code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateCodeWindow(t *testing.T) {
+	key, err := encoding.DecodeString(rfcSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / period
+
+	tests := []struct {
+		name     string
+		step     int64
+		valid    bool
+		wantStep int64
+	}{
+		{"current step", current, true, current},
+		{"previous step", current - 1, true, current - 1},
+		{"next step", current + 1, true, current + 1},
+		{"two steps ago", current - 2, false, 0},
+		{"two steps ahead", current + 2, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := ValidateCode(rfcSecret, generateCode(key, tt.step), now)
+			if ok != tt.valid || step != tt.wantStep {
+				t.Errorf("ValidateCode() = (%d, %v), want (%d, %v)", step, ok, tt.wantStep, tt.valid)
+			}
+		})
+	}
+}
+
+func TestValidateCodeInput(t *testing.T) {
+	now := time.Unix(59, 0)
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		valid  bool
+	}{
+		{"valid code", rfcSecret, "287082", true},
+		{"surrounding spaces", rfcSecret, " 287082 ", true},
+		{"lowercase secret", strings.ToLower(rfcSecret), "287082", true},
+		{"wrong code", rfcSecret, "287083", false},
+		{"too short", rfcSecret, "28708", false},
+		{"8 digit code", rfcSecret, "94287082", false},
+		{"empty", rfcSecret, "", false},
+		{"invalid secret", "not base32!", "287082", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := ValidateCode(tt.secret, tt.code, now); ok != tt.valid {
+				t.Errorf("ValidateCode(%q, %q) = %v, want %v", tt.secret, tt.code, ok, tt.valid)
+			}
+		})
+	}
+}
+
+func TestValidateCodeReturnsStepForReplayCheck(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := encoding.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A code stays valid for the whole window, so callers rely on the step to refuse a second use
+	issued := time.Unix(1700000000, 0)
+	code := generateCode(key, issued.Unix()/period)
+	first, ok := ValidateCode(secret, code, issued)
+	if !ok {
+		t.Fatal("fresh code was rejected")
+	}
+	again, ok := ValidateCode(secret, code, issued.Add(period*time.Second))
+	if !ok {
+		t.Fatal("code from the previous step was rejected")
+	}
+	if again != first {
+		t.Errorf("the same code matched steps %d and %d", first, again)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("recovery code %q isn't formatted as xxxxx-xxxxx", code)
+		}
+		if seen[code] {
+			t.Errorf("recovery code %q generated twice", code)
+		}
+		seen[code] = true
+		if got := NormalizeRecoveryCode(" " + strings.ToUpper(code[:5]) + " " + code[5:]); got != code {
+			t.Errorf("NormalizeRecoveryCode() = %q, want %q", got, code)
+		}
+	}
+}
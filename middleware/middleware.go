@@ -2,25 +2,77 @@ package middleware
 
 import (
 	"context"
+	"log"
 	"net/http"
+	"strings"
 	"time"
 
-	// "strings"
-
 	"github/akhil/ecommerce-yt/database"
+	"github/akhil/ecommerce-yt/helpers"
 	"github/akhil/ecommerce-yt/models"
+	"github/akhil/ecommerce-yt/roles"
 	"github/akhil/ecommerce-yt/tokens"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
-// Authentication middleware validates JWT tokens and sets user info in context
+// extractToken reads the access token from the Authorization: Bearer header, the legacy
+// token header or the session cookie, in that order. fromCookie reports the latter.
+func extractToken(c *gin.Context) (token string, fromCookie bool) {
+	if authHeader := c.GetHeader("Authorization"); authHeader != "" {
+		scheme, credentials, found := strings.Cut(authHeader, " ")
+		if found && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(credentials), false
+		}
+	}
+
+	if token := c.GetHeader("token"); token != "" {
+		return token, false
+	}
+
+	if cookie, err := c.Cookie(helpers.AccessTokenCookie); err == nil && cookie != "" {
+		return cookie, true
+	}
+
+	return "", false
+}
+
+// APIKeyHeader carries an API key in place of an access token
+const APIKeyHeader = "X-API-Key"
+
+// authenticateAPIKey authenticates the request with the API key in key. The key's id stands in
+// for the user id, prefixed so audit entries show the action was taken by a key.
+func authenticateAPIKey(c *gin.Context, key string) {
+	apiKey, err := database.UseAPIKey(database.UserData(database.Client, "APIKeys"), key, c.ClientIP())
+	if err != nil {
+		if err == database.ErrInvalidAPIKey {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error checking API key"})
+		c.Abort()
+		return
+	}
+
+	c.Set("user_id", "apikey:"+apiKey.Key_ID.Hex())
+	c.Set("roles", []string{})
+	c.Set("api_key_id", apiKey.Key_ID.Hex())
+	c.Set("api_key_scopes", apiKey.Scopes)
+
+	c.Next()
+}
+
+// Authentication middleware validates JWT tokens or API keys and sets user info in context
 func Authentication() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Get token from header
-		clientToken := c.GetHeader("token")
+		if key := c.GetHeader(APIKeyHeader); key != "" {
+			authenticateAPIKey(c, key)
+			return
+		}
+
+		// Get token from header or session cookie
+		clientToken, fromCookie := extractToken(c)
 
 		if clientToken == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "No Authorization header provided"})
@@ -36,47 +88,207 @@ func Authentication() gin.HandlerFunc {
 			return
 		}
 
+		// Only access tokens authenticate requests; tokens without a type predate token types.
+		// Refresh and email verification tokens must never be accepted here.
+		if claims.Token_Type != tokens.AccessTokenType && claims.Token_Type != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "only access tokens can be used for authentication"})
+			c.Abort()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		// Reject tokens revoked by logout, on their own or with their session
+		revokedIDs := []string{claims.ID, tokens.SessionRevocationID(claims.Session_ID)}
+		revoked, revokeErr := tokens.IsTokenIDRevoked(revokedIDs, database.UserData(database.Client, "RevokedTokens"), ctx)
+		if revokeErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error checking token status"})
+			c.Abort()
+			return
+		}
+		if revoked {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "token has been revoked"})
+			c.Abort()
+			return
+		}
+
+		// Reject tokens issued before the user logged out from all devices. The user's
+		// current state comes from a short-lived in-process cache instead of a lookup per request.
+		state, stateErr := database.GetUserAuthState(database.UserData(database.Client, "Users"), claims.User_ID)
+		if stateErr != nil {
+			if stateErr == database.ErrCantFindUser {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+				c.Abort()
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error checking token status"})
+			c.Abort()
+			return
+		}
+		if claims.Token_Version < state.Token_Version {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "token has been revoked"})
+			c.Abort()
+			return
+		}
+
+		// Authorize with the roles in the token, minus any the user has lost since it was
+		// issued. Tokens issued before roles were added to the claims use the current roles.
+		currentRoles := roles.EffectiveRoles(state.Roles, state.IsAdmin)
+		userRoles := currentRoles
+		if claims.Roles != nil {
+			userRoles = roles.Intersect(claims.Roles, currentRoles)
+		}
+
+		// Impersonation tokens only work while the admin behind them may still impersonate
+		if claims.Act != nil {
+			actorState, actorErr := database.GetUserAuthState(database.UserData(database.Client, "Users"), claims.Act.Sub)
+			if actorErr != nil && actorErr != database.ErrCantFindUser {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error checking token status"})
+				c.Abort()
+				return
+			}
+			if actorErr != nil || !roles.HasPermission(roles.EffectiveRoles(actorState.Roles, actorState.IsAdmin), roles.UsersImpersonate) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "impersonation is no longer allowed"})
+				c.Abort()
+				return
+			}
+			c.Set("impersonator_id", claims.Act.Sub)
+		}
+
 		// Set user information in context for use in handlers
 		c.Set("email", claims.Email)
 		c.Set("first_name", claims.First_Name)
 		c.Set("last_name", claims.Last_Name)
 		c.Set("user_id", claims.User_ID)
+		c.Set("roles", userRoles)
+		c.Set("token_id", claims.ID)
+		c.Set("session_id", claims.Session_ID)
+		c.Set("auth_via_cookie", fromCookie)
+		if claims.ExpiresAt != nil {
+			c.Set("token_expires_at", claims.ExpiresAt.Time)
+		}
+
+		if claims.Session_ID != "" {
+			if err := database.TouchSession(database.UserData(database.Client, "Sessions"), claims.Session_ID, c.ClientIP()); err != nil {
+				log.Printf("error updating session %s: %v", claims.Session_ID, err)
+			}
+		}
 
 		// Continue to next handler
 		c.Next()
+
+		if claims.Act != nil {
+			recordImpersonatedRequest(c, claims.Act.Sub, claims.User_ID)
+		}
 	}
 }
 
-// AdminAuth middleware checks if the authenticated user is an admin
-func AdminAuth() gin.HandlerFunc {
+// recordImpersonatedRequest adds an audit entry with both identities for a request made
+// with an impersonation token, including requests that were refused
+func recordImpersonatedRequest(c *gin.Context, actorID, userID string) {
+	err := database.RecordAudit(database.UserData(database.Client, "AuditLogs"), models.AuditLog{
+		Action:    "impersonated_request",
+		Actor_ID:  actorID,
+		Target_ID: userID,
+		IP:        c.ClientIP(),
+		Details: map[string]interface{}{
+			"method": c.Request.Method,
+			"path":   c.Request.URL.Path,
+			"status": c.Writer.Status(),
+		},
+	})
+	if err != nil {
+		log.Printf("error recording impersonated request of %s as %s: %v", actorID, userID, err)
+	}
+}
+
+// BlockImpersonation middleware refuses sensitive actions, such as checkout or a password
+// change, to admins acting as a user
+func BlockImpersonation() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Get user_id from context (set by Authentication middleware)
-		userID, exists := c.Get("user_id")
-		if !exists {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		if c.GetString("impersonator_id") != "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "this action is not allowed while impersonating a user"})
 			c.Abort()
 			return
 		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+		c.Next()
+	}
+}
+
+// CSRFProtection middleware enforces the double-submit CSRF token on state-changing requests
+// authenticated with a session cookie. Requests carrying the token in a header are not
+// sent automatically by browsers and need no CSRF token.
+func CSRFProtection() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
 
-		// Find the user and check if they are admin
-		var user models.User
-		err := database.UserData(database.Client, "Users").FindOne(ctx, bson.M{"user_id": userID}).Decode(&user)
-		if err != nil {
-			if err == mongo.ErrNoDocuments {
-				c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		if !c.GetBool("auth_via_cookie") {
+			c.Next()
+			return
+		}
+
+		if !helpers.ValidCSRFToken(c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "missing or invalid CSRF token"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RejectAPIKeys middleware refuses requests authenticated with an API key. API keys act for
+// integrations, not users, so they only work on admin endpoints their scopes allow.
+func RejectAPIKeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("api_key_id") != "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API keys can only be used on admin endpoints"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// loadRoles returns the roles set by the Authentication middleware. It aborts the request
+// and returns false when they are missing.
+func loadRoles(c *gin.Context) ([]string, bool) {
+	userRoles, exists := c.Get("roles")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		c.Abort()
+		return nil, false
+	}
+	return userRoles.([]string), true
+}
+
+// AdminAuth middleware checks if the authenticated user holds any staff role. API keys
+// pass as long as they have a scope; RequirePermission checks which.
+func AdminAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("api_key_id") != "" {
+			if len(c.GetStringSlice("api_key_scopes")) == 0 {
+				c.JSON(http.StatusForbidden, gin.H{"error": "access denied: API key has no scopes"})
 				c.Abort()
 				return
 			}
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error checking admin status"})
-			c.Abort()
+			c.Next()
 			return
 		}
 
-		// Check if user is admin
-		if !user.IsAdmin {
+		userRoles, ok := loadRoles(c)
+		if !ok {
+			return
+		}
+
+		if !roles.IsStaff(userRoles) {
 			c.JSON(http.StatusForbidden, gin.H{"error": "access denied: admin privileges required"})
 			c.Abort()
 			return
@@ -86,3 +298,30 @@ func AdminAuth() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// RequirePermission middleware checks that the authenticated user's roles, or the scopes of
+// the API key, grant every given permission
+func RequirePermission(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userRoles, ok := loadRoles(c)
+		if !ok {
+			return
+		}
+
+		apiKeyScopes, isAPIKey := c.Get("api_key_scopes")
+		for _, permission := range permissions {
+			granted := roles.HasPermission(userRoles, permission)
+			if isAPIKey {
+				granted = roles.ScopesAllow(apiKeyScopes.([]string), permission)
+			}
+			if !granted {
+				c.JSON(http.StatusForbidden, gin.H{"error": "access denied: missing permission " + permission})
+				c.Abort()
+				return
+			}
+		}
+
+		// Continue to next handler
+		c.Next()
+	}
+}
@@ -0,0 +1,329 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github/akhil/ecommerce-yt/database"
+	"github/akhil/ecommerce-yt/helpers"
+	"github/akhil/ecommerce-yt/roles"
+	"github/akhil/ecommerce-yt/tokens"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func useTestKeys(t *testing.T) {
+	t.Helper()
+
+	dir := t.TempDir()
+	data, err := tokens.GenerateKeyPEM(tokens.AlgEdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "test.pem"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("JWT_KEYS_DIR", dir)
+	t.Setenv("JWT_ACTIVE_KID", "")
+	if err := tokens.LoadKeys(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// useMockClient makes the middleware's database calls go to the mock deployment of mt
+func useMockClient(mt *mtest.T) {
+	previous := database.Client
+	database.Client = mt.Client
+	mt.Cleanup(func() { database.Client = previous })
+}
+
+// caller is what the handler behind the middleware saw of the request
+type caller struct {
+	UserID        string   `json:"user_id"`
+	Roles         []string `json:"roles"`
+	AuthViaCookie bool     `json:"auth_via_cookie"`
+}
+
+// run sends req through middleware to a handler that reports the caller
+func run(req *http.Request, middleware ...gin.HandlerFunc) (*httptest.ResponseRecorder, caller) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	handlers := append(middleware, func(c *gin.Context) {
+		c.JSON(http.StatusOK, caller{c.GetString("user_id"), c.GetStringSlice("roles"), c.GetBool("auth_via_cookie")})
+	})
+	router.Any("/", handlers...)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var seen caller
+	if w.Code == http.StatusOK {
+		json.Unmarshal(w.Body.Bytes(), &seen)
+	}
+	return w, seen
+}
+
+func bearer(token string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+// notRevoked answers the revocation store lookup with no entries
+func notRevoked() bson.D {
+	return mtest.CreateCursorResponse(0, "test.RevokedTokens", mtest.FirstBatch)
+}
+
+func userState(userID string, tokenVersion int, userRoles ...string) bson.D {
+	return mtest.CreateCursorResponse(0, "test.Users", mtest.FirstBatch, bson.D{
+		{Key: "user_id", Value: userID},
+		{Key: "roles", Value: userRoles},
+		{Key: "token_version", Value: tokenVersion},
+	})
+}
+
+func TestAuthenticationAcceptsOnlyAccessTokens(t *testing.T) {
+	useTestKeys(t)
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	access, refresh, err := tokens.TokenGenerator("jane@example.com", "Jane", "Doe", "user-types", "", []string{roles.Customer}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verification, err := tokens.GenerateEmailVerificationToken("user-types", "jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfaPending, err := tokens.GenerateMFAToken("user-types", tokens.MFAPendingTokenType, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"access token", access, http.StatusOK},
+		{"refresh token", refresh, http.StatusUnauthorized},
+		{"email verification token", verification, http.StatusUnauthorized},
+		// A password alone must not get past the second factor
+		{"MFA pending token", mfaPending, http.StatusUnauthorized},
+		{"malformed token", "not-a-jwt", http.StatusUnauthorized},
+		{"no token", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			useMockClient(mt)
+			database.InvalidateUserAuthState("user-types")
+			mt.AddMockResponses(notRevoked(), userState("user-types", 0, roles.Customer))
+
+			req := bearer(tt.token)
+			if tt.token == "" {
+				req.Header.Del("Authorization")
+			}
+			w, seen := run(req, Authentication())
+			if w.Code != tt.want {
+				mt.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.want == http.StatusOK && seen.UserID != "user-types" {
+				mt.Errorf("user_id = %q, want user-types", seen.UserID)
+			}
+			if tt.want != http.StatusOK && len(mt.GetAllStartedEvents()) != 0 {
+				mt.Error("a rejected token was looked up in the database")
+			}
+		})
+	}
+}
+
+func TestAuthenticationRejectsRevokedTokens(t *testing.T) {
+	useTestKeys(t)
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	access, _, err := tokens.TokenGenerator("jane@example.com", "Jane", "Doe", "user-revoked", "", []string{roles.Support}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mt.Run("logged out token", func(mt *mtest.T) {
+		useMockClient(mt)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "test.RevokedTokens", mtest.FirstBatch, bson.D{{Key: "n", Value: 1}}))
+
+		if w, _ := run(bearer(access), Authentication()); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "revoked") {
+			mt.Errorf("answer = %d %s, want %d token has been revoked", w.Code, w.Body, http.StatusUnauthorized)
+		}
+	})
+
+	mt.Run("logged out from all devices", func(mt *mtest.T) {
+		useMockClient(mt)
+		database.InvalidateUserAuthState("user-revoked")
+		mt.AddMockResponses(notRevoked(), userState("user-revoked", 2, roles.Support))
+
+		if w, _ := run(bearer(access), Authentication()); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "revoked") {
+			mt.Errorf("answer = %d %s, want %d token has been revoked", w.Code, w.Body, http.StatusUnauthorized)
+		}
+	})
+
+	mt.Run("demoted since the token was issued", func(mt *mtest.T) {
+		useMockClient(mt)
+		database.InvalidateUserAuthState("user-revoked")
+		mt.AddMockResponses(notRevoked(), userState("user-revoked", 1, roles.Customer))
+
+		w, seen := run(bearer(access), Authentication())
+		if w.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+		}
+		// The token still names support, but the user no longer holds it
+		if len(seen.Roles) != 0 {
+			mt.Errorf("roles = %v, want none", seen.Roles)
+		}
+	})
+}
+
+func TestExtractToken(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     map[string]string
+		cookie     string
+		want       string
+		fromCookie bool
+	}{
+		{"bearer", map[string]string{"Authorization": "Bearer abc"}, "", "abc", false},
+		{"scheme is case-insensitive", map[string]string{"Authorization": "bearer  abc "}, "", "abc", false},
+		{"legacy header", map[string]string{"token": "abc"}, "", "abc", false},
+		{"header wins over cookie", map[string]string{"Authorization": "Bearer abc"}, "def", "abc", false},
+		{"cookie", nil, "def", "def", true},
+		// Basic credentials aren't a token; fall through to the other sources
+		{"other scheme", map[string]string{"Authorization": "Basic abc"}, "", "", false},
+		{"nothing", nil, "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+			for name, value := range tt.header {
+				c.Request.Header.Set(name, value)
+			}
+			if tt.cookie != "" {
+				c.Request.AddCookie(&http.Cookie{Name: helpers.AccessTokenCookie, Value: tt.cookie})
+			}
+
+			token, fromCookie := extractToken(c)
+			if token != tt.want || fromCookie != tt.fromCookie {
+				t.Errorf("extractToken() = (%q, %v), want (%q, %v)", token, fromCookie, tt.want, tt.fromCookie)
+			}
+		})
+	}
+}
+
+func TestCSRFProtection(t *testing.T) {
+	authenticate := func(viaCookie bool) gin.HandlerFunc {
+		return func(c *gin.Context) { c.Set("auth_via_cookie", viaCookie) }
+	}
+
+	tests := []struct {
+		name      string
+		method    string
+		viaCookie bool
+		cookie    string
+		header    string
+		want      int
+	}{
+		{"cookie session with matching token", http.MethodPost, true, "csrf-1", "csrf-1", http.StatusOK},
+		{"cookie session without token", http.MethodPost, true, "csrf-1", "", http.StatusForbidden},
+		{"cookie session with other token", http.MethodDelete, true, "csrf-1", "csrf-2", http.StatusForbidden},
+		{"header without cookie", http.MethodPut, true, "", "csrf-1", http.StatusForbidden},
+		{"safe method", http.MethodGet, true, "", "", http.StatusOK},
+		// Browsers don't attach Authorization headers on their own, so there's nothing to forge
+		{"bearer token", http.MethodPost, false, "", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/", nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: helpers.CSRFCookie, Value: tt.cookie})
+			}
+			if tt.header != "" {
+				req.Header.Set(helpers.CSRFHeader, tt.header)
+			}
+
+			if w, _ := run(req, authenticate(tt.viaCookie), CSRFProtection()); w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	asUser := func(userRoles ...string) gin.HandlerFunc {
+		return func(c *gin.Context) { c.Set("roles", userRoles) }
+	}
+	asKey := func(scopes ...string) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			c.Set("roles", []string{})
+			c.Set("api_key_id", "key-1")
+			c.Set("api_key_scopes", scopes)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		caller      gin.HandlerFunc
+		permissions []string
+		want        int
+	}{
+		{"role grants it", asUser(roles.CatalogManager), []string{roles.ProductsWrite}, http.StatusOK},
+		{"role doesn't grant it", asUser(roles.CatalogManager), []string{roles.OrdersRead}, http.StatusForbidden},
+		{"every permission is needed", asUser(roles.CatalogManager), []string{roles.ProductsRead, roles.OrdersRead}, http.StatusForbidden},
+		{"superadmin", asUser(roles.SuperAdmin), []string{roles.APIKeysManage}, http.StatusOK},
+		{"customer", asUser(roles.Customer), []string{roles.ProductsRead}, http.StatusForbidden},
+		{"not authenticated", func(c *gin.Context) {}, []string{roles.ProductsRead}, http.StatusUnauthorized},
+		{"key scope", asKey(roles.ProductsRead), []string{roles.ProductsRead}, http.StatusOK},
+		{"key without the scope", asKey(roles.ProductsRead), []string{roles.ProductsWrite}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w, _ := run(httptest.NewRequest(http.MethodGet, "/", nil), tt.caller, RequirePermission(tt.permissions...)); w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	tests := []struct {
+		name   string
+		values map[string]interface{}
+		want   int
+	}{
+		{"staff", map[string]interface{}{"roles": []string{roles.Support}}, http.StatusOK},
+		{"customer", map[string]interface{}{"roles": []string{roles.Customer}}, http.StatusForbidden},
+		{"unknown role", map[string]interface{}{"roles": []string{"owner"}}, http.StatusForbidden},
+		{"key with scopes", map[string]interface{}{"roles": []string{}, "api_key_id": "key-1", "api_key_scopes": []string{roles.OrdersRead}}, http.StatusOK},
+		{"key without scopes", map[string]interface{}{"roles": []string{}, "api_key_id": "key-1", "api_key_scopes": []string{}}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := func(c *gin.Context) {
+				for key, value := range tt.values {
+					c.Set(key, value)
+				}
+			}
+			w, seen := run(httptest.NewRequest(http.MethodGet, "/", nil), set, AdminAuth())
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+			if w.Code == http.StatusOK && tt.values["api_key_id"] == nil && !reflect.DeepEqual(seen.Roles, tt.values["roles"]) {
+				t.Errorf("roles = %v, want %v", seen.Roles, tt.values["roles"])
+			}
+		})
+	}
+}
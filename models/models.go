@@ -7,21 +7,35 @@ import (
 )
 
 type User struct {
-	ID              primitive.ObjectID `json:"_id" bson:"_id"`
-	First_Name      *string            `json:"first_name" validate:"required,min=2,max=30"`
-	Last_Name       *string            `json:"last_name" validate:"required,min=2,max=30"`
-	Password        *string            `json:"password" validate:"required,min=6"`
-	Email           *string            `json:"email" validate:"email,required"`
-	Phone           *string            `json:"phone" validate:"required"`
-	Token           *string            `json:"token"`
-	Refresh_Token   *string            `json:"refresh_token"`
-	Created_At      time.Time          `json:"created_at"`
-	Updated_At      time.Time          `json:"updated_at"`
-	User_ID         string             `json:"user_id"`
-	IsAdmin         bool               `json:"is_admin" bson:"is_admin"`
-	User_Cart       []ProductUser      `json:"user_cart" bson:"user_cart"`
-	Address_Details []Address          `json:"address_details" bson:"address_details"`
-	Order_Status    []Order            `json:"order_status" bson:"order_status"`
+	ID                 primitive.ObjectID `json:"_id" bson:"_id"`
+	First_Name         *string            `json:"first_name" validate:"required,min=2,max=30"`
+	Last_Name          *string            `json:"last_name" validate:"required,min=2,max=30"`
+	Password           *string            `json:"password" validate:"required"`
+	Email              *string            `json:"email" validate:"email,required"`
+	Email_Verified     bool               `json:"email_verified" bson:"email_verified"`
+	Email_Verified_At  *time.Time         `json:"email_verified_at,omitempty" bson:"email_verified_at,omitempty"`
+	Phone              *string            `json:"phone" validate:"required"`
+	Phone_Verified     bool               `json:"phone_verified" bson:"phone_verified"`
+	Phone_Verified_At  *time.Time         `json:"phone_verified_at,omitempty" bson:"phone_verified_at,omitempty"`
+	Pending_Email      *string            `json:"-" bson:"pending_email,omitempty"`
+	Token              *string            `json:"-"`
+	Refresh_Token      *string            `json:"-"`
+	Token_Version      int                `json:"-" bson:"token_version"`
+	Created_At         time.Time          `json:"created_at"`
+	Updated_At         time.Time          `json:"updated_at"`
+	User_ID            string             `json:"user_id"`
+	IsAdmin            bool               `json:"is_admin" bson:"is_admin"`
+	Roles              []string           `json:"roles" bson:"roles"`
+	Deleted_At         *time.Time         `json:"-" bson:"deleted_at,omitempty"`
+	MFA_Enabled        bool               `json:"mfa_enabled" bson:"mfa_enabled"`
+	MFA_Secret         *string            `json:"-" bson:"mfa_secret,omitempty"`
+	MFA_Pending_Secret *string            `json:"-" bson:"mfa_pending_secret,omitempty"`
+	MFA_Recovery_Codes []string           `json:"-" bson:"mfa_recovery_codes,omitempty"`
+	MFA_Last_Step      int64              `json:"-" bson:"mfa_last_step,omitempty"`
+	Identities         []ExternalIdentity `json:"-" bson:"identities,omitempty"`
+	User_Cart          []ProductUser      `json:"user_cart" bson:"user_cart"`
+	Address_Details    []Address          `json:"address_details" bson:"address_details"`
+	Order_Status       []Order            `json:"order_status" bson:"order_status"`
 }
 
 type Product struct {
@@ -30,6 +44,41 @@ type Product struct {
 	Price        *uint64            `json:"price"`
 	Rating       *uint8             `json:"rating"`
 	Image        *string            `json:"image"`
+	Stock        *int64             `json:"stock" bson:"stock" validate:"omitempty,min=0"`
+	// Option axes such as size or color. Products with variants are sold by variant; their
+	// price is the lowest variant price and their stock the sum of the variant stocks.
+	Options     []ProductOption     `json:"options,omitempty" bson:"options,omitempty" validate:"omitempty,max=5,dive"`
+	Variants    []ProductVariant    `json:"variants,omitempty" bson:"variants,omitempty" validate:"omitempty,max=100,dive"`
+	Category_ID *primitive.ObjectID `json:"category_id,omitempty" bson:"category_id,omitempty"`
+	Tags        []string            `json:"tags,omitempty" bson:"tags,omitempty" validate:"omitempty,max=20,dive,required,max=30"`
+	Archived_At *time.Time          `json:"archived_at,omitempty" bson:"archived_at,omitempty"`
+}
+
+// Category groups products in a tree. Ancestors lists the ids of the parent categories from
+// the root down, so a category's descendants can be found with a single query.
+type Category struct {
+	Category_ID primitive.ObjectID   `json:"category_id" bson:"category_id"`
+	Name        string               `json:"name" bson:"name"`
+	Slug        string               `json:"slug" bson:"slug"`
+	Parent_ID   *primitive.ObjectID  `json:"parent_id,omitempty" bson:"parent_id,omitempty"`
+	Ancestors   []primitive.ObjectID `json:"-" bson:"ancestors"`
+	Created_At  time.Time            `json:"created_at" bson:"created_at"`
+	Updated_At  time.Time            `json:"updated_at" bson:"updated_at"`
+}
+
+// ProductOption is an axis along which a product's variants differ, e.g. size with S, M and L
+type ProductOption struct {
+	Name   string   `json:"name" bson:"name" validate:"required,min=1,max=50"`
+	Values []string `json:"values" bson:"values" validate:"required,min=1,max=50,dive,required,max=50"`
+}
+
+// ProductVariant is a purchasable version of a product with one value for each option
+type ProductVariant struct {
+	SKU     string            `json:"sku" bson:"sku" validate:"required,min=1,max=64"`
+	Options map[string]string `json:"options" bson:"options" validate:"required"`
+	Price   *uint64           `json:"price" bson:"price" validate:"required"`
+	Stock   *int64            `json:"stock" bson:"stock" validate:"omitempty,min=0"`
+	Image   *string           `json:"image,omitempty" bson:"image,omitempty"`
 }
 
 type ProductUser struct {
@@ -38,6 +87,9 @@ type ProductUser struct {
 	Price        *int               `json:"price" bson:"price"`
 	Rating       *uint              `json:"rating" bson:"rating"`
 	Image        *string            `json:"image" bson:"image"`
+	// The chosen variant, for products with variants
+	SKU     string            `json:"sku,omitempty" bson:"sku,omitempty"`
+	Options map[string]string `json:"options,omitempty" bson:"options,omitempty"`
 }
 
 type Address struct {
@@ -61,3 +113,111 @@ type Payment struct {
 	Digital bool `json:"digital" bson:"digital"`
 	COD     bool `json:"cod" bson:"cod"`
 }
+
+// AdminInvite is a single-use code that allows one admin account to be created
+type AdminInvite struct {
+	Invite_ID  primitive.ObjectID `json:"invite_id" bson:"invite_id"`
+	Code_Hash  string             `json:"-" bson:"code_hash"`
+	Email      *string            `json:"email,omitempty" bson:"email,omitempty"`
+	Role       string             `json:"role" bson:"role"`
+	Created_By string             `json:"created_by" bson:"created_by"`
+	Created_At time.Time          `json:"created_at" bson:"created_at"`
+	Expires_At time.Time          `json:"expires_at" bson:"expires_at"`
+	Used_By    *string            `json:"used_by,omitempty" bson:"used_by,omitempty"`
+	Used_At    *time.Time         `json:"used_at,omitempty" bson:"used_at,omitempty"`
+	Revoked_By *string            `json:"revoked_by,omitempty" bson:"revoked_by,omitempty"`
+	Revoked_At *time.Time         `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+}
+
+// AuditLog records a security-relevant action for later review
+type AuditLog struct {
+	Audit_ID   primitive.ObjectID     `json:"audit_id" bson:"audit_id"`
+	Action     string                 `json:"action" bson:"action"`
+	Actor_ID   string                 `json:"actor_id,omitempty" bson:"actor_id,omitempty"`
+	Target_ID  string                 `json:"target_id,omitempty" bson:"target_id,omitempty"`
+	IP         string                 `json:"ip,omitempty" bson:"ip,omitempty"`
+	Details    map[string]interface{} `json:"details,omitempty" bson:"details,omitempty"`
+	Created_At time.Time              `json:"created_at" bson:"created_at"`
+}
+
+// PasswordReset is a single-use password reset token; only its hash is stored
+type PasswordReset struct {
+	Token_Hash string     `bson:"token_hash"`
+	User_ID    string     `bson:"user_id"`
+	Created_At time.Time  `bson:"created_at"`
+	Expires_At time.Time  `bson:"expires_at"`
+	Used_At    *time.Time `bson:"used_at,omitempty"`
+}
+
+// LoginAttempt counts recent failed logins for one email address or client IP
+type LoginAttempt struct {
+	Key             string     `json:"key" bson:"key"`
+	Failures        int        `json:"failures" bson:"failures"`
+	Last_Failure_At time.Time  `json:"last_failure_at" bson:"last_failure_at"`
+	Blocked_Until   *time.Time `json:"blocked_until,omitempty" bson:"blocked_until,omitempty"`
+	Expires_At      time.Time  `json:"expires_at" bson:"expires_at"`
+}
+
+// Session is a signed-in device. Its refresh token is rotated on every refresh; only its hash is stored.
+type Session struct {
+	Session_ID         string     `json:"session_id" bson:"session_id"`
+	User_ID            string     `json:"-" bson:"user_id"`
+	Refresh_Token_Hash string     `json:"-" bson:"refresh_token_hash"`
+	User_Agent         string     `json:"user_agent" bson:"user_agent"`
+	IP                 string     `json:"ip" bson:"ip"`
+	Created_At         time.Time  `json:"created_at" bson:"created_at"`
+	Last_Seen_At       time.Time  `json:"last_seen_at" bson:"last_seen_at"`
+	Expires_At         time.Time  `json:"expires_at" bson:"expires_at"`
+	Revoked_At         *time.Time `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+}
+
+// APIKey lets an integration call admin routes without a user login. Only the key's hash is stored.
+type APIKey struct {
+	Key_ID       primitive.ObjectID `json:"key_id" bson:"key_id"`
+	Name         string             `json:"name" bson:"name"`
+	Prefix       string             `json:"prefix" bson:"prefix"`
+	Key_Hash     string             `json:"-" bson:"key_hash"`
+	Scopes       []string           `json:"scopes" bson:"scopes"`
+	Created_By   string             `json:"created_by" bson:"created_by"`
+	Created_At   time.Time          `json:"created_at" bson:"created_at"`
+	Expires_At   *time.Time         `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	Last_Used_At *time.Time         `json:"last_used_at,omitempty" bson:"last_used_at,omitempty"`
+	Last_Used_IP string             `json:"last_used_ip,omitempty" bson:"last_used_ip,omitempty"`
+	Use_Count    int64              `json:"use_count" bson:"use_count"`
+	Revoked_By   *string            `json:"revoked_by,omitempty" bson:"revoked_by,omitempty"`
+	Revoked_At   *time.Time         `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+}
+
+// ExternalIdentity links a user to an account at an OpenID Connect identity provider
+type ExternalIdentity struct {
+	Provider  string    `json:"provider" bson:"provider"`
+	Subject   string    `json:"-" bson:"subject"`
+	Email     string    `json:"email" bson:"email"`
+	Linked_At time.Time `json:"linked_at" bson:"linked_at"`
+}
+
+// OIDCState remembers an OpenID Connect login between the redirect to the identity provider
+// and its callback. It is looked up by the hash of the state parameter.
+type OIDCState struct {
+	State_Hash    string    `bson:"state_hash"`
+	Provider      string    `bson:"provider"`
+	Nonce         string    `bson:"nonce"`
+	Code_Verifier string    `bson:"code_verifier"`
+	Cookie_Mode   bool      `bson:"cookie_mode"`
+	Created_At    time.Time `bson:"created_at"`
+	Expires_At    time.Time `bson:"expires_at"`
+}
+
+// PhoneOTP is a one-time passcode sent by SMS. Only a hash of the code is stored.
+type PhoneOTP struct {
+	OTP_ID     primitive.ObjectID `bson:"otp_id"`
+	Phone      string             `bson:"phone"`
+	Purpose    string             `bson:"purpose"`
+	User_ID    string             `bson:"user_id"`
+	Code_Hash  string             `bson:"code_hash"`
+	Attempts   int                `bson:"attempts"`
+	IP         string             `bson:"ip"`
+	Created_At time.Time          `bson:"created_at"`
+	Expires_At time.Time          `bson:"expires_at"`
+	Used_At    *time.Time         `bson:"used_at,omitempty"`
+}
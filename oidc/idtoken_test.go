@@ -0,0 +1,273 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// fakeIssuer serves the discovery document and the JWKS of an identity provider
+type fakeIssuer struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	keys        []jsonWebKey
+	jwksFetches int
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	t.Helper()
+
+	issuer := &fakeIssuer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(discoveryDocument{
+			Issuer:                issuer.URL,
+			AuthorizationEndpoint: issuer.URL + "/authorize",
+			TokenEndpoint:         issuer.URL + "/token",
+			JWKSURI:               issuer.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		issuer.mu.Lock()
+		defer issuer.mu.Unlock()
+		issuer.jwksFetches++
+		json.NewEncoder(w).Encode(map[string][]jsonWebKey{"keys": issuer.keys})
+	})
+	issuer.Server = httptest.NewServer(mux)
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+// publish adds the public part of key to the JWKS under kid
+func (f *fakeIssuer) publish(kid string, key interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	encode := base64.RawURLEncoding.EncodeToString
+	switch key := key.(type) {
+	case ed25519.PrivateKey:
+		f.keys = append(f.keys, jsonWebKey{Kid: kid, Kty: "OKP", Use: "sig", Crv: "Ed25519", X: encode(key.Public().(ed25519.PublicKey))})
+	case *rsa.PrivateKey:
+		f.keys = append(f.keys, jsonWebKey{Kid: kid, Kty: "RSA", Use: "sig", N: encode(key.N.Bytes()), E: encode(big.NewInt(int64(key.E)).Bytes())})
+	case *ecdsa.PrivateKey:
+		f.keys = append(f.keys, jsonWebKey{Kid: kid, Kty: "EC", Use: "sig", Crv: "P-256", X: encode(key.X.Bytes()), Y: encode(key.Y.Bytes())})
+	}
+}
+
+func signIDToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.Claims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer.publish("ed", edKey)
+	issuer.publish("rsa", rsaKey)
+	issuer.publish("ec", ecKey)
+
+	provider := NewProvider("test", issuer.URL, "client-1", "secret", "http://localhost/callback", nil)
+
+	now := time.Now()
+	claims := func(modify func(*IDTokenClaims)) *IDTokenClaims {
+		c := &IDTokenClaims{
+			Nonce: "nonce-1",
+			Email: "jane@example.com",
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    issuer.URL,
+				Subject:   "subject-1",
+				Audience:  jwt.ClaimStrings{"client-1"},
+				IssuedAt:  jwt.NewNumericDate(now),
+				ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+			},
+		}
+		if modify != nil {
+			modify(c)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"EdDSA", signIDToken(t, jwt.SigningMethodEdDSA, "ed", edKey, claims(nil)), false},
+		{"RS256", signIDToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(nil)), false},
+		{"ES256", signIDToken(t, jwt.SigningMethodES256, "ec", ecKey, claims(nil)), false},
+		{"expired within the leeway", signIDToken(t, jwt.SigningMethodEdDSA, "ed", edKey, claims(func(c *IDTokenClaims) {
+			c.ExpiresAt = jwt.NewNumericDate(now.Add(-30 * time.Second))
+		})), false},
+		{"several audiences, issued to us", signIDToken(t, jwt.SigningMethodEdDSA, "ed", edKey, claims(func(c *IDTokenClaims) {
+			c.Audience = jwt.ClaimStrings{"client-1", "client-2"}
+			c.AuthorizedParty = "client-1"
+		})), false},
+		{"expired", signIDToken(t, jwt.SigningMethodEdDSA, "ed", edKey, claims(func(c *IDTokenClaims) {
+			c.ExpiresAt = jwt.NewNumericDate(now.Add(-2 * time.Minute))
+		})), true},
+		{"no expiry", signIDToken(t, jwt.SigningMethodEdDSA, "ed", edKey, claims(func(c *IDTokenClaims) {
+			c.ExpiresAt = nil
+		})), true},
+		{"other issuer", signIDToken(t, jwt.SigningMethodEdDSA, "ed", edKey, claims(func(c *IDTokenClaims) {
+			c.Issuer = "https://evil.example.com"
+		})), true},
+		{"other audience", signIDToken(t, jwt.SigningMethodEdDSA, "ed", edKey, claims(func(c *IDTokenClaims) {
+			c.Audience = jwt.ClaimStrings{"client-2"}
+		})), true},
+		{"several audiences, issued to another client", signIDToken(t, jwt.SigningMethodEdDSA, "ed", edKey, claims(func(c *IDTokenClaims) {
+			c.Audience = jwt.ClaimStrings{"client-1", "client-2"}
+			c.AuthorizedParty = "client-2"
+		})), true},
+		{"other nonce", signIDToken(t, jwt.SigningMethodEdDSA, "ed", edKey, claims(func(c *IDTokenClaims) {
+			c.Nonce = "nonce-2"
+		})), true},
+		{"no subject", signIDToken(t, jwt.SigningMethodEdDSA, "ed", edKey, claims(func(c *IDTokenClaims) {
+			c.Subject = ""
+		})), true},
+		{"signed by another key", signIDToken(t, jwt.SigningMethodEdDSA, "ed", otherKey, claims(nil)), true},
+		{"unknown kid", signIDToken(t, jwt.SigningMethodEdDSA, "other", otherKey, claims(nil)), true},
+		// Several keys are published, so a token must say which one signed it
+		{"no kid", signIDToken(t, jwt.SigningMethodEdDSA, "", edKey, claims(nil)), true},
+		{"HS256", signIDToken(t, jwt.SigningMethodHS256, "ed", []byte("client secret"), claims(nil)), true},
+		{"unsigned", signIDToken(t, jwt.SigningMethodNone, "ed", jwt.UnsafeAllowNoneSignatureType, claims(nil)), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := provider.VerifyIDToken(context.Background(), tt.token, "nonce-1")
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("VerifyIDToken() accepted the token: %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("VerifyIDToken: %v", err)
+			}
+			if got.Subject != "subject-1" || got.Email != "jane@example.com" {
+				t.Errorf("VerifyIDToken() = %+v, want the token's claims", got)
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenKeyRotation(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	_, oldKey, _ := ed25519.GenerateKey(rand.Reader)
+	_, newKey, _ := ed25519.GenerateKey(rand.Reader)
+	issuer.publish("old", oldKey)
+
+	provider := NewProvider("test", issuer.URL, "client-1", "", "http://localhost/callback", nil)
+	claims := &IDTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer.URL,
+			Subject:   "subject-1",
+			Audience:  jwt.ClaimStrings{"client-1"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+	verify := func(kid string, key ed25519.PrivateKey) error {
+		_, err := provider.VerifyIDToken(context.Background(), signIDToken(t, jwt.SigningMethodEdDSA, kid, key, claims), "")
+		return err
+	}
+
+	// A single published key also verifies tokens without a kid
+	if err := verify("", oldKey); err != nil {
+		t.Fatalf("token without a kid: %v", err)
+	}
+	if err := verify("old", oldKey); err != nil {
+		t.Fatalf("token of the old key: %v", err)
+	}
+
+	issuer.publish("new", newKey)
+	if err := verify("new", newKey); err == nil {
+		t.Error("key published less than a minute after the last fetch was used")
+	}
+	if issuer.jwksFetches != 1 {
+		t.Errorf("JWKS fetched %d times, want 1: unknown kids must not trigger a fetch each", issuer.jwksFetches)
+	}
+
+	provider.keys.fetchedAt = time.Now().Add(-keyRefreshInterval)
+	if err := verify("new", newKey); err != nil {
+		t.Errorf("token of the rotated key: %v", err)
+	}
+	if issuer.jwksFetches != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", issuer.jwksFetches)
+	}
+}
+
+func TestDiscoverRejectsIssuerMismatch(t *testing.T) {
+	issuer := newFakeIssuer(t)
+
+	// The configured issuer differs from the one in the document by a trailing slash only
+	provider := NewProvider("test", issuer.URL+"/", "client-1", "", "http://localhost/callback", nil)
+	if _, err := provider.discover(context.Background()); err == nil {
+		t.Error("discover() accepted a document for another issuer")
+	}
+}
+
+func TestJSONWebKeyPublicKey(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encode := base64.RawURLEncoding.EncodeToString
+
+	tests := []struct {
+		name    string
+		key     jsonWebKey
+		wantErr bool
+	}{
+		{"EC", jsonWebKey{Kty: "EC", Crv: "P-256", X: encode(ecKey.X.Bytes()), Y: encode(ecKey.Y.Bytes())}, false},
+		{"EC point off the curve", jsonWebKey{Kty: "EC", Crv: "P-256", X: encode(ecKey.X.Bytes()), Y: encode(ecKey.X.Bytes())}, true},
+		{"unsupported curve", jsonWebKey{Kty: "EC", Crv: "secp256k1", X: encode(ecKey.X.Bytes()), Y: encode(ecKey.Y.Bytes())}, true},
+		{"short Ed25519 key", jsonWebKey{Kty: "OKP", Crv: "Ed25519", X: encode([]byte("short"))}, true},
+		{"X25519 key", jsonWebKey{Kty: "OKP", Crv: "X25519", X: encode(make([]byte, 32))}, true},
+		{"symmetric key", jsonWebKey{Kty: "oct"}, true},
+		{"invalid base64", jsonWebKey{Kty: "RSA", N: "!!", E: "AQAB"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.key.publicKey(); (err != nil) != tt.wantErr {
+				t.Errorf("publicKey() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package passwords
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownHash is returned when a stored hash wasn't produced by any known hasher
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+// Hasher hashes passwords into self-describing strings that record the algorithm and its parameters
+type Hasher interface {
+	// Hash returns the encoded hash of password
+	Hash(password string) (string, error)
+	// Verify reports whether password matches an encoded hash produced by this hasher
+	Verify(password, encoded string) (bool, error)
+	// Recognizes reports whether encoded was produced by this hasher's algorithm
+	Recognizes(encoded string) bool
+	// NeedsRehash reports whether encoded uses other parameters than the hasher's current ones
+	NeedsRehash(encoded string) bool
+}
+
+var (
+	defaultHasher Hasher
+	defaultOnce   sync.Once
+)
+
+// Default returns the hasher selected by PASSWORD_HASHER: "argon2id" (default) or "bcrypt".
+// The environment is read on first use.
+func Default() Hasher {
+	defaultOnce.Do(func() {
+		defaultHasher = FromEnv()
+	})
+	return defaultHasher
+}
+
+// FromEnv builds the hasher selected by PASSWORD_HASHER, with parameters from ARGON2_* or BCRYPT_COST
+func FromEnv() Hasher {
+	switch os.Getenv("PASSWORD_HASHER") {
+	case "bcrypt":
+		return &Bcrypt{Cost: envInt("BCRYPT_COST", DefaultBcryptCost)}
+	default:
+		return &Argon2id{
+			Memory:      uint32(envInt("ARGON2_MEMORY_KIB", DefaultArgon2Memory)),
+			Iterations:  uint32(envInt("ARGON2_ITERATIONS", DefaultArgon2Iterations)),
+			Parallelism: uint8(envInt("ARGON2_PARALLELISM", DefaultArgon2Parallelism)),
+		}
+	}
+}
+
+// knownHashers lists every algorithm stored hashes may use. Verification reads the
+// parameters from the hash, so none are set here.
+var knownHashers = []Hasher{&Argon2id{}, &Bcrypt{}}
+
+// Hash hashes password with the default hasher
+func Hash(password string) (string, error) {
+	return Default().Hash(password)
+}
+
+// Verify checks password against an encoded hash of any known algorithm. needsRehash is
+// true when the password matched but the hash should be replaced by one from Default().
+func Verify(password, encoded string) (ok bool, needsRehash bool, err error) {
+	for _, hasher := range knownHashers {
+		if !hasher.Recognizes(encoded) {
+			continue
+		}
+
+		ok, err = hasher.Verify(password, encoded)
+		if err != nil || !ok {
+			return false, false, err
+		}
+
+		current := Default()
+		return true, !current.Recognizes(encoded) || current.NeedsRehash(encoded), nil
+	}
+	return false, false, ErrUnknownHash
+}
+
+// envInt returns a positive integer environment variable, or fallback when it is unset or invalid
+func envInt(name string, fallback int) int {
+	value, err := strconv.Atoi(strings.TrimSpace(os.Getenv(name)))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,176 @@
+package passwords
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Cheap parameters keep the tests fast; the format is the same as with the defaults
+var testArgon2 = &Argon2id{Memory: 64, Iterations: 1, Parallelism: 1}
+
+// useDefaultHasher makes h the hasher returned by Default() for the rest of the test
+func useDefaultHasher(t *testing.T, h Hasher) {
+	t.Helper()
+
+	previous := Default()
+	defaultHasher = h
+	t.Cleanup(func() { defaultHasher = previous })
+}
+
+func mustHash(t *testing.T, h Hasher, password string) string {
+	t.Helper()
+
+	encoded, err := h.Hash(password)
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	return encoded
+}
+
+func TestArgon2idRoundTrip(t *testing.T) {
+	encoded := mustHash(t, testArgon2, "correct horse")
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("Hash() = %q, want the PHC format with the hasher's parameters", encoded)
+	}
+	if other := mustHash(t, testArgon2, "correct horse"); other == encoded {
+		t.Error("two hashes of the same password are equal; the salt isn't random")
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		encoded  string
+		want     bool
+		wantErr  bool
+	}{
+		{"matching password", "correct horse", encoded, true, false},
+		{"wrong password", "correct horsE", encoded, false, false},
+		{"empty password", "", encoded, false, false},
+		{"truncated hash", "correct horse", encoded[:strings.LastIndex(encoded, "$")], false, true},
+		{"other version", "correct horse", strings.Replace(encoded, "v=19", "v=16", 1), false, true},
+		{"broken parameters", "correct horse", strings.Replace(encoded, "m=64", "m=x", 1), false, true},
+		{"broken salt", "correct horse", strings.Replace(encoded, "p=1$", "p=1$!", 1), false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := testArgon2.Verify(tt.password, tt.encoded)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Verify() error = %v, want error %v", err, tt.wantErr)
+			}
+			if ok != tt.want {
+				t.Errorf("Verify() = %v, want %v", ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestArgon2idVerifiesWithStoredParameters(t *testing.T) {
+	encoded := mustHash(t, testArgon2, "correct horse")
+
+	stronger := &Argon2id{Memory: 128, Iterations: 2, Parallelism: 1}
+	if ok, err := stronger.Verify("correct horse", encoded); err != nil || !ok {
+		t.Errorf("Verify() with other parameters = (%v, %v), want (true, nil)", ok, err)
+	}
+	if !stronger.NeedsRehash(encoded) {
+		t.Error("NeedsRehash() = false for a hash with weaker parameters")
+	}
+	if testArgon2.NeedsRehash(encoded) {
+		t.Error("NeedsRehash() = true for a hash with the current parameters")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	useDefaultHasher(t, testArgon2)
+
+	current := mustHash(t, testArgon2, "correct horse")
+	weaker := mustHash(t, &Argon2id{Memory: 32, Iterations: 1, Parallelism: 1}, "correct horse")
+	legacy := mustHash(t, &Bcrypt{Cost: bcrypt.MinCost}, "correct horse")
+
+	tests := []struct {
+		name            string
+		password        string
+		encoded         string
+		wantOK          bool
+		wantNeedsRehash bool
+		wantErr         error
+	}{
+		{"current hash", "correct horse", current, true, false, nil},
+		{"weaker argon2id parameters", "correct horse", weaker, true, true, nil},
+		// Hashes from before argon2id became the default are upgraded on the next login
+		{"bcrypt hash", "correct horse", legacy, true, true, nil},
+		{"wrong password for bcrypt", "wrong horse", legacy, false, false, nil},
+		{"wrong password for argon2id", "wrong horse", weaker, false, false, nil},
+		{"unknown format", "correct horse", "5f4dcc3b5aa765d61d8327deb882cf99", false, false, ErrUnknownHash},
+		{"empty hash", "correct horse", "", false, false, ErrUnknownHash},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, needsRehash, err := Verify(tt.password, tt.encoded)
+			if err != tt.wantErr {
+				t.Fatalf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+			if ok != tt.wantOK || needsRehash != tt.wantNeedsRehash {
+				t.Errorf("Verify() = (%v, %v), want (%v, %v)", ok, needsRehash, tt.wantOK, tt.wantNeedsRehash)
+			}
+		})
+	}
+}
+
+func TestVerifyWithBcryptDefault(t *testing.T) {
+	useDefaultHasher(t, &Bcrypt{Cost: bcrypt.MinCost})
+
+	tests := []struct {
+		name            string
+		encoded         string
+		wantNeedsRehash bool
+	}{
+		{"current cost", mustHash(t, &Bcrypt{Cost: bcrypt.MinCost}, "correct horse"), false},
+		{"other cost", mustHash(t, &Bcrypt{Cost: bcrypt.MinCost + 1}, "correct horse"), true},
+		{"argon2id hash", mustHash(t, testArgon2, "correct horse"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, needsRehash, err := Verify("correct horse", tt.encoded)
+			if err != nil || !ok {
+				t.Fatalf("Verify() = (%v, %v), want a match", ok, err)
+			}
+			if needsRehash != tt.wantNeedsRehash {
+				t.Errorf("needsRehash = %v, want %v", needsRehash, tt.wantNeedsRehash)
+			}
+		})
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want Hasher
+	}{
+		{"defaults", nil, &Argon2id{Memory: DefaultArgon2Memory, Iterations: DefaultArgon2Iterations, Parallelism: DefaultArgon2Parallelism}},
+		{"argon2id parameters", map[string]string{"ARGON2_MEMORY_KIB": "19456", "ARGON2_ITERATIONS": "2", "ARGON2_PARALLELISM": "1"}, &Argon2id{Memory: 19456, Iterations: 2, Parallelism: 1}},
+		{"invalid parameters fall back", map[string]string{"ARGON2_MEMORY_KIB": "-1", "ARGON2_ITERATIONS": "lots"}, &Argon2id{Memory: DefaultArgon2Memory, Iterations: DefaultArgon2Iterations, Parallelism: DefaultArgon2Parallelism}},
+		{"bcrypt", map[string]string{"PASSWORD_HASHER": "bcrypt"}, &Bcrypt{Cost: DefaultBcryptCost}},
+		{"bcrypt cost", map[string]string{"PASSWORD_HASHER": "bcrypt", "BCRYPT_COST": "10"}, &Bcrypt{Cost: 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"PASSWORD_HASHER", "BCRYPT_COST", "ARGON2_MEMORY_KIB", "ARGON2_ITERATIONS", "ARGON2_PARALLELISM"} {
+				t.Setenv(name, tt.env[name])
+			}
+
+			switch got := FromEnv().(type) {
+			case *Argon2id:
+				if want, ok := tt.want.(*Argon2id); !ok || *got != *want {
+					t.Errorf("FromEnv() = %+v, want %+v", got, tt.want)
+				}
+			case *Bcrypt:
+				if want, ok := tt.want.(*Bcrypt); !ok || *got != *want {
+					t.Errorf("FromEnv() = %+v, want %+v", got, tt.want)
+				}
+			}
+		})
+	}
+}
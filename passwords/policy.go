@@ -0,0 +1,177 @@
+package passwords
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// breachedPasswords is the bundled list of common breached passwords
+//
+//go:embed breached.txt
+var breachedPasswords string
+
+// Violation is a password policy rule that a password breaks
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Policy is the set of rules a new password must satisfy
+type Policy struct {
+	MinLength     int
+	MaxLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// RejectPersonalInfo refuses passwords that contain the user's email or name
+	RejectPersonalInfo bool
+	// Blocklist holds lowercased passwords that are refused outright
+	Blocklist map[string]struct{}
+}
+
+var (
+	defaultPolicy     *Policy
+	defaultPolicyOnce sync.Once
+)
+
+// DefaultPolicy returns the policy configured through the environment, read on first use
+func DefaultPolicy() *Policy {
+	defaultPolicyOnce.Do(func() {
+		defaultPolicy = PolicyFromEnv()
+	})
+	return defaultPolicy
+}
+
+// PolicyFromEnv builds a policy from the PASSWORD_* environment variables. The bundled
+// breached password list is always used; PASSWORD_BLOCKLIST_FILE adds to it.
+func PolicyFromEnv() *Policy {
+	policy := &Policy{
+		MinLength:          envInt("PASSWORD_MIN_LENGTH", 8),
+		MaxLength:          envInt("PASSWORD_MAX_LENGTH", 128),
+		RequireUpper:       envBool("PASSWORD_REQUIRE_UPPER", true),
+		RequireLower:       envBool("PASSWORD_REQUIRE_LOWER", true),
+		RequireDigit:       envBool("PASSWORD_REQUIRE_DIGIT", true),
+		RequireSymbol:      envBool("PASSWORD_REQUIRE_SYMBOL", false),
+		RejectPersonalInfo: envBool("PASSWORD_REJECT_PERSONAL_INFO", true),
+		Blocklist:          make(map[string]struct{}),
+	}
+
+	addToBlocklist(policy.Blocklist, strings.NewReader(breachedPasswords))
+
+	if path := os.Getenv("PASSWORD_BLOCKLIST_FILE"); path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			log.Printf("error opening password blocklist %s: %v", path, err)
+		} else {
+			addToBlocklist(policy.Blocklist, file)
+			file.Close()
+		}
+	}
+
+	return policy
+}
+
+// addToBlocklist adds one password per line; empty lines and lines starting with # are skipped
+func addToBlocklist(blocklist map[string]struct{}, r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		blocklist[strings.ToLower(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("error reading password blocklist: %v", err)
+	}
+}
+
+// Check returns every rule password breaks; it is empty when the password is acceptable.
+// personalInfo holds the user's email and names.
+func (p *Policy) Check(password string, personalInfo ...string) []Violation {
+	var violations []Violation
+
+	length := len([]rune(password))
+	if length < p.MinLength {
+		violations = append(violations, Violation{"min_length", fmt.Sprintf("password must be at least %d characters long", p.MinLength)})
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, Violation{"max_length", fmt.Sprintf("password must be at most %d characters long", p.MaxLength)})
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+	if p.RequireUpper && !hasUpper {
+		violations = append(violations, Violation{"uppercase", "password must contain an uppercase letter"})
+	}
+	if p.RequireLower && !hasLower {
+		violations = append(violations, Violation{"lowercase", "password must contain a lowercase letter"})
+	}
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, Violation{"digit", "password must contain a digit"})
+	}
+	if p.RequireSymbol && !hasSymbol {
+		violations = append(violations, Violation{"symbol", "password must contain a symbol"})
+	}
+
+	lower := strings.ToLower(password)
+	if p.RejectPersonalInfo && containsPersonalInfo(lower, personalInfo) {
+		violations = append(violations, Violation{"personal_info", "password must not contain your email address or name"})
+	}
+
+	if _, found := p.Blocklist[lower]; found {
+		violations = append(violations, Violation{"breached", "password is too common and appears in known data breaches"})
+	}
+
+	return violations
+}
+
+// containsPersonalInfo reports whether password contains any value of personalInfo, or the
+// local part of an email among them. Values shorter than 3 characters are ignored.
+func containsPersonalInfo(password string, personalInfo []string) bool {
+	for _, value := range personalInfo {
+		value = strings.ToLower(strings.TrimSpace(value))
+		candidates := []string{value}
+		if at := strings.Index(value, "@"); at >= 0 {
+			candidates = append(candidates, value[:at])
+		}
+
+		for _, candidate := range candidates {
+			if len(candidate) >= 3 && strings.Contains(password, candidate) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// envBool reads a boolean environment variable, or fallback when it is unset or invalid
+func envBool(name string, fallback bool) bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv(name))) {
+	case "true", "1", "yes":
+		return true
+	case "false", "0", "no":
+		return false
+	default:
+		return fallback
+	}
+}
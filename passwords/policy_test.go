@@ -0,0 +1,115 @@
+package passwords
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func rules(violations []Violation) []string {
+	var names []string
+	for _, v := range violations {
+		names = append(names, v.Rule)
+	}
+	return names
+}
+
+func TestPolicyCheck(t *testing.T) {
+	policy := &Policy{
+		MinLength:          8,
+		MaxLength:          16,
+		RequireUpper:       true,
+		RequireLower:       true,
+		RequireDigit:       true,
+		RequireSymbol:      true,
+		RejectPersonalInfo: true,
+		Blocklist:          map[string]struct{}{"password1!": {}},
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		want     []string
+	}{
+		{"acceptable", "Tr0ub4dor&3", nil},
+		{"too short", "Ab1!", []string{"min_length"}},
+		{"too long", "Tr0ub4dor&3Tr0ub4dor&3", []string{"max_length"}},
+		// Length counts characters, not bytes
+		{"multi-byte characters", "Ünïcödé1!", nil},
+		{"no uppercase", "tr0ub4dor&3", []string{"uppercase"}},
+		{"no lowercase", "TR0UB4DOR&3", []string{"lowercase"}},
+		{"no digit", "Troubador&!", []string{"digit"}},
+		{"no symbol", "Tr0ub4dor33", []string{"symbol"}},
+		{"space counts as a symbol", "Tr0ub4dor 3", nil},
+		{"several rules", "abc", []string{"min_length", "uppercase", "digit", "symbol"}},
+		{"contains the email's local part", "Jane.doe#2024", []string{"personal_info"}},
+		{"contains the name", "xXSmithXx#1", []string{"personal_info"}},
+		// The blocklist is matched case-insensitively
+		{"breached", "PASSWORD1!", []string{"lowercase", "breached"}},
+		{"breached, other case", "Password1!", []string{"breached"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rules(policy.Check(tt.password, "jane.doe@example.com", "Jane", "Smith"))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check(%q) = %v, want %v", tt.password, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyCheckDisabledRules(t *testing.T) {
+	policy := &Policy{MinLength: 4}
+	if got := policy.Check("jane", "jane@example.com"); len(got) != 0 {
+		t.Errorf("Check() = %v, want no violations when the rules are off", rules(got))
+	}
+}
+
+func TestContainsPersonalInfo(t *testing.T) {
+	tests := []struct {
+		password     string
+		personalInfo []string
+		want         bool
+	}{
+		{"jane.doe@example.com!", []string{"jane.doe@example.com"}, true},
+		{"xjane.doex", []string{"Jane.Doe@Example.com"}, true},
+		{"smith123", []string{" Smith "}, true},
+		// Values shorter than 3 characters match too many passwords to be refused
+		{"bob-al-99", []string{"al"}, false},
+		{"al@x", []string{"al@x.io"}, false},
+		{"unrelated", []string{"jane@example.com", "Jane", "Smith"}, false},
+		{"anything", nil, false},
+	}
+	for _, tt := range tests {
+		if got := containsPersonalInfo(tt.password, tt.personalInfo); got != tt.want {
+			t.Errorf("containsPersonalInfo(%q, %v) = %v, want %v", tt.password, tt.personalInfo, got, tt.want)
+		}
+	}
+}
+
+func TestAddToBlocklist(t *testing.T) {
+	blocklist := map[string]struct{}{}
+	addToBlocklist(blocklist, strings.NewReader("# comment\n\n  Qwerty123  \nletmein\n"))
+
+	want := map[string]struct{}{"qwerty123": {}, "letmein": {}}
+	if !reflect.DeepEqual(blocklist, want) {
+		t.Errorf("addToBlocklist() = %v, want %v", blocklist, want)
+	}
+}
+
+func TestPolicyFromEnvBlocklist(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "blocklist.txt")
+	if err := os.WriteFile(file, []byte("Company2024!\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PASSWORD_BLOCKLIST_FILE", file)
+
+	policy := PolicyFromEnv()
+	for _, password := range []string{"password123", "company2024!"} {
+		if _, found := policy.Blocklist[password]; !found {
+			t.Errorf("blocklist is missing %q", password)
+		}
+	}
+}
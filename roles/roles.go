@@ -0,0 +1,161 @@
+package roles
+
+import "sort"
+
+// Roles that can be assigned to a user
+const (
+	Customer       = "customer"
+	CatalogManager = "catalog_manager"
+	OrderManager   = "order_manager"
+	Support        = "support"
+	SuperAdmin     = "superadmin"
+)
+
+// Permissions checked by middleware.RequirePermission
+const (
+	ProductsRead     = "products:read"
+	ProductsWrite    = "products:write"
+	OrdersRead       = "orders:read"
+	OrdersWrite      = "orders:write"
+	UsersRead        = "users:read"
+	UsersManage      = "users:manage"
+	UsersImpersonate = "users:impersonate"
+	RolesManage      = "roles:manage"
+	InvitesManage    = "invites:manage"
+	AuditRead        = "audit:read"
+	APIKeysManage    = "api_keys:manage"
+)
+
+// permissions lists every permission that can be granted
+var permissions = []string{
+	ProductsRead, ProductsWrite, OrdersRead, OrdersWrite, UsersRead, UsersManage,
+	UsersImpersonate, RolesManage, InvitesManage, AuditRead, APIKeysManage,
+}
+
+// privileged lists the permissions that create credentials or hand out access. API keys
+// can't hold them, so a leaked key can't be turned into more access.
+var privileged = []string{APIKeysManage, InvitesManage, RolesManage, UsersImpersonate}
+
+// all grants every permission
+const all = "*"
+
+// rolePermissions maps each role to the permissions it grants
+var rolePermissions = map[string][]string{
+	Customer:       {},
+	CatalogManager: {ProductsRead, ProductsWrite},
+	OrderManager:   {OrdersRead, OrdersWrite, ProductsRead},
+	Support:        {UsersRead, UsersImpersonate, OrdersRead, ProductsRead},
+	SuperAdmin:     {all},
+}
+
+// IsValid reports whether role is a known role
+func IsValid(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// All returns every role with the permissions it grants
+func All() map[string][]string {
+	result := make(map[string][]string, len(rolePermissions))
+	for role, permissions := range rolePermissions {
+		result[role] = append([]string{}, permissions...)
+	}
+	return result
+}
+
+// Permissions returns every permission that can be granted
+func Permissions() []string {
+	return append([]string{}, permissions...)
+}
+
+// IsValidPermission reports whether permission is a known permission
+func IsValidPermission(permission string) bool {
+	for _, p := range permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPrivileged reports whether permission creates credentials or hands out access
+func IsPrivileged(permission string) bool {
+	for _, p := range privileged {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// EffectiveRoles returns the roles of a user. Accounts created before roles existed have
+// none; legacy admins are treated as superadmin and everyone else as customer.
+func EffectiveRoles(userRoles []string, isAdmin bool) []string {
+	if len(userRoles) > 0 {
+		return userRoles
+	}
+	if isAdmin {
+		return []string{SuperAdmin}
+	}
+	return []string{Customer}
+}
+
+// HasPermission reports whether any of the roles grants permission
+func HasPermission(userRoles []string, permission string) bool {
+	for _, role := range userRoles {
+		for _, granted := range rolePermissions[role] {
+			if granted == all || granted == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ScopesAllow reports whether a set of API key scopes, which are permission names, includes permission
+func ScopesAllow(scopes []string, permission string) bool {
+	for _, scope := range scopes {
+		if scope == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// IsStaff reports whether the roles include anything beyond customer
+func IsStaff(userRoles []string) bool {
+	for _, role := range userRoles {
+		if role != Customer && IsValid(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// Intersect returns the roles present in both a and b
+func Intersect(a, b []string) []string {
+	result := make([]string, 0, len(a))
+	for _, role := range a {
+		for _, other := range b {
+			if role == other {
+				result = append(result, role)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// Normalize removes duplicates and sorts roles so they compare and store consistently
+func Normalize(userRoles []string) []string {
+	seen := make(map[string]bool, len(userRoles))
+	result := make([]string, 0, len(userRoles))
+	for _, role := range userRoles {
+		if !seen[role] {
+			seen[role] = true
+			result = append(result, role)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,112 @@
+package roles
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEffectiveRoles(t *testing.T) {
+	tests := []struct {
+		name    string
+		roles   []string
+		isAdmin bool
+		want    []string
+	}{
+		{"assigned roles", []string{Support, OrderManager}, false, []string{Support, OrderManager}},
+		{"assigned roles win over the legacy flag", []string{CatalogManager}, true, []string{CatalogManager}},
+		{"legacy admin", nil, true, []string{SuperAdmin}},
+		{"legacy customer", nil, false, []string{Customer}},
+		{"empty roles", []string{}, false, []string{Customer}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EffectiveRoles(tt.roles, tt.isAdmin); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("EffectiveRoles(%v, %v) = %v, want %v", tt.roles, tt.isAdmin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasPermission(t *testing.T) {
+	tests := []struct {
+		name       string
+		roles      []string
+		permission string
+		want       bool
+	}{
+		{"customer can't read products in admin", []string{Customer}, ProductsRead, false},
+		{"catalog manager writes products", []string{CatalogManager}, ProductsWrite, true},
+		{"catalog manager can't read orders", []string{CatalogManager}, OrdersRead, false},
+		{"order manager reads products", []string{OrderManager}, ProductsRead, true},
+		{"order manager can't write products", []string{OrderManager}, ProductsWrite, false},
+		{"support impersonates", []string{Support}, UsersImpersonate, true},
+		{"support can't manage users", []string{Support}, UsersManage, false},
+		{"superadmin has every permission", []string{SuperAdmin}, APIKeysManage, true},
+		{"superadmin has unknown permissions too", []string{SuperAdmin}, "anything:else", true},
+		{"permissions of several roles add up", []string{CatalogManager, Support}, UsersRead, true},
+		{"unknown role grants nothing", []string{"owner"}, ProductsRead, false},
+		{"no roles", nil, ProductsRead, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HasPermission(tt.roles, tt.permission); got != tt.want {
+				t.Errorf("HasPermission(%v, %q) = %v, want %v", tt.roles, tt.permission, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsStaff(t *testing.T) {
+	tests := []struct {
+		roles []string
+		want  bool
+	}{
+		{[]string{Customer}, false},
+		{[]string{"owner"}, false},
+		{nil, false},
+		{[]string{Customer, Support}, true},
+		{[]string{SuperAdmin}, true},
+	}
+	for _, tt := range tests {
+		if got := IsStaff(tt.roles); got != tt.want {
+			t.Errorf("IsStaff(%v) = %v, want %v", tt.roles, got, tt.want)
+		}
+	}
+}
+
+func TestScopesAllow(t *testing.T) {
+	scopes := []string{ProductsRead, OrdersRead}
+	tests := []struct {
+		permission string
+		want       bool
+	}{
+		{ProductsRead, true},
+		{OrdersRead, true},
+		{ProductsWrite, false},
+		// Scopes are exact permissions; the superadmin wildcard can't be granted to a key
+		{"*", false},
+	}
+	for _, tt := range tests {
+		if got := ScopesAllow(scopes, tt.permission); got != tt.want {
+			t.Errorf("ScopesAllow(%v, %q) = %v, want %v", scopes, tt.permission, got, tt.want)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	got := Normalize([]string{Support, CatalogManager, Support})
+	want := []string{CatalogManager, Support}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Normalize() = %v, want %v", got, want)
+	}
+}
+
+func TestIsPrivileged(t *testing.T) {
+	for _, permission := range Permissions() {
+		want := permission == APIKeysManage || permission == InvitesManage ||
+			permission == RolesManage || permission == UsersImpersonate
+		if got := IsPrivileged(permission); got != want {
+			t.Errorf("IsPrivileged(%q) = %v, want %v", permission, got, want)
+		}
+	}
+}
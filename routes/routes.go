@@ -2,6 +2,8 @@ package routes
 
 import (
 	"github/akhil/ecommerce-yt/controllers"
+	"github/akhil/ecommerce-yt/middleware"
+	"github/akhil/ecommerce-yt/roles"
 
 	"github.com/gin-gonic/gin"
 )
@@ -10,16 +12,103 @@ import (
 func UserRoutes(incomingRoutes *gin.Engine) {
 	incomingRoutes.POST("api/v1/users/signup", controllers.SignUp())
 	incomingRoutes.POST("api/v1/users/login", controllers.Login())
-	incomingRoutes.POST("api/v1/admin/signup", controllers.AdminSignUp())
+	incomingRoutes.POST("api/v1/users/login/mfa", controllers.LoginMFA())
+	incomingRoutes.POST("api/v1/users/login/mfa/setup", controllers.LoginMFASetup())
+	incomingRoutes.POST("api/v1/users/login/mfa/activate", controllers.LoginMFAActivate())
+	incomingRoutes.POST("api/v1/users/login/phone", controllers.RequestPhoneLogin())
+	incomingRoutes.POST("api/v1/users/login/phone/verify", controllers.PhoneLogin())
+	incomingRoutes.POST("api/v1/users/refresh", controllers.RefreshToken())
+	incomingRoutes.POST("api/v1/users/password/forgot", controllers.ForgotPassword())
+	incomingRoutes.POST("api/v1/users/password/reset", controllers.ResetPassword())
+	incomingRoutes.GET("api/v1/users/oidc/:provider/login", controllers.OIDCLogin())
+	incomingRoutes.GET("api/v1/users/oidc/:provider/callback", controllers.OIDCCallback())
+	incomingRoutes.GET("api/v1/users/verify-email", controllers.VerifyEmail())
+	incomingRoutes.GET("api/v1/users/me/email/confirm", controllers.ConfirmEmailChange())
+	incomingRoutes.POST("api/v1/admin/signup", controllers.AdminSignUp()) // requires an invite code
 	incomingRoutes.POST("api/v1/admin/login", controllers.AdminLogin())
-	// Search endpoints (public - no authentication required)
+	// Search, product detail and category endpoints (public - no authentication required)
 	incomingRoutes.GET("api/v1/products/search", controllers.SearchProduct())
 	incomingRoutes.GET("api/v1/products/search/query", controllers.SearchProductByQuery())
+	incomingRoutes.GET("api/v1/products/:product_id", controllers.GetProduct())
+	incomingRoutes.GET("api/v1/categories", controllers.ListCategories())
+	incomingRoutes.GET("api/v1/categories/:slug/products", controllers.GetCategoryProducts())
 }
 
-// AdminRoutes sets up admin-related routes (requires authentication and admin privileges)
+// WellKnownRoutes sets up discovery routes (public routes)
+func WellKnownRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/.well-known/jwks.json", controllers.JWKS())
+}
+
+// AuthRoutes sets up session and account routes (requires authentication). Routes that
+// change credentials or account security are closed to impersonation tokens.
+func AuthRoutes(incomingRoutes *gin.RouterGroup) {
+	incomingRoutes.GET("api/v1/users/me", controllers.GetMe())
+	incomingRoutes.PATCH("api/v1/users/me", controllers.UpdateMe())
+	incomingRoutes.DELETE("api/v1/users/me", middleware.BlockImpersonation(), controllers.DeleteMe())
+	incomingRoutes.GET("api/v1/users/me/export", middleware.BlockImpersonation(), controllers.ExportMe())
+	incomingRoutes.POST("api/v1/users/me/password", middleware.BlockImpersonation(), controllers.ChangePassword())
+	incomingRoutes.POST("api/v1/users/me/email", middleware.BlockImpersonation(), controllers.ChangeEmail())
+	incomingRoutes.POST("api/v1/users/me/phone/verify/send", middleware.BlockImpersonation(), controllers.SendPhoneVerification())
+	incomingRoutes.POST("api/v1/users/me/phone/verify", middleware.BlockImpersonation(), controllers.VerifyPhone())
+	incomingRoutes.POST("api/v1/users/logout", controllers.Logout())
+	incomingRoutes.POST("api/v1/users/logout/all", middleware.BlockImpersonation(), controllers.LogoutAll())
+	incomingRoutes.GET("api/v1/users/sessions", controllers.ListSessions())
+	incomingRoutes.DELETE("api/v1/users/sessions/:session_id", middleware.BlockImpersonation(), controllers.RevokeSession())
+	incomingRoutes.POST("api/v1/users/verify-email/resend", controllers.ResendVerificationEmail())
+	incomingRoutes.POST("api/v1/users/mfa/setup", middleware.BlockImpersonation(), controllers.SetupMFA())
+	incomingRoutes.POST("api/v1/users/mfa/activate", middleware.BlockImpersonation(), controllers.ActivateMFA())
+	incomingRoutes.POST("api/v1/users/mfa/disable", middleware.BlockImpersonation(), controllers.DisableMFA())
+	incomingRoutes.POST("api/v1/users/mfa/recovery-codes", middleware.BlockImpersonation(), controllers.RegenerateRecoveryCodes())
+}
+
+// AdminRoutes sets up admin-related routes (requires authentication and a staff role).
+// Each group additionally requires the permissions its endpoints need.
 func AdminRoutes(incomingRoutes *gin.RouterGroup) {
-	incomingRoutes.POST("api/v1/admin/addproduct", controllers.ProductViewerAdmin())
+	catalog := incomingRoutes.Group("", middleware.RequirePermission(roles.ProductsWrite))
+	catalog.POST("api/v1/admin/addproduct", controllers.ProductViewerAdmin())
+	catalog.PUT("api/v1/admin/products/:product_id", controllers.ReplaceProduct())
+	catalog.PATCH("api/v1/admin/products/:product_id", controllers.PatchProduct())
+	catalog.DELETE("api/v1/admin/products/:product_id", controllers.DeleteProduct())
+	catalog.POST("api/v1/admin/products/:product_id/restore", controllers.RestoreProduct())
+	catalog.POST("api/v1/admin/products/:product_id/restock", controllers.RestockProduct())
+	catalog.POST("api/v1/admin/products/:product_id/stock/adjust", controllers.AdjustProductStock())
+	catalog.PUT("api/v1/admin/products/:product_id/options", controllers.SetProductOptions())
+	catalog.POST("api/v1/admin/products/:product_id/variants", controllers.AddProductVariant())
+	catalog.PATCH("api/v1/admin/products/:product_id/variants/:sku", controllers.UpdateProductVariant())
+	catalog.DELETE("api/v1/admin/products/:product_id/variants/:sku", controllers.RemoveProductVariant())
+
+	catalog.POST("api/v1/admin/categories", controllers.CreateCategory())
+	catalog.PATCH("api/v1/admin/categories/:category_id", controllers.UpdateCategory())
+	catalog.DELETE("api/v1/admin/categories/:category_id", controllers.DeleteCategory())
+
+	catalogRead := incomingRoutes.Group("", middleware.RequirePermission(roles.ProductsRead))
+	catalogRead.GET("api/v1/admin/products", controllers.AdminListProducts())
+	catalogRead.GET("api/v1/admin/products/:product_id", controllers.AdminGetProduct())
+
+	invites := incomingRoutes.Group("", middleware.RequirePermission(roles.InvitesManage))
+	invites.POST("api/v1/admin/invites", controllers.CreateAdminInvite())
+	invites.GET("api/v1/admin/invites", controllers.ListAdminInvites())
+	invites.DELETE("api/v1/admin/invites/:invite_id", controllers.RevokeAdminInvite())
+
+	access := incomingRoutes.Group("", middleware.RequirePermission(roles.RolesManage))
+	access.GET("api/v1/admin/roles", controllers.ListRoles())
+	access.PUT("api/v1/admin/users/:user_id/roles", controllers.AssignRoles())
+
+	users := incomingRoutes.Group("", middleware.RequirePermission(roles.UsersManage))
+	users.POST("api/v1/admin/users/:user_id/unlock", controllers.UnlockUser())
+	users.GET("api/v1/admin/users/:user_id/export", controllers.ExportUser())
+	users.DELETE("api/v1/admin/users/:user_id", controllers.DeleteUser())
+
+	impersonation := incomingRoutes.Group("", middleware.RequirePermission(roles.UsersImpersonate))
+	impersonation.POST("api/v1/admin/users/:user_id/impersonate", controllers.ImpersonateUser())
+
+	apiKeys := incomingRoutes.Group("", middleware.RequirePermission(roles.APIKeysManage))
+	apiKeys.POST("api/v1/admin/api-keys", controllers.CreateAPIKey())
+	apiKeys.GET("api/v1/admin/api-keys", controllers.ListAPIKeys())
+	apiKeys.DELETE("api/v1/admin/api-keys/:key_id", controllers.RevokeAPIKey())
+
+	audit := incomingRoutes.Group("", middleware.RequirePermission(roles.AuditRead))
+	audit.GET("api/v1/admin/audit-logs", controllers.ListAuditLogs())
 }
 
 // ProductRoutes sets up product-related routes (requires authentication)
@@ -32,8 +121,8 @@ func CartRoutes(incomingRoutes *gin.RouterGroup, app *controllers.Application) {
 	incomingRoutes.POST("api/v1/cart/add", app.AddToCart())
 	incomingRoutes.DELETE("api/v1/cart/remove", app.RemoveItem())
 	incomingRoutes.GET("api/v1/cart", app.GetItemFromCart())
-	incomingRoutes.POST("api/v1/cart/checkout", app.BuyFromCart())
-	incomingRoutes.POST("api/v1/cart/instantbuy", app.InstantBuy())
+	incomingRoutes.POST("api/v1/cart/checkout", middleware.BlockImpersonation(), app.BuyFromCart())
+	incomingRoutes.POST("api/v1/cart/instantbuy", middleware.BlockImpersonation(), app.InstantBuy())
 }
 
 // AddressRoutes sets up address-related routes (requires authentication)
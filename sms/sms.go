@@ -0,0 +1,54 @@
+// Package sms delivers text messages such as one-time passcodes
+package sms
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SMSSender delivers text messages
+type SMSSender interface {
+	Send(ctx context.Context, to, body string) error
+}
+
+// FromEnv returns the sender configured by the environment. Only the log sender, writing to
+// SMS_OUTBOX_DIR, is built in; an SMS gateway integration implements SMSSender instead.
+func FromEnv() SMSSender {
+	return &LogSender{Dir: os.Getenv("SMS_OUTBOX_DIR")}
+}
+
+// LogSender is meant for local development. It writes every message to a file in Dir,
+// or to the application log when Dir is empty.
+type LogSender struct {
+	Dir string
+}
+
+// Send implements SMSSender
+func (s *LogSender) Send(ctx context.Context, to, body string) error {
+	if s.Dir == "" {
+		log.Printf("sms to %s: %s", to, body)
+		return nil
+	}
+
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return err
+	}
+	content := fmt.Sprintf("To: %s\nDate: %s\n\n%s\n", to, time.Now().Format(time.RFC1123Z), body)
+	name := fmt.Sprintf("%s-%s.txt", time.Now().Format("20060102T150405.000000000"), sanitize(to))
+	return os.WriteFile(filepath.Join(s.Dir, name), []byte(content), 0600)
+}
+
+// sanitize makes a phone number safe to use in a file name
+func sanitize(phone string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' || r == '+' {
+			return r
+		}
+		return '_'
+	}, phone)
+}
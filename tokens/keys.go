@@ -0,0 +1,240 @@
+package tokens
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported signing algorithms
+const (
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// signingKey is a key of the key ring. Keys without a private part can only verify tokens.
+type signingKey struct {
+	KID     string
+	Method  jwt.SigningMethod
+	Private crypto.Signer
+	Public  crypto.PublicKey
+}
+
+// keyRing holds every key that may verify tokens and the one used to sign new tokens
+type keyRing struct {
+	active *signingKey
+	keys   map[string]*signingKey
+}
+
+// ring is loaded by LoadKeys when the server starts
+var ring *keyRing
+
+// errKeysNotLoaded is returned when a token is signed or verified before LoadKeys succeeded
+var errKeysNotLoaded = errors.New("signing keys are not loaded")
+
+// LoadKeys loads the key ring from JWT_KEYS_DIR and JWT_ACTIVE_KID. main calls it at startup,
+// after loading the environment, so a bad key directory stops the server before it serves requests.
+func LoadKeys() error {
+	kr, err := loadKeyRing(os.Getenv("JWT_KEYS_DIR"), os.Getenv("JWT_ACTIVE_KID"))
+	if err != nil {
+		return err
+	}
+	ring = kr
+	return nil
+}
+
+// loadKeyRing reads every <kid>.pem file in dir. Private keys can sign and verify, public
+// keys only verify, which lets a retired key keep validating tokens it signed. Without a
+// directory an ephemeral Ed25519 key is generated, so tokens don't survive a restart.
+func loadKeyRing(dir, activeKID string) (*keyRing, error) {
+	kr := &keyRing{keys: make(map[string]*signingKey)}
+
+	if dir == "" {
+		log.Println("JWT_KEYS_DIR is not set, signing tokens with an ephemeral key; tokens will not survive a restart")
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		key := &signingKey{KID: "ephemeral", Method: jwt.SigningMethodEdDSA, Private: private, Public: private.Public()}
+		kr.keys[key.KID] = key
+		kr.active = key
+		return kr, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var signers []string
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		kid := strings.TrimSuffix(filepath.Base(file), ".pem")
+		key, err := parseKey(kid, data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		kr.keys[kid] = key
+		if key.Private != nil {
+			signers = append(signers, kid)
+		}
+	}
+
+	if len(signers) == 0 {
+		return nil, errors.New("no private key found in " + dir)
+	}
+
+	// Without JWT_ACTIVE_KID the last private key by name signs, so date-prefixed kids rotate naturally
+	if activeKID == "" {
+		activeKID = signers[len(signers)-1]
+	}
+	active, ok := kr.keys[activeKID]
+	if !ok || active.Private == nil {
+		return nil, fmt.Errorf("active key %q has no private key in %s", activeKID, dir)
+	}
+	kr.active = active
+
+	return kr, nil
+}
+
+// parseKey parses a PEM encoded RSA or Ed25519 key
+func parseKey(kid string, data []byte) (*signingKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &signingKey{KID: kid, Method: jwt.SigningMethodRS256, Private: k, Public: k.Public()}, nil
+	case ed25519.PrivateKey:
+		return &signingKey{KID: kid, Method: jwt.SigningMethodEdDSA, Private: k, Public: k.Public()}, nil
+	case *rsa.PublicKey:
+		return &signingKey{KID: kid, Method: jwt.SigningMethodRS256, Public: k}, nil
+	case ed25519.PublicKey:
+		return &signingKey{KID: kid, Method: jwt.SigningMethodEdDSA, Public: k}, nil
+	default:
+		return nil, errors.New("only RSA and Ed25519 keys are supported")
+	}
+}
+
+// sign signs claims with the active key and sets the kid header
+func sign(claims jwt.Claims) (string, error) {
+	if ring == nil {
+		return "", errKeysNotLoaded
+	}
+	active := ring.active
+	token := jwt.NewWithClaims(active.Method, claims)
+	token.Header["kid"] = active.KID
+	return token.SignedString(active.Private)
+}
+
+// verificationKey is the jwt.Keyfunc resolving the kid header against the key ring
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	if ring == nil {
+		return nil, errKeysNotLoaded
+	}
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ring.keys[kid]
+	if !ok {
+		return nil, errors.New("unknown signing key")
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, errors.New("unexpected signing method")
+	}
+	return key.Public, nil
+}
+
+// JWKS returns the public keys of the key ring as a JSON Web Key Set
+func JWKS() map[string]interface{} {
+	kr := ring
+	if kr == nil {
+		kr = &keyRing{}
+	}
+
+	kids := make([]string, 0, len(kr.keys))
+	for kid := range kr.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	keys := make([]map[string]string, 0, len(kids))
+	for _, kid := range kids {
+		key := kr.keys[kid]
+		jwk := map[string]string{
+			"kid": key.KID,
+			"alg": key.Method.Alg(),
+			"use": "sig",
+		}
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk["kty"] = "RSA"
+			jwk["n"] = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk["kty"] = "OKP"
+			jwk["crv"] = "Ed25519"
+			jwk["x"] = base64.RawURLEncoding.EncodeToString(public)
+		}
+		keys = append(keys, jwk)
+	}
+
+	return map[string]interface{}{"keys": keys}
+}
+
+// GenerateKeyPEM creates a new PKCS#8 PEM encoded private key for alg (RS256 or EdDSA)
+func GenerateKeyPEM(alg string) ([]byte, error) {
+	var private interface{}
+	var err error
+	switch alg {
+	case AlgRS256:
+		private, err = rsa.GenerateKey(rand.Reader, 3072)
+	case AlgEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
@@ -0,0 +1,328 @@
+package tokens
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// writeKey stores a new private key for alg as <kid>.pem in dir and returns its public key
+func writeKey(t *testing.T, dir, kid, alg string) crypto.PublicKey {
+	t.Helper()
+
+	data, err := GenerateKeyPEM(alg)
+	if err != nil {
+		t.Fatalf("GenerateKeyPEM(%s): %v", alg, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, kid+".pem"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	key, err := parseKey(kid, data)
+	if err != nil {
+		t.Fatalf("parseKey(%s): %v", kid, err)
+	}
+	return key.Public
+}
+
+// writePublicKey stores public as <kid>.pem in dir, as done for a retired key
+func writePublicKey(t *testing.T, dir, kid string, public crypto.PublicKey) {
+	t.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, kid+".pem"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// useKeyRing loads the keys in dir for the rest of the test
+func useKeyRing(t *testing.T, dir, activeKID string) {
+	t.Helper()
+
+	kr, err := loadKeyRing(dir, activeKID)
+	if err != nil {
+		t.Fatalf("loadKeyRing: %v", err)
+	}
+	previous := ring
+	ring = kr
+	t.Cleanup(func() { ring = previous })
+}
+
+func testClaims() *SignedDetails {
+	return &SignedDetails{
+		User_ID:    "user-1",
+		Token_Type: AccessTokenType,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "token-1",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+}
+
+func TestLoadKeyRing(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "2026-01", AlgEdDSA)
+	writeKey(t, dir, "2026-02", AlgRS256)
+	retired := writeKey(t, t.TempDir(), "2025-12", AlgEdDSA)
+	writePublicKey(t, dir, "2025-12", retired)
+
+	publicOnly := t.TempDir()
+	writePublicKey(t, publicOnly, "2025-12", retired)
+
+	invalid := t.TempDir()
+	if err := os.WriteFile(filepath.Join(invalid, "broken.pem"), []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		dir        string
+		activeKID  string
+		wantActive string
+		wantErr    bool
+	}{
+		{"last private key by name signs", dir, "", "2026-02", false},
+		{"configured active key", dir, "2026-01", "2026-01", false},
+		{"active key without private part", dir, "2025-12", "", true},
+		{"unknown active key", dir, "2027-01", "", true},
+		{"no private key", publicOnly, "", "", true},
+		{"invalid PEM", invalid, "", "", true},
+		{"missing directory", filepath.Join(dir, "missing"), "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kr, err := loadKeyRing(tt.dir, tt.activeKID)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("loadKeyRing() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadKeyRing: %v", err)
+			}
+			if kr.active.KID != tt.wantActive {
+				t.Errorf("active key = %s, want %s", kr.active.KID, tt.wantActive)
+			}
+			if len(kr.keys) != 3 {
+				t.Errorf("key ring holds %d keys, want 3", len(kr.keys))
+			}
+		})
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+	previous := ring
+	t.Cleanup(func() { ring = previous })
+
+	// A directory without keys must stop startup instead of failing on the first login
+	t.Setenv("JWT_KEYS_DIR", t.TempDir())
+	t.Setenv("JWT_ACTIVE_KID", "")
+	ring = nil
+	if err := LoadKeys(); err == nil {
+		t.Fatal("LoadKeys() succeeded without keys")
+	}
+	if _, err := sign(testClaims()); err != errKeysNotLoaded {
+		t.Errorf("sign() without keys = %v, want %v", err, errKeysNotLoaded)
+	}
+
+	dir := t.TempDir()
+	writeKey(t, dir, "2026-01", AlgEdDSA)
+	t.Setenv("JWT_KEYS_DIR", dir)
+	if err := LoadKeys(); err != nil {
+		t.Fatalf("LoadKeys: %v", err)
+	}
+	if ring == nil || ring.active.KID != "2026-01" {
+		t.Fatalf("LoadKeys() didn't load the key ring of %s", dir)
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "ed", AlgEdDSA)
+	writeKey(t, dir, "rsa", AlgRS256)
+
+	for _, kid := range []string{"ed", "rsa"} {
+		t.Run(kid, func(t *testing.T) {
+			useKeyRing(t, dir, kid)
+
+			signed, err := sign(testClaims())
+			if err != nil {
+				t.Fatalf("sign: %v", err)
+			}
+			claims, msg := ValidateToken(signed)
+			if msg != "" {
+				t.Fatalf("ValidateToken: %s", msg)
+			}
+			if claims.User_ID != "user-1" {
+				t.Errorf("User_ID = %q, want user-1", claims.User_ID)
+			}
+
+			token, _, err := jwt.NewParser().ParseUnverified(signed, &SignedDetails{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token.Header["kid"] != kid || token.Method.Alg() != ring.keys[kid].Method.Alg() {
+				t.Errorf("header = %v, want kid %s signed with %s", token.Header, kid, ring.keys[kid].Method.Alg())
+			}
+		})
+	}
+}
+
+func TestRetiredKeyStillVerifies(t *testing.T) {
+	oldDir := t.TempDir()
+	retired := writeKey(t, oldDir, "2025-12", AlgEdDSA)
+	useKeyRing(t, oldDir, "")
+	signed, err := sign(testClaims())
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	// The key is rotated: a new key signs and only the public part of the old one is kept
+	newDir := t.TempDir()
+	writeKey(t, newDir, "2026-01", AlgEdDSA)
+	writePublicKey(t, newDir, "2025-12", retired)
+	useKeyRing(t, newDir, "")
+	if _, msg := ValidateToken(signed); msg != "" {
+		t.Errorf("token of the retired key was rejected: %s", msg)
+	}
+
+	// Once the retired key is removed its tokens stop validating
+	onlyNew := t.TempDir()
+	writeKey(t, onlyNew, "2026-01", AlgEdDSA)
+	useKeyRing(t, onlyNew, "")
+	if _, msg := ValidateToken(signed); msg == "" {
+		t.Error("token of a removed key was accepted")
+	}
+}
+
+func TestValidateTokenRejectsForgedHeaders(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "ed", AlgEdDSA)
+	useKeyRing(t, dir, "")
+
+	unknownKID := jwt.NewWithClaims(jwt.SigningMethodEdDSA, testClaims())
+	unknownKID.Header["kid"] = "other"
+	forgedKID, err := unknownKID.SignedString(ring.active.Private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// HS256 keyed with the public key must not pass for a token of the EdDSA key
+	hmac := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+	hmac.Header["kid"] = "ed"
+	forgedAlg, err := hmac.SignedString([]byte(ring.active.Public.(ed25519.PublicKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	none := jwt.NewWithClaims(jwt.SigningMethodNone, testClaims())
+	none.Header["kid"] = "ed"
+	unsigned, err := none.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"unknown kid", forgedKID},
+		{"HS256 with the public key", forgedAlg},
+		{"unsigned", unsigned},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if claims, msg := ValidateToken(tt.token); msg == "" {
+				t.Errorf("ValidateToken() accepted a forged token: %+v", claims)
+			}
+		})
+	}
+}
+
+// jwkPublicKey decodes a key of the JWKS document the way a relying party does
+func jwkPublicKey(t *testing.T, jwk map[string]string) crypto.PublicKey {
+	t.Helper()
+
+	decode := func(field string) []byte {
+		b, err := base64.RawURLEncoding.DecodeString(jwk[field])
+		if err != nil {
+			t.Fatalf("decoding %s of key %s: %v", field, jwk["kid"], err)
+		}
+		return b
+	}
+	switch jwk["kty"] {
+	case "RSA":
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(decode("n")), E: int(new(big.Int).SetBytes(decode("e")).Int64())}
+	case "OKP":
+		if jwk["crv"] != "Ed25519" {
+			t.Fatalf("unexpected curve %q", jwk["crv"])
+		}
+		return ed25519.PublicKey(decode("x"))
+	default:
+		t.Fatalf("unexpected key type %q", jwk["kty"])
+		return nil
+	}
+}
+
+func TestJWKSRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "ed", AlgEdDSA)
+	writeKey(t, dir, "rsa", AlgRS256)
+	retired := writeKey(t, t.TempDir(), "old", AlgEdDSA)
+	writePublicKey(t, dir, "old", retired)
+
+	useKeyRing(t, dir, "")
+	keys := JWKS()["keys"].([]map[string]string)
+	if len(keys) != 3 {
+		t.Fatalf("JWKS has %d keys, want 3", len(keys))
+	}
+
+	for _, jwk := range keys {
+		kid := jwk["kid"]
+		t.Run(kid, func(t *testing.T) {
+			if jwk["use"] != "sig" {
+				t.Errorf("use = %q, want sig", jwk["use"])
+			}
+			for _, private := range []string{"d", "p", "q"} {
+				if _, ok := jwk[private]; ok {
+					t.Errorf("JWKS exposes private parameter %q", private)
+				}
+			}
+
+			public := jwkPublicKey(t, jwk)
+			if key := ring.keys[kid]; key.Private == nil {
+				// Retired keys only verify; check the published key is the one on disk
+				if !public.(ed25519.PublicKey).Equal(retired) {
+					t.Error("published key doesn't match the retired key")
+				}
+				return
+			}
+
+			useKeyRing(t, dir, kid)
+			signed, err := sign(testClaims())
+			if err != nil {
+				t.Fatalf("sign: %v", err)
+			}
+			_, err = jwt.ParseWithClaims(signed, &SignedDetails{}, func(*jwt.Token) (interface{}, error) {
+				return public, nil
+			}, jwt.WithValidMethods([]string{jwk["alg"]}))
+			if err != nil {
+				t.Errorf("token didn't verify against the published key: %v", err)
+			}
+		})
+	}
+}
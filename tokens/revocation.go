@@ -0,0 +1,61 @@
+package tokens
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RevokedToken is an entry of the revocation store. Entries are removed by a TTL
+// index once the token would have expired anyway.
+type RevokedToken struct {
+	Token_ID   string    `bson:"token_id"`
+	User_ID    string    `bson:"user_id"`
+	Revoked_At time.Time `bson:"revoked_at"`
+	Expires_At time.Time `bson:"expires_at"`
+}
+
+// RevokeTokenID adds a token's jti to the revocation store
+func RevokeTokenID(tokenID, userID string, expiresAt time.Time, revokedCollection *mongo.Collection, ctx context.Context) error {
+	if tokenID == "" {
+		return nil
+	}
+
+	_, err := revokedCollection.InsertOne(ctx, RevokedToken{
+		Token_ID:   tokenID,
+		User_ID:    userID,
+		Revoked_At: time.Now(),
+		Expires_At: expiresAt,
+	})
+	return err
+}
+
+// SessionRevocationID is the revocation store entry that revokes every token of a session
+func SessionRevocationID(sessionID string) string {
+	if sessionID == "" {
+		return ""
+	}
+	return "session:" + sessionID
+}
+
+// IsTokenIDRevoked reports whether any of tokenIDs, such as a token's jti and the
+// SessionRevocationID of its session, is in the revocation store
+func IsTokenIDRevoked(tokenIDs []string, revokedCollection *mongo.Collection, ctx context.Context) (bool, error) {
+	ids := make([]string, 0, len(tokenIDs))
+	for _, id := range tokenIDs {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return false, nil
+	}
+
+	count, err := revokedCollection.CountDocuments(ctx, bson.M{"token_id": bson.M{"$in": ids}})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
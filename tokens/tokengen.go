@@ -2,7 +2,8 @@ package tokens
 
 import (
 	"context"
-	"os"
+	"crypto/rand"
+	"encoding/hex"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -10,43 +11,102 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-var SECRET_KEY string = os.Getenv("SECRET_KEY")
+// Token types carried in SignedDetails.Token_Type
+const (
+	AccessTokenType            = "access"
+	RefreshTokenType           = "refresh"
+	EmailVerificationTokenType = "email_verification"
+	EmailChangeTokenType       = "email_change"
+	// MFA tokens prove the password step of a login and can only finish it
+	MFAPendingTokenType = "mfa_pending"
+	MFASetupTokenType   = "mfa_setup"
+)
 
 type SignedDetails struct {
 	Email      string
 	First_Name string
 	Last_Name  string
 	User_ID    string
+	Token_Type string
+	// Roles held by the user when the token was issued
+	Roles []string
+	// Token_Version must match the user's current version; bumping it revokes every token
+	Token_Version int
+	// Session_ID is the login session (device) the token belongs to; empty for tokens issued before sessions
+	Session_ID string
+	// Act identifies the admin acting as the user in an impersonation token (RFC 8693)
+	Act *Actor `json:"act,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func TokenGenerator(email, firstName, lastName, userID string) (signedToken string, signedRefreshToken string, err error) {
+// Actor is the admin behind an impersonation token
+type Actor struct {
+	Sub   string `json:"sub"`
+	Email string `json:"email,omitempty"`
+}
+
+// newTokenID returns a random identifier used as the jti claim. Tokens are revoked by jti,
+// so a token must never be issued without one.
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Lifetimes of the access and refresh tokens
+const (
+	AccessTokenTTL  = 24 * time.Hour
+	RefreshTokenTTL = 168 * time.Hour // 7 days
+)
+
+// TokenGenerator signs the access and refresh tokens of a login session
+func TokenGenerator(email, firstName, lastName, userID, sessionID string, userRoles []string, tokenVersion int) (signedToken string, signedRefreshToken string, err error) {
+	tokenID, err := newTokenID()
+	if err != nil {
+		return "", "", err
+	}
+	refreshTokenID, err := newTokenID()
+	if err != nil {
+		return "", "", err
+	}
+
 	claims := &SignedDetails{
-		Email:      email,
-		First_Name: firstName,
-		Last_Name:  lastName,
-		User_ID:    userID,
+		Email:         email,
+		First_Name:    firstName,
+		Last_Name:     lastName,
+		User_ID:       userID,
+		Token_Type:    AccessTokenType,
+		Roles:         userRoles,
+		Token_Version: tokenVersion,
+		Session_ID:    sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Local().Add(time.Hour * time.Duration(24))),
+			ID:        tokenID,
+			IssuedAt:  jwt.NewNumericDate(time.Now().Local()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Local().Add(AccessTokenTTL)),
 		},
 	}
 
+	// The refresh token only identifies the user and session; the jti makes every rotated token unique
 	refreshClaims := &SignedDetails{
+		User_ID:       userID,
+		Token_Type:    RefreshTokenType,
+		Token_Version: tokenVersion,
+		Session_ID:    sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Local().Add(time.Hour * time.Duration(168))), // 7 days
+			ID:        refreshTokenID,
+			IssuedAt:  jwt.NewNumericDate(time.Now().Local()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Local().Add(RefreshTokenTTL)),
 		},
 	}
 
-	if SECRET_KEY == "" {
-		SECRET_KEY = "secret"
-	}
-
-	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SECRET_KEY))
+	token, err := sign(claims)
 	if err != nil {
 		return "", "", err
 	}
 
-	refreshToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims).SignedString([]byte(SECRET_KEY))
+	refreshToken, err := sign(refreshClaims)
 	if err != nil {
 		return "", "", err
 	}
@@ -54,18 +114,45 @@ func TokenGenerator(email, firstName, lastName, userID string) (signedToken stri
 	return token, refreshToken, err
 }
 
-// ValidateToken validates and parses a JWT token
-func ValidateToken(signedToken string) (claims *SignedDetails, msg string) {
-	if SECRET_KEY == "" {
-		SECRET_KEY = "secret"
+// GenerateImpersonationToken signs a short-lived access token that lets actor act as the user.
+// It has no session and no refresh token; it ends when it expires or is logged out.
+func GenerateImpersonationToken(email, firstName, lastName, userID string, userRoles []string, tokenVersion int, actor Actor, ttl time.Duration) (string, time.Time, error) {
+	tokenID, err := newTokenID()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiresAt := time.Now().Local().Add(ttl)
+	claims := &SignedDetails{
+		Email:         email,
+		First_Name:    firstName,
+		Last_Name:     lastName,
+		User_ID:       userID,
+		Token_Type:    AccessTokenType,
+		Roles:         userRoles,
+		Token_Version: tokenVersion,
+		Act:           &actor,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			IssuedAt:  jwt.NewNumericDate(time.Now().Local()),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	token, err := sign(claims)
+	if err != nil {
+		return "", time.Time{}, err
 	}
+	return token, expiresAt, nil
+}
 
+// ValidateToken validates and parses a JWT token signed by any key of the key ring
+func ValidateToken(signedToken string) (claims *SignedDetails, msg string) {
 	token, err := jwt.ParseWithClaims(
 		signedToken,
 		&SignedDetails{},
-		func(token *jwt.Token) (interface{}, error) {
-			return []byte(SECRET_KEY), nil
-		},
+		verificationKey,
+		jwt.WithValidMethods([]string{AlgRS256, AlgEdDSA}),
 	)
 
 	if err != nil {
@@ -87,20 +174,182 @@ func ValidateToken(signedToken string) (claims *SignedDetails, msg string) {
 	return claims, msg
 }
 
-func UpdateAllTokens(signedToken, signedRefreshToken, userID string, userCollection *mongo.Collection, ctx context.Context) error {
-	var updateObj bson.D
+// GenerateEmailVerificationToken signs the token embedded in email verification links.
+// It is bound to the address, so it stops working if the user's email changes.
+func GenerateEmailVerificationToken(userID, email string) (string, error) {
+	tokenID, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	claims := &SignedDetails{
+		Email:      email,
+		User_ID:    userID,
+		Token_Type: EmailVerificationTokenType,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			IssuedAt:  jwt.NewNumericDate(time.Now().Local()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Local().Add(time.Hour * time.Duration(24))),
+		},
+	}
+	return sign(claims)
+}
+
+// ValidateEmailVerificationToken validates a token from an email verification link
+func ValidateEmailVerificationToken(signedToken string) (claims *SignedDetails, msg string) {
+	claims, msg = ValidateToken(signedToken)
+	if msg != "" {
+		return nil, msg
+	}
+
+	if claims.Token_Type != EmailVerificationTokenType || claims.User_ID == "" || claims.Email == "" {
+		return nil, "the token is not an email verification token"
+	}
+
+	return claims, msg
+}
+
+// GenerateEmailChangeToken signs the token sent to a new address to confirm an email change
+func GenerateEmailChangeToken(userID, newEmail string) (string, error) {
+	tokenID, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	claims := &SignedDetails{
+		Email:      newEmail,
+		User_ID:    userID,
+		Token_Type: EmailChangeTokenType,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			IssuedAt:  jwt.NewNumericDate(time.Now().Local()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Local().Add(time.Hour * time.Duration(24))),
+		},
+	}
+	return sign(claims)
+}
+
+// ValidateEmailChangeToken validates a token from an email change confirmation link
+func ValidateEmailChangeToken(signedToken string) (claims *SignedDetails, msg string) {
+	claims, msg = ValidateToken(signedToken)
+	if msg != "" {
+		return nil, msg
+	}
+
+	if claims.Token_Type != EmailChangeTokenType || claims.User_ID == "" || claims.Email == "" {
+		return nil, "the token is not an email change token"
+	}
+
+	return claims, msg
+}
+
+// GenerateMFAToken signs the short-lived token returned by the password step of a login.
+// tokenType is MFAPendingTokenType, or MFASetupTokenType when MFA must be enrolled first.
+func GenerateMFAToken(userID, tokenType string, tokenVersion int) (string, error) {
+	tokenID, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	claims := &SignedDetails{
+		User_ID:       userID,
+		Token_Type:    tokenType,
+		Token_Version: tokenVersion,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			IssuedAt:  jwt.NewNumericDate(time.Now().Local()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Local().Add(10 * time.Minute)),
+		},
+	}
+	return sign(claims)
+}
+
+// ValidateMFAToken validates a token returned by the password step of a login
+func ValidateMFAToken(signedToken, tokenType string) (claims *SignedDetails, msg string) {
+	claims, msg = ValidateToken(signedToken)
+	if msg != "" {
+		return nil, msg
+	}
+
+	if claims.Token_Type != tokenType || claims.User_ID == "" {
+		return nil, "the token is not a valid MFA token"
+	}
+
+	return claims, msg
+}
+
+// ValidateRefreshToken validates a refresh token and makes sure it was issued as one
+func ValidateRefreshToken(signedToken string) (claims *SignedDetails, msg string) {
+	claims, msg = ValidateToken(signedToken)
+	if msg != "" {
+		return nil, msg
+	}
+
+	if claims.Token_Type != RefreshTokenType || claims.User_ID == "" {
+		return nil, "the token is not a refresh token"
+	}
+
+	return claims, msg
+}
+
+// ConsumeStoredRefreshToken clears the token pair stored on the user by logins from before
+// sessions existed, provided currentRefreshToken is still the one on record. It returns
+// false when that refresh token has already been used.
+func ConsumeStoredRefreshToken(currentRefreshToken, userID string, userCollection *mongo.Collection, ctx context.Context) (bool, error) {
+	Updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+	result, err := userCollection.UpdateOne(
+		ctx,
+		bson.M{"user_id": userID, "refresh_token": currentRefreshToken},
+		bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "token", Value: nil},
+				{Key: "refresh_token", Value: nil},
+				{Key: "updated_at", Value: Updated_at},
+			}},
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// RevokeAllTokens clears the stored token pair and bumps the user's token version,
+// which invalidates every access and refresh token issued so far
+func RevokeAllTokens(userID string, userCollection *mongo.Collection, ctx context.Context) error {
+	Updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 
-	updateObj = append(updateObj, bson.E{Key: "token", Value: signedToken})
-	updateObj = append(updateObj, bson.E{Key: "refresh_token", Value: signedRefreshToken})
+	_, err := userCollection.UpdateOne(
+		ctx,
+		bson.M{"user_id": userID},
+		bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "token", Value: nil},
+				{Key: "refresh_token", Value: nil},
+				{Key: "updated_at", Value: Updated_at},
+			}},
+			{Key: "$inc", Value: bson.D{
+				{Key: "token_version", Value: 1},
+			}},
+		},
+	)
+	return err
+}
 
+// ClearStoredTokens removes the stored token pair so the current refresh token can no longer be exchanged
+func ClearStoredTokens(userID string, userCollection *mongo.Collection, ctx context.Context) error {
 	Updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-	updateObj = append(updateObj, bson.E{Key: "updated_at", Value: Updated_at})
 
 	_, err := userCollection.UpdateOne(
 		ctx,
 		bson.M{"user_id": userID},
 		bson.D{
-			{Key: "$set", Value: updateObj},
+			{Key: "$set", Value: bson.D{
+				{Key: "token", Value: nil},
+				{Key: "refresh_token", Value: nil},
+				{Key: "updated_at", Value: Updated_at},
+			}},
 		},
 	)
 	return err
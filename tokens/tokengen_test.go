@@ -0,0 +1,159 @@
+package tokens
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// useEphemeralKey signs and verifies the tokens of a test with a fresh key ring
+func useEphemeralKey(t *testing.T) {
+	t.Helper()
+
+	kr, err := loadKeyRing("", "")
+	if err != nil {
+		t.Fatalf("loading ephemeral key ring: %v", err)
+	}
+	previous := ring
+	ring = kr
+	t.Cleanup(func() { ring = previous })
+}
+
+func TestTokenGeneratorRotatesTokenIDs(t *testing.T) {
+	useEphemeralKey(t)
+
+	seen := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		token, refreshToken, err := TokenGenerator("jane@example.com", "Jane", "Doe", "user-1", "session-1", []string{"customer"}, 2)
+		if err != nil {
+			t.Fatalf("TokenGenerator: %v", err)
+		}
+
+		access, msg := ValidateToken(token)
+		if msg != "" {
+			t.Fatalf("ValidateToken(access): %s", msg)
+		}
+		refresh, msg := ValidateRefreshToken(refreshToken)
+		if msg != "" {
+			t.Fatalf("ValidateRefreshToken: %s", msg)
+		}
+
+		if refresh.Session_ID != "session-1" || refresh.User_ID != "user-1" || refresh.Token_Version != 2 {
+			t.Errorf("refresh claims = %+v, want the user, session and token version of the login", refresh)
+		}
+		for _, id := range []string{access.ID, refresh.ID} {
+			if id == "" {
+				t.Fatal("token issued without a jti")
+			}
+			if seen[id] {
+				t.Fatalf("jti %s issued twice", id)
+			}
+			seen[id] = true
+		}
+	}
+}
+
+func TestValidateRefreshToken(t *testing.T) {
+	useEphemeralKey(t)
+
+	token, refreshToken, err := TokenGenerator("jane@example.com", "Jane", "Doe", "user-1", "session-1", nil, 0)
+	if err != nil {
+		t.Fatalf("TokenGenerator: %v", err)
+	}
+	mfaToken, err := GenerateMFAToken("user-1", MFAPendingTokenType, 0)
+	if err != nil {
+		t.Fatalf("GenerateMFAToken: %v", err)
+	}
+	expired, err := sign(&SignedDetails{
+		User_ID:    "user-1",
+		Token_Type: RefreshTokenType,
+		Session_ID: "session-1",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "expired",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+		},
+	})
+	if err != nil {
+		t.Fatalf("signing expired token: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"refresh token", refreshToken, true},
+		{"access token", token, false},
+		{"mfa token", mfaToken, false},
+		{"expired refresh token", expired, false},
+		{"tampered refresh token", refreshToken[:len(refreshToken)-2] + "xx", false},
+		{"garbage", "not-a-token", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, msg := ValidateRefreshToken(tt.token)
+			if tt.valid && msg != "" {
+				t.Fatalf("ValidateRefreshToken() rejected a valid token: %s", msg)
+			}
+			if !tt.valid && (msg == "" || claims != nil) {
+				t.Fatalf("ValidateRefreshToken() accepted an invalid token: %+v", claims)
+			}
+		})
+	}
+}
+
+func TestValidateEmailVerificationToken(t *testing.T) {
+	useEphemeralKey(t)
+
+	verification, err := GenerateEmailVerificationToken("user-1", "jane@example.com")
+	if err != nil {
+		t.Fatalf("GenerateEmailVerificationToken: %v", err)
+	}
+	token, refreshToken, err := TokenGenerator("jane@example.com", "Jane", "Doe", "user-1", "session-1", nil, 0)
+	if err != nil {
+		t.Fatalf("TokenGenerator: %v", err)
+	}
+	expired, err := sign(&SignedDetails{
+		User_ID:    "user-1",
+		Email:      "jane@example.com",
+		Token_Type: EmailVerificationTokenType,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "expired",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+		},
+	})
+	if err != nil {
+		t.Fatalf("signing expired token: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"verification token", verification, true},
+		{"access token", token, false},
+		{"refresh token", refreshToken, false},
+		{"expired verification token", expired, false},
+		{"tampered verification token", verification[:len(verification)-2] + "xx", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, msg := ValidateEmailVerificationToken(tt.token)
+			if tt.valid {
+				if msg != "" {
+					t.Fatalf("ValidateEmailVerificationToken() rejected a valid token: %s", msg)
+				}
+				// The link verifies the address it was sent to, not whatever the account holds later
+				if claims.User_ID != "user-1" || claims.Email != "jane@example.com" {
+					t.Errorf("claims = %+v, want the user and address the link was issued for", claims)
+				}
+				return
+			}
+			if msg == "" || claims != nil {
+				t.Fatalf("ValidateEmailVerificationToken() accepted an invalid token: %+v", claims)
+			}
+		})
+	}
+}